@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"github.com/je4/PictureFS/v2/pkg/PictureFS"
+	"github.com/je4/PictureFS/v2/pkg/imagecollage"
+	"github.com/pkg/errors"
+	"go/format"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"unicode"
+)
+
+// GenerateOptions are the settings of a generated asset bundle
+type GenerateOptions struct {
+	Folder  string
+	Package string
+	// Output is the go file, atlas and layout are written next to it
+	Output  string
+	Border  int64
+	Space   int64
+	Extrude int64
+	Trim    bool
+}
+
+// identifiers of the generated code, which cannot be used for images
+var reservedIdents = map[string]bool{"FS": true, "Image": true, "Name": true}
+
+// identifier returns the exported go identifier of an image path, e.g. sub/trash-can.png -> SubTrashCan
+func identifier(path string) string {
+	path = strings.TrimPrefix(path, "/")
+	path = strings.TrimSuffix(path, filepath.Ext(path))
+	var result strings.Builder
+	var upper = true
+	for _, r := range path {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		result.WriteRune(r)
+	}
+	var ident = result.String()
+	if ident == "" || unicode.IsDigit([]rune(ident)[0]) {
+		ident = "Image" + ident
+	}
+	if reservedIdents[ident] {
+		ident += "Image"
+	}
+	return ident
+}
+
+type bundleImage struct {
+	Ident string
+	Path  string
+}
+
+// bundleImages returns the images of the layout with their identifiers sorted by path.
+// The png aliases of gif images are skipped. Every image declares the function <Ident> and the
+// constant Name<Ident>, which must not collide with the declarations of other images
+func bundleImages(layout *PictureFS.Layout) ([]bundleImage, error) {
+	var paths = map[string]bool{}
+	for _, rect := range layout.Images {
+		paths[rect.Path] = true
+	}
+	var result = []bundleImage{}
+	for _, rect := range layout.Images {
+		if strings.HasSuffix(rect.Path, ".png") && paths[strings.TrimSuffix(rect.Path, ".png")+".gif"] {
+			continue
+		}
+		var path = strings.TrimPrefix(rect.Path, "/")
+		result = append(result, bundleImage{Ident: identifier(path), Path: path})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Path < result[j].Path })
+	// declaration -> image
+	var decls = map[string]string{}
+	for _, img := range result {
+		for _, decl := range []string{img.Ident, "Name" + img.Ident} {
+			if reservedIdents[decl] {
+				return nil, errors.New(fmt.Sprintf("identifier %s of image %s is used by the generated code", decl, img.Path))
+			}
+			if other, ok := decls[decl]; ok {
+				return nil, errors.New(fmt.Sprintf("images %s and %s have the same identifier %s", other, img.Path, decl))
+			}
+			decls[decl] = img.Path
+		}
+	}
+	return result, nil
+}
+
+var bundleTemplate = template.Must(template.New("bundle").Parse(`// Code generated by collage generate; DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"github.com/je4/PictureFS/v2/pkg/PictureFS"
+	"image"
+	_ "image/png"
+	"io/fs"
+	"sync"
+)
+
+//go:embed {{.Atlas}}
+var atlasData []byte
+
+//go:embed {{.Layout}}
+var layoutData []byte
+
+// Name is the path of an image of the bundle
+type Name string
+
+// names of the images of the bundle
+const (
+{{- range .Images}}
+	Name{{.Ident}} Name = {{printf "%q" .Path}}
+{{- end}}
+)
+
+var bundle struct {
+	once sync.Once
+	fs   *PictureFS.FS
+	err  error
+}
+
+// load decodes atlas and layout on first use
+func load() {
+	bundle.once.Do(func() {
+		var layout PictureFS.Layout
+		if bundle.err = json.Unmarshal(layoutData, &layout); bundle.err != nil {
+			return
+		}
+		atlas, _, err := image.Decode(bytes.NewReader(atlasData))
+		if err != nil {
+			bundle.err = err
+			return
+		}
+		bundle.fs, bundle.err = PictureFS.NewFS(atlas, layout)
+	})
+}
+
+type lazyFS struct{}
+
+func (lazyFS) Open(name string) (fs.File, error) {
+	load()
+	if bundle.err != nil {
+		return nil, bundle.err
+	}
+	return bundle.fs.Open(name)
+}
+
+// FS contains the images of the bundle as files. It is created on first use
+var FS fs.FS = lazyFS{}
+
+// Image returns the image name as view of the atlas (nil, if name is not part of the bundle).
+// The bounds are the coordinates of the image within the atlas
+func Image(name Name) image.Image {
+	load()
+	if bundle.err != nil {
+		panic(bundle.err)
+	}
+	img, err := bundle.fs.Image(string(name))
+	if err != nil {
+		return nil
+	}
+	return img
+}
+{{range .Images}}
+// {{.Ident}} returns the image {{.Path}}
+func {{.Ident}}() image.Image {
+	return Image(Name{{.Ident}})
+}
+{{end}}`))
+
+// generateBundle packs the images of the folder and writes atlas, layout and go file
+func generateBundle(opts GenerateOptions) error {
+	var dir = filepath.Dir(opts.Output)
+	var base = strings.TrimSuffix(filepath.Base(opts.Output), filepath.Ext(opts.Output))
+	var atlasFile = base + ".png"
+	var layoutFile = atlasFile + ".json"
+
+	sc := imagecollage.NewSemibranCollage(os.DirFS(opts.Folder), opts.Border, opts.Space, 0, 0, 0, 0)
+	sc.SetExtrude(opts.Extrude)
+	sc.SetTrim(opts.Trim, 0)
+	if err := fs.WalkDir(sc.FS(), ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		// the atlas of a previous run
+		if filepath.Join(opts.Folder, path) == filepath.Join(dir, atlasFile) {
+			return nil
+		}
+		if err := sc.AddImageFile(path); err != nil {
+			log.Printf("%s not an image: %v", path, err)
+		}
+		return nil
+	}); err != nil {
+		return errors.Wrapf(err, "cannot walk folder %s", opts.Folder)
+	}
+	layout, err := sc.Pack()
+	if err != nil {
+		return errors.Wrap(err, "cannot pack images")
+	}
+	img, err := sc.CreateImage(layout)
+	if err != nil {
+		return errors.Wrap(err, "cannot create atlas")
+	}
+	pLayout, err := sc.CreateLayout(layout)
+	if err != nil {
+		return errors.Wrap(err, "cannot create layout")
+	}
+	images, err := bundleImages(pLayout)
+	if err != nil {
+		return err
+	}
+	jsonBytes, err := sc.CreateJSON(layout)
+	if err != nil {
+		return err
+	}
+
+	var code = &bytes.Buffer{}
+	if err := bundleTemplate.Execute(code, struct {
+		Package, Atlas, Layout string
+		Images                 []bundleImage
+	}{
+		Package: opts.Package,
+		Atlas:   atlasFile,
+		Layout:  layoutFile,
+		Images:  images,
+	}); err != nil {
+		return errors.Wrap(err, "cannot execute template")
+	}
+	src, err := format.Source(code.Bytes())
+	if err != nil {
+		return errors.Wrap(err, "cannot format generated code")
+	}
+
+	var atlas = &bytes.Buffer{}
+	if err := imagecollage.EncodePNG(atlas, img); err != nil {
+		return err
+	}
+	for name, data := range map[string][]byte{atlasFile: atlas.Bytes(), layoutFile: jsonBytes, filepath.Base(opts.Output): src} {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0666); err != nil {
+			return errors.Wrapf(err, "cannot write %s", name)
+		}
+	}
+	return nil
+}
+
+// generate writes a go file which embeds the collage of a folder and returns the exit code.
+// Usage with go generate: //go:generate go run github.com/je4/PictureFS/v2/cmd/collage generate -folder icons -output icons.go
+func generate(args []string) int {
+	flags := flag.NewFlagSet("generate", flag.ExitOnError)
+	var basedir = flags.String("folder", ".", "base folder with image contents")
+	var output = flags.String("output", "collage.go", "generated go file (atlas <name>.png and layout <name>.png.json are written next to it)")
+	var pkg = flags.String("package", os.Getenv("GOPACKAGE"), "package name of the generated file (default: $GOPACKAGE)")
+	var border = flags.Int64("border", 0, "width of black border around each image")
+	var space = flags.Int64("space", 1, "empty space around images")
+	var extrude = flags.Int64("extrude", 0, "repeat edge pixels of each image n times")
+	var trim = flags.Bool("trim", false, "trim transparent borders of images")
+	flags.Parse(args)
+
+	if *pkg == "" || flags.NArg() != 0 {
+		fmt.Fprintf(os.Stderr, "usage: %s generate -package name [-folder X] [-output file.go]\n", filepath.Base(os.Args[0]))
+		return 2
+	}
+	if err := generateBundle(GenerateOptions{
+		Folder:  filepath.Clean(*basedir),
+		Package: *pkg,
+		Output:  filepath.Clean(*output),
+		Border:  *border,
+		Space:   *space,
+		Extrude: *extrude,
+		Trim:    *trim,
+	}); err != nil {
+		log.Printf("cannot generate %s: %v", *output, err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"github.com/je4/PictureFS/v2/pkg/PictureFS"
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"image/color"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestIdentifier(t *testing.T) {
+	for path, expected := range map[string]string{
+		"trash.png":              "Trash",
+		"/sub/trash-can.png":     "SubTrashCan",
+		"scan.tif/page-0001.png": "ScanTifPage0001",
+		"3d_box.png":             "Image3dBox",
+		"image.png":              "ImageImage",
+	} {
+		if ident := identifier(path); ident != expected {
+			t.Errorf("%s: expected %s, got %s", path, expected, ident)
+		}
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	src := t.TempDir()
+	out := t.TempDir()
+	green := color.RGBA{G: 255, A: 255}
+	if err := os.MkdirAll(filepath.Join(src, "sub"), 0777); err != nil {
+		t.Fatal(err)
+	}
+	writeTestImage(t, filepath.Join(src, "trash.png"), 10, 8, green)
+	writeTestImage(t, filepath.Join(src, "sub", "trash-can.png"), 6, 6, green)
+
+	if err := generateBundle(GenerateOptions{
+		Folder:  src,
+		Package: "icons",
+		Output:  filepath.Join(out, "icons.go"),
+		Space:   1,
+	}); err != nil {
+		t.Fatalf("cannot generate bundle: %v", err)
+	}
+	for _, name := range []string{"icons.png", "icons.png.json"} {
+		if !fileExists(filepath.Join(out, name)) {
+			t.Fatalf("%s not written", name)
+		}
+	}
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, filepath.Join(out, "icons.go"), nil, parser.ParseComments)
+	if err != nil {
+		t.Fatalf("cannot parse generated code: %v", err)
+	}
+	// the imports are resolved with the export data of the module of the test
+	lookup := func(path string) (io.ReadCloser, error) {
+		export, err := exec.Command("go", "list", "-export", "-f", "{{.Export}}", path).Output()
+		if err != nil {
+			return nil, err
+		}
+		return os.Open(strings.TrimSpace(string(export)))
+	}
+	conf := types.Config{Importer: importer.ForCompiler(fset, "gc", lookup)}
+	pkg, err := conf.Check("icons", fset, []*ast.File{file}, nil)
+	if err != nil {
+		t.Fatalf("cannot type-check generated code: %v", err)
+	}
+	if pkg.Name() != "icons" {
+		t.Errorf("expected package icons, got %s", pkg.Name())
+	}
+	for _, name := range []string{"Trash", "SubTrashCan", "NameTrash", "NameSubTrashCan", "FS", "Image"} {
+		if pkg.Scope().Lookup(name) == nil {
+			t.Errorf("%s not declared", name)
+		}
+	}
+
+	// identical identifiers are rejected
+	writeTestImage(t, filepath.Join(src, "sub_trash_can.png"), 6, 6, green)
+	if err := generateBundle(GenerateOptions{
+		Folder:  src,
+		Package: "icons",
+		Output:  filepath.Join(out, "icons.go"),
+	}); err == nil {
+		t.Error("expected error for duplicate identifier")
+	}
+}
+
+func TestBundleImageCollisions(t *testing.T) {
+	for _, paths := range [][]string{
+		// function NameTrash and constant NameTrash
+		{"/trash.png", "/name-trash.png"},
+		{"/sub/trash-can.png", "/sub_trash_can.png"},
+	} {
+		layout := &PictureFS.Layout{}
+		for _, path := range paths {
+			layout.Images = append(layout.Images, PictureFS.Rect{Path: path, Width: 1, Height: 1})
+		}
+		if _, err := bundleImages(layout); err == nil {
+			t.Errorf("%v: expected collision", paths)
+		}
+	}
+	layout := &PictureFS.Layout{Images: []PictureFS.Rect{
+		{Path: "/trash.png"}, {Path: "/name.png"}, {Path: "/fs.png"}, {Path: "/anim.gif"}, {Path: "/anim.png"},
+	}}
+	images, err := bundleImages(layout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(images) != 4 {
+		t.Errorf("expected 4 images, got %v", images)
+	}
+}
@@ -1,19 +1,24 @@
 package main
 
 import (
+	"archive/zip"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"github.com/je4/PictureFS/v2/pkg/PictureFS"
 	"github.com/je4/PictureFS/v2/pkg/imagecollage"
 	"github.com/pkg/errors"
 	"image"
 	_ "image/gif"
 	_ "image/jpeg"
-	"image/png"
 	_ "image/png"
+	"io"
 	"io/fs"
 	"log"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"sort"
 	"strings"
 )
 
@@ -36,33 +41,461 @@ func loadImage(filePath string) (image.Image, error) {
 	return image, nil
 }
 
+// listFlag is a flag, which can be given several times
+type listFlag []string
+
+func (lf *listFlag) String() string {
+	return strings.Join(*lf, " ")
+}
+
+func (lf *listFlag) Set(value string) error {
+	*lf = append(*lf, value)
+	return nil
+}
+
+// openFolder returns the filesystem of a folder or zip archive and a function to close it
+func openFolder(folder string) (fs.FS, func() error, error) {
+	if strings.ToLower(filepath.Ext(folder)) == ".zip" {
+		zr, err := zip.OpenReader(folder)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "cannot open archive %s", folder)
+		}
+		return zr, zr.Close, nil
+	}
+	return os.DirFS(folder), func() error { return nil }, nil
+}
+
+func fileExists(filePath string) bool {
+	_, err := os.Stat(filePath)
+	return err == nil
+}
+
+// writeScaled writes image and layout of the collage at scale
+func writeScaled(sc *imagecollage.SemibranCollage, layout imagecollage.Layout, scale int64, output string, profile *imagecollage.ICCProfile) error {
+	outimg := PictureFS.ScaledPath(output, int(scale))
+	outjson := PictureFS.ScaledPath(output+".json", int(scale))
+	img, err := sc.CreateScaledImage(layout, scale)
+	if err != nil {
+		return errors.Wrapf(err, "cannot create image of scale %v", scale)
+	}
+	fDst, err := os.Create(outimg)
+	if err != nil {
+		return errors.Wrapf(err, "cannot create %s", outimg)
+	}
+	defer fDst.Close()
+	var wDst io.Writer = fDst
+	if profile != nil {
+		if wDst, err = PictureFS.NewICCWriter(fDst, "png", profile.Data); err != nil {
+			return err
+		}
+	}
+	if err := imagecollage.EncodePNG(wDst, img); err != nil {
+		return err
+	}
+	fmt.Printf("output image written: %s\n", outimg)
+	pLayout, err := sc.CreateScaledLayout(layout, scale)
+	if err != nil {
+		return err
+	}
+	jsonBytes, err := json.Marshal(pLayout)
+	if err != nil {
+		return errors.Wrapf(err, "cannot marshal json of %s", outimg)
+	}
+	if err := os.WriteFile(outjson, jsonBytes, 0666); err != nil {
+		return errors.Wrapf(err, "cannot write %s", outjson)
+	}
+	fmt.Printf("output json written: %s\n", outjson)
+	return nil
+}
+
 func main() {
-	var basedir = flag.String("folder", ".", "base folder with image contents")
+	if len(os.Args) > 1 && os.Args[1] == "verify" {
+		os.Exit(verify(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "serve" {
+		os.Exit(serve(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "generate" {
+		os.Exit(generate(os.Args[2:]))
+	}
+
+	var basedir = flag.String("folder", ".", "base folder or zip archive with image contents")
 	var marginExt = flag.Int64("margin", 20, "empty margin around collage")
 	var border = flag.Int64("border", 2, "width of black border around each image")
 	var space = flag.Int64("space", 2, "empty space around images")
 	var output = flag.String("output", "./collage.png", "name of output image (metadata json file is same with extension .json")
+	var align = flag.Int64("align", 0, "align images to multiples of n pixels")
+	var pot = flag.Bool("pot", false, "power of two width and height of collage")
+	var multiple = flag.Int64("multiple", 0, "width and height of collage are multiples of n")
+	var square = flag.Bool("square", false, "square collage")
+	var canvas = flag.String("canvas", "", "fixed size of collage (<width>x<height>, 0: dimension not fixed)")
+	var extrude = flag.Int64("extrude", 0, "repeat edge pixels of each image n times")
+	var padding = flag.Int64("padding", 0, "transparent padding around each image")
+	var dedup = flag.String("dedup", "none", "pack duplicate images only once (none, exact, perceptual)")
+	var dedupDistance = flag.Int("dedupdistance", 4, "maximum hamming distance of perceptual hashes for -dedup perceptual")
+	var trim = flag.Bool("trim", false, "trim transparent borders of images")
+	var trimThreshold = flag.Uint("trimthreshold", 0, "pixels with alpha <= threshold are trimmed (0..255)")
+	var band = flag.Int("band", 0, "render the collage in bands of n rows to save memory (0: whole image in memory)")
+	var tiles = flag.String("tiles", "", "export tile pyramid (dzi or iiif)")
+	var tileSize = flag.Int("tilesize", 256, "size of pyramid tiles")
+	var tileFormat = flag.String("tileformat", "jpg", "format of pyramid tiles (jpg or png)")
+	var iiifID = flag.String("iiifid", "", "base uri of iiif image service (default: name of output image)")
+	var animation = flag.String("animation", "none", "pack frames of animated gif/png images: none|frames|filmstrip")
+	var colorMode = flag.String("colormode", "auto", "image type of the collage: auto|rgba|gray|paletted (ignored with -band)")
+	var colors = flag.Int("colors", 256, "maximum number of palette colors for paletted collages")
+	var colorSpace = flag.String("colorspace", "srgb", "working color space of the collage: srgb|p3|adobergb|none (none: no color management)")
+	var depth = flag.Int("depth", 8, "bits per channel of the collage: 8|16 (16 bit cannot be combined with -band)")
+	var dpi = flag.Float64("dpi", 96, "resolution of rasterized svg and pdf images")
+	var pdf = flag.Bool("pdf", false, "rasterize the first page of pdf files with pdftoppm (poppler), which has to be installed")
+	var rasterSize = flag.String("rastersize", "", "fit rasterized svg and pdf images into <width>x<height> (0: any size, overrides -dpi)")
+	var uv = flag.String("uv", "", "add normalized texture coordinates to the layout with origin topleft|bottomleft")
+	var uvHalfTexel = flag.Bool("uvhalftexel", false, "inset texture coordinates by half a texel")
+	var presentation = flag.String("presentation", "", "base uri for a iiif presentation manifest of the collage with the image service <uri>/iiif/<output name without scale suffix> of serve (empty: no manifest)")
+	var manifestFile = flag.String("manifest", "", "manifest file for incremental builds (empty: always full build)")
+	var pins, priorities listFlag
+	flag.Var(&pins, "pin", "place image at fixed position of the packing area: <path>=<x>,<y> (repeatable)")
+	flag.Var(&priorities, "priority", "place image before images with lower priority: <path>=<n> (repeatable, default 0)")
+	var groupDirs = flag.Bool("groupdirs", false, "pack the images of every subdirectory into one contiguous region")
+	var scales = flag.String("scales", "", "comma separated scale factors of the source images, e.g. 1,2,3 (writes <name>@<n>x.png for every scale, the sources have the largest scale)")
+	var layoutMode = flag.String("mode", "pack", "layout of the collage: pack|grid|justified|masonry (grid, justified and masonry scale the images and use -space and -margin only)")
+	var cellSize = flag.String("cellsize", "256x256", "cell size of -mode grid (<width>x<height>)")
+	var columns = flag.Int64("columns", 0, "number of columns of -mode grid (0: as many as rows) and masonry (0: roughly square collage)")
+	var width = flag.Int64("width", 1600, "width of the rows of -mode justified (without margin)")
+	var rowHeight = flag.Int64("rowheight", 200, "target row height of -mode justified")
+	var columnWidth = flag.Int64("columnwidth", 256, "column width of -mode masonry")
+	var order = flag.String("order", "name", "order of the images in the layout and placement order of grid, justified and masonry: name|natural|mtime|exif|size|list")
+	var orderFile = flag.String("orderfile", "", "file with one image path per line for -order list")
+	var reverse = flag.Bool("reverse", false, "reverse the order of the images")
 
 	flag.Parse()
 
 	var folder = filepath.ToSlash(filepath.Clean(*basedir))
+	outimg := filepath.Clean(*output)
+	outjson := filepath.Clean(*output) + ".json"
 
 	var collage imagecollage.Collage
 
-	collage = imagecollage.NewSemibranCollage(
-		*basedir,
+	fsys, closeFolder, err := openFolder(folder)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer closeFolder()
+
+	sc := imagecollage.NewSemibranCollage(
+		fsys,
 		*border,
 		*space,
 		*marginExt,
 		*marginExt,
 		*marginExt,
 		*marginExt)
+	collage = sc
 
-	filepath.WalkDir(folder, func(path string, d fs.DirEntry, err error) error {
-		if d.IsDir() {
+	sc.SetAlignment(*align)
+	sc.SetExtrude(*extrude)
+	sc.SetPadding(*padding)
+	sc.SetTrim(*trim, uint8(*trimThreshold))
+	if err := sc.SetUV(*uv, *uvHalfTexel); err != nil {
+		log.Fatal(err)
+	}
+	switch *dedup {
+	case "none":
+	case "exact":
+		sc.SetDedup(imagecollage.DedupExact, 0)
+	case "perceptual":
+		sc.SetDedup(imagecollage.DedupPerceptual, *dedupDistance)
+	default:
+		log.Fatalf("invalid dedup mode %s", *dedup)
+	}
+	switch *animation {
+	case "none":
+	case "frames":
+		sc.SetAnimation(imagecollage.AnimationFrames)
+	case "filmstrip":
+		sc.SetAnimation(imagecollage.AnimationFilmstrip)
+	default:
+		log.Fatalf("invalid animation mode %s", *animation)
+	}
+	// the tile pyramid needs the whole collage image in memory
+	if *tiles != "" && *band > 0 {
+		log.Fatal("-tiles cannot be combined with -band")
+	}
+	if *animation != "none" && *manifestFile != "" {
+		log.Fatal("-animation cannot be combined with -manifest")
+	}
+	mode, err := imagecollage.ParseColorMode(*colorMode)
+	if err != nil {
+		log.Fatal(err)
+	}
+	sc.SetColorMode(mode, *colors)
+	if err := sc.SetDepth(*depth); err != nil {
+		log.Fatal(err)
+	}
+	// bands are encoded with 8 bit per channel
+	if *depth != 8 && *band > 0 {
+		log.Fatal("-depth 16 cannot be combined with -band")
+	}
+	profile, err := imagecollage.ParseColorSpace(*colorSpace)
+	if err != nil {
+		log.Fatal(err)
+	}
+	sc.SetColorSpace(profile)
+	canvasOpts := imagecollage.CanvasOptions{
+		PowerOfTwo: *pot,
+		Multiple:   *multiple,
+		Square:     *square,
+	}
+	if *canvas != "" {
+		if _, err := fmt.Sscanf(*canvas, "%dx%d", &canvasOpts.FixedWidth, &canvasOpts.FixedHeight); err != nil {
+			log.Fatalf("invalid canvas size %s: %v", *canvas, err)
+		}
+	}
+	sc.SetCanvas(canvasOpts)
+	rasterization := imagecollage.Rasterization{DPI: *dpi}
+	if *rasterSize != "" {
+		if _, err := fmt.Sscanf(*rasterSize, "%dx%d", &rasterization.Width, &rasterization.Height); err != nil {
+			log.Fatalf("invalid raster size %s: %v", *rasterSize, err)
+		}
+	}
+	sc.SetRasterization(rasterization)
+	if *pdf {
+		if _, err := exec.LookPath("pdftoppm"); err != nil {
+			log.Fatalf("-pdf needs pdftoppm: %v", err)
+		}
+		sc.AddSource(&imagecollage.PDFSource{})
+	}
+	orderMode, err := imagecollage.ParseOrderMode(*order)
+	if err != nil {
+		log.Fatal(err)
+	}
+	imageOrder := imagecollage.Order{Mode: orderMode, Reverse: *reverse}
+	if orderMode == imagecollage.OrderList {
+		if *orderFile == "" {
+			log.Fatal("-order list needs -orderfile")
+		}
+		if imageOrder.List, err = imagecollage.LoadOrderList(*orderFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+	sc.SetOrder(imageOrder)
+	switch *layoutMode {
+	case "pack":
+	case "grid", "justified", "masonry":
+		if *band > 0 || *manifestFile != "" || *scales != "" {
+			log.Fatalf("-mode %s cannot be combined with -band, -manifest or -scales", *layoutMode)
+		}
+		// options of the packer, which the gallery layouts do not support
+		var packOptions = []string{}
+		for option, set := range map[string]bool{
+			"-pin":       len(pins) > 0,
+			"-priority":  len(priorities) > 0,
+			"-groupdirs": *groupDirs,
+			"-trim":      *trim,
+			"-dedup":     *dedup != "none",
+			"-uv":        *uv != "",
+			"-animation": *animation != "none",
+			"-colormode": *colorMode != "auto",
+			"-depth":     *depth != 8,
+			"-align":     *align != 0,
+			"-pot":       *pot,
+			"-multiple":  *multiple != 0,
+			"-square":    *square,
+			"-canvas":    *canvas != "",
+			"-extrude":   *extrude != 0,
+			"-padding":   *padding != 0,
+		} {
+			if set {
+				packOptions = append(packOptions, option)
+			}
+		}
+		if len(packOptions) > 0 {
+			sort.Strings(packOptions)
+			log.Fatalf("-mode %s cannot be combined with %s", *layoutMode, strings.Join(packOptions, ", "))
+		}
+		switch *layoutMode {
+		case "grid":
+			var cellWidth, cellHeight int64
+			if _, err := fmt.Sscanf(*cellSize, "%dx%d", &cellWidth, &cellHeight); err != nil {
+				log.Fatalf("invalid cell size %s: %v", *cellSize, err)
+			}
+			gc := imagecollage.NewGridCollage(fsys, cellWidth, cellHeight, *columns, *space, *marginExt)
+			gc.SetRasterization(rasterization)
+			gc.SetColorSpace(profile)
+			if *pdf {
+				gc.AddSource(&imagecollage.PDFSource{})
+			}
+			gc.SetOrder(imageOrder)
+			collage = gc
+		case "justified":
+			jc := imagecollage.NewJustifiedCollage(fsys, *width, *rowHeight, *space, *marginExt)
+			jc.SetRasterization(rasterization)
+			jc.SetColorSpace(profile)
+			if *pdf {
+				jc.AddSource(&imagecollage.PDFSource{})
+			}
+			jc.SetOrder(imageOrder)
+			collage = jc
+		case "masonry":
+			mc := imagecollage.NewMasonryCollage(fsys, *columns, *columnWidth, *space, *marginExt)
+			mc.SetRasterization(rasterization)
+			mc.SetColorSpace(profile)
+			if *pdf {
+				mc.AddSource(&imagecollage.PDFSource{})
+			}
+			mc.SetOrder(imageOrder)
+			collage = mc
+		}
+	default:
+		log.Fatalf("invalid mode %s", *layoutMode)
+	}
+	for _, pin := range pins {
+		var x, y int64
+		i := strings.LastIndex(pin, "=")
+		if i < 0 {
+			log.Fatalf("invalid pin %s", pin)
+		}
+		if _, err := fmt.Sscanf(pin[i+1:], "%d,%d", &x, &y); err != nil {
+			log.Fatalf("invalid pin %s: %v", pin, err)
+		}
+		sc.SetPin(filepath.ToSlash(filepath.Clean("/"+pin[:i])), x, y)
+	}
+	for _, priority := range priorities {
+		var p int
+		i := strings.LastIndex(priority, "=")
+		if i < 0 {
+			log.Fatalf("invalid priority %s", priority)
+		}
+		if _, err := fmt.Sscanf(priority[i+1:], "%d", &p); err != nil {
+			log.Fatalf("invalid priority %s: %v", priority, err)
+		}
+		sc.SetPriority(filepath.ToSlash(filepath.Clean("/"+priority[:i])), p)
+	}
+	if *groupDirs {
+		sc.SetGroupFunc(imagecollage.DirectoryGroup)
+	}
+	if *scales != "" {
+		var factors = []int64{}
+		for _, str := range strings.Split(*scales, ",") {
+			var factor int64
+			if _, err := fmt.Sscanf(strings.TrimSpace(str), "%d", &factor); err != nil {
+				log.Fatalf("invalid scale %s: %v", str, err)
+			}
+			factors = append(factors, factor)
+		}
+		if err := sc.SetScales(factors); err != nil {
+			log.Fatal(err)
+		}
+		if *manifestFile != "" {
+			log.Fatal("-scales cannot be combined with -manifest")
+		}
+		// the largest scale is written by the default output
+		var maxScale = sc.Scales()[len(sc.Scales())-1]
+		outimg = PictureFS.ScaledPath(outimg, int(maxScale))
+		outjson = PictureFS.ScaledPath(outjson, int(maxScale))
+	}
+
+	var prevManifest *imagecollage.Manifest
+	var manifest *imagecollage.Manifest
+	if *manifestFile != "" {
+		sortedPins := append([]string{}, pins...)
+		sort.Strings(sortedPins)
+		sortedPriorities := append([]string{}, priorities...)
+		sort.Strings(sortedPriorities)
+		settings := imagecollage.ManifestSettings{
+			Border:        *border,
+			Space:         *space,
+			Margin:        *marginExt,
+			Align:         *align,
+			Extrude:       *extrude,
+			Padding:       *padding,
+			Trim:          *trim,
+			Canvas:        canvasOpts,
+			ColorMode:     *colorMode,
+			Colors:        *colors,
+			Dedup:         *dedup,
+			DedupDistance: *dedupDistance,
+			Pins:          strings.Join(sortedPins, "\n"),
+			Priorities:    strings.Join(sortedPriorities, "\n"),
+			GroupDirs:     *groupDirs,
+			Order:         *order,
+			OrderList:     strings.Join(imageOrder.List, "\n"),
+			Reverse:       *reverse,
+			UV:            *uv,
+			UVHalfTexel:   *uvHalfTexel,
+			Rasterization: rasterization,
+		}
+		if profile != nil {
+			settings.ColorSpace = *colorSpace
+		}
+		if *depth != 8 {
+			settings.Depth = *depth
+		}
+		if *trim {
+			settings.TrimThreshold = uint8(*trimThreshold)
+		}
+		manifest = imagecollage.NewManifest(settings)
+		if _, err := os.Stat(*manifestFile); err == nil {
+			prevManifest, err = imagecollage.LoadManifest(*manifestFile)
+			if err != nil {
+				log.Printf("ignoring manifest: %v", err)
+				prevManifest = nil
+			} else if !prevManifest.SameSettings(settings) {
+				log.Printf("settings changed, ignoring manifest %s", *manifestFile)
+				prevManifest = nil
+			}
+		}
+	}
+
+	// content hash -> path for dedup in manifest mode
+	contentHashes := map[string]string{}
+	fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		imgPath := "/" + path
+		if manifest != nil {
+			var prev *imagecollage.ManifestEntry
+			if prevManifest != nil {
+				prev = prevManifest.Entry(filepath.ToSlash(filepath.Clean(imgPath)))
+			}
+			// rasterized, multi-page and perceptually deduplicated images are decoded on every run
+			if sc.NeedsImageFile(imgPath) {
+				entry, err := imagecollage.NewManifestFileEntry(sc.FS(), imgPath, prev)
+				if err != nil {
+					log.Printf("%s not an image: %v", imgPath, err)
+					return nil
+				}
+				if err := collage.AddImageFile(imgPath); err != nil {
+					log.Printf("%s not an image: %v", imgPath, err)
+					return nil
+				}
+				manifest.Add(*entry)
+				return nil
+			}
+			entry, err := imagecollage.NewManifestEntry(sc.FS(), imgPath, prev, manifest.Settings)
+			if err != nil {
+				log.Printf("%s not an image: %v", imgPath, err)
+				return nil
+			}
+			if original, ok := contentHashes[entry.ContentHash]; ok && entry.ContentHash != "" {
+				err = sc.AddAlias(entry.Path, original)
+			} else {
+				if entry.Trim != nil {
+					err = sc.AddTrimmedRect(entry.Path, entry.Width, entry.Height, *entry.Trim)
+				} else {
+					err = collage.AddRect(entry.Path, entry.Width, entry.Height)
+				}
+				if err == nil {
+					contentHashes[entry.ContentHash] = entry.Path
+				}
+			}
+			if err != nil {
+				log.Printf("cannot add image %s: %v", imgPath, err)
+				return nil
+			}
+			manifest.Add(*entry)
 			return nil
 		}
-		imgPath := strings.TrimPrefix(filepath.ToSlash(path), folder)
 		if err := collage.AddImageFile(imgPath); err != nil {
 			log.Printf("%s not an image: %v", imgPath, err)
 			//			return errors.Wrapf(err, "cannot add image %s", path)
@@ -72,6 +505,33 @@ func main() {
 		return nil
 	})
 
+	if prevManifest != nil {
+		// tile pyramids and presentation manifests are not recorded in the manifest
+		if prevManifest.Equal(manifest) && fileExists(outimg) && fileExists(outjson) && *tiles == "" && *presentation == "" {
+			fmt.Printf("nothing changed: %s\n", outimg)
+			return
+		}
+		// only unchanged images are used as seed
+		seed := imagecollage.Layout{Rects: []imagecollage.Rect{}}
+		for _, entry := range manifest.Images {
+			if prev := prevManifest.Entry(entry.Path); prev != nil && prev.Hash == entry.Hash {
+				if prev.Rect.Name != "" {
+					seed.Rects = append(seed.Rects, prev.Rect)
+				}
+			}
+		}
+		var seedImage image.Image
+		if fileExists(outimg) {
+			if img, err := loadImage(outimg); err == nil {
+				seedImage = img
+			} else {
+				log.Printf("cannot reuse previous image: %v", err)
+			}
+		}
+		log.Printf("reusing %v of %v images", len(seed.Rects), len(manifest.Images))
+		sc.SetSeed(seed, seedImage)
+	}
+
 	layout, err := collage.Pack()
 	if err != nil {
 		log.Fatalf("cannot pack: %v", err)
@@ -79,23 +539,34 @@ func main() {
 	for _, rect := range layout.Rects {
 		fmt.Printf("%v\n", rect)
 	}
-	result, err := collage.CreateImage(layout, folder)
-	if err != nil {
-		log.Fatalf("cannot create target image: %v", err)
-	}
-	outimg := filepath.Clean(*output)
 	fDst, err := os.Create(outimg)
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer fDst.Close()
-	err = png.Encode(fDst, result)
-	if err != nil {
-		log.Fatal(err)
+	var wDst io.Writer = fDst
+	if profile != nil {
+		if wDst, err = PictureFS.NewICCWriter(fDst, "png", profile.Data); err != nil {
+			log.Fatal(err)
+		}
+	}
+	var result image.Image
+	if *band > 0 {
+		if err := sc.EncodeImageBands(wDst, layout, *band); err != nil {
+			log.Fatalf("cannot create target image: %v", err)
+		}
+	} else {
+		result, err = collage.CreateImage(layout)
+		if err != nil {
+			log.Fatalf("cannot create target image: %v", err)
+		}
+		err = imagecollage.EncodePNG(wDst, result)
+		if err != nil {
+			log.Fatal(err)
+		}
 	}
 	fmt.Printf("output image written: %s\n", outimg)
 
-	outjson := filepath.Clean(*output) + ".json"
 	jsonBytes, err := collage.CreateJSON(layout)
 	if err != nil {
 		log.Fatal(err)
@@ -105,4 +576,85 @@ func main() {
 	}
 	fmt.Printf("output json written: %s\n", outjson)
 
+	// smaller scales of a multi-scale collage
+	for i := 0; i < len(sc.Scales())-1; i++ {
+		scale := sc.Scales()[i]
+		if err := writeScaled(sc, layout, scale, filepath.Clean(*output), profile); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *tiles != "" {
+		pLayout, err := collage.CreateLayout(layout)
+		if err != nil {
+			log.Fatal(err)
+		}
+		dir := filepath.Dir(outimg)
+		name := strings.TrimSuffix(filepath.Base(outimg), filepath.Ext(outimg))
+		var target string
+		switch *tiles {
+		case "dzi":
+			if err := imagecollage.WriteDeepZoom(result, dir, name, *tileSize, 0, *tileFormat); err != nil {
+				log.Fatalf("cannot write deep zoom image: %v", err)
+			}
+			target = name + ".dzi"
+			fmt.Printf("deep zoom image written: %s\n", filepath.Join(dir, name+".dzi"))
+		case "iiif":
+			target = *iiifID
+			if target == "" {
+				target = name
+			}
+			if err := imagecollage.WriteIIIFTiles(result, filepath.Join(dir, name), target, *tileSize, *tileFormat); err != nil {
+				log.Fatalf("cannot write iiif tiles: %v", err)
+			}
+			fmt.Printf("iiif tiles written: %s\n", filepath.Join(dir, name))
+		default:
+			log.Fatalf("invalid tile pyramid type %s", *tiles)
+		}
+		annotations := imagecollage.CreateRegionAnnotations(pLayout, target+"/annotations", target)
+		annotationBytes, err := json.Marshal(annotations)
+		if err != nil {
+			log.Fatal(err)
+		}
+		outannotations := filepath.Clean(*output) + ".annotations.json"
+		if err := os.WriteFile(outannotations, annotationBytes, 0666); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("region annotations written: %s\n", outannotations)
+	}
+
+	if *presentation != "" {
+		pLayout, err := collage.CreateLayout(layout)
+		if err != nil {
+			log.Fatal(err)
+		}
+		name := strings.TrimSuffix(filepath.Base(outimg), filepath.Ext(outimg))
+		size := collage.ImageSize(layout)
+		iiifManifest := imagecollage.CreateIIIFManifest(
+			pLayout,
+			*presentation,
+			name,
+			strings.TrimRight(*presentation, "/")+"/iiif/"+iiifServiceID(outimg),
+			size.Dx(),
+			size.Dy(),
+			false)
+		iiifBytes, err := json.Marshal(iiifManifest)
+		if err != nil {
+			log.Fatal(err)
+		}
+		outiiif := filepath.Clean(*output) + ".iiif.json"
+		if err := os.WriteFile(outiiif, iiifBytes, 0666); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("iiif manifest written: %s\n", outiiif)
+	}
+
+	if manifest != nil {
+		manifest.SetLayout(layout)
+		if err := manifest.Save(*manifestFile); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("manifest written: %s\n", *manifestFile)
+	}
+
 }
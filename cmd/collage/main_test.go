@@ -14,6 +14,7 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"testing/fstest"
 )
 
 const MAX_W = 100
@@ -81,10 +82,13 @@ func TestCollage(t *testing.T) {
 	fmt.Printf("Folder: %s\n", dname)
 	//defer os.RemoveAll(dname)
 
+	// the source images only exist in memory
+	sources := fstest.MapFS{}
+
 	var collage imagecollage.Collage
 
 	collage = imagecollage.NewSemibranCollage(
-		dname,
+		sources,
 		2,
 		2,
 		10,
@@ -97,24 +101,17 @@ func TestCollage(t *testing.T) {
 		height := 2 * (rand.Intn(MAX_H) + 1)
 		fname := fmt.Sprintf("pictureFS_%04dx%04d.png", width, height)
 		dir := fmt.Sprintf("%v", i%5)
-		dir2 := filepath.Join(dname, dir)
-		if err := os.MkdirAll(dir2, 0777); err != nil {
-			t.Fatalf("cannot create dir %s", dir2)
-		}
-		fullpath := filepath.Join(dir2, fname)
-		f, err := os.Create(fullpath)
-		if err != nil {
-			t.Fatalf("cannot create temp dir \"pictureFS\": %v", err)
-		}
+		fullpath := dir + "/" + fname
 		upLeft := image.Point{X: 0, Y: 0}
 		lowRight := image.Point{X: width, Y: height}
 		img := image.NewNRGBA(image.Rectangle{Min: upLeft, Max: lowRight})
 		Rect(0, 0, width-1, height-1, 2, color.RGBA{R: 0, G: 255, B: 0, A: 255}, img)
 		drawline(0, 0, width-1, height-1, color.RGBA{R: 0, G: 255, B: 0, A: 255}, img)
 		drawline(width-1, 0, 0, height-1, color.RGBA{R: 0, G: 255, B: 0, A: 255}, img)
-		png.Encode(f, img)
-		f.Close()
-		collage.AddImageFile(filepath.Join(dir, fname))
+		buf := &bytes.Buffer{}
+		png.Encode(buf, img)
+		sources[fullpath] = &fstest.MapFile{Data: buf.Bytes()}
+		collage.AddImageFile(fullpath)
 
 		fmt.Printf("Image %s\n", fullpath)
 	}
@@ -125,7 +122,7 @@ func TestCollage(t *testing.T) {
 	for _, rect := range layout.Rects {
 		fmt.Printf("%v\n", rect)
 	}
-	result, err := collage.CreateImage(layout, dname)
+	result, err := collage.CreateImage(layout)
 	if err != nil {
 		t.Fatalf("cannot create target image: %v", err)
 	}
@@ -151,11 +148,6 @@ func TestCollage(t *testing.T) {
 	}
 
 	fmt.Printf("output json written: %s\n", outjson)
-	for _, rect := range layout.Rects {
-		if err := os.Remove(filepath.Join(dname, rect.Name)); err != nil {
-			t.Fatalf("cannot remove file %s: %v", filepath.Join(dname, rect.Name), err)
-		}
-	}
 
 	pfs, err := PictureFS.NewFSFile(outimg, outjson)
 	if err != nil {
@@ -174,7 +166,7 @@ func TestCollage(t *testing.T) {
 			if err != nil {
 				return errors.Wrapf(err, "cannot decode image %s", path)
 			}
-			fmt.Sprintf("%s is a %s", path, format)
+			t.Logf("%s is a %s", path, format)
 			dir2 := filepath.Join(dname, "out", filepath.Dir(path))
 			if err := os.MkdirAll(dir2, 0777); err != nil {
 				return errors.Wrapf(err, "cannot create directory %s", dir2)
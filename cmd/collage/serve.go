@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/je4/PictureFS/v2/pkg/PictureFS"
+	"github.com/je4/PictureFS/v2/pkg/imagecollage"
+	"image"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// scale suffix of the atlases of a multi-scale collage
+var scalePattern = regexp.MustCompile(`@\d+x$`)
+
+// iiifServiceID returns the id of the iiif image service of an atlas file: the name of the file without
+// extension and scale suffix. It is the default of serve -id and the service of -presentation manifests
+func iiifServiceID(atlasFile string) string {
+	return scalePattern.ReplaceAllString(strings.TrimSuffix(filepath.Base(atlasFile), filepath.Ext(atlasFile)), "")
+}
+
+// serveHandler returns the handler of the iiif image service and the presentation manifest of an atlas.
+// Without baseURL, the urls are based on the scheme and host of the request
+func serveHandler(img image.Image, layout PictureFS.Layout, title, id, baseURL string, perImage bool) http.Handler {
+	mux := http.NewServeMux()
+	iiif := PictureFS.NewIIIFHandler(img, layout, "/iiif", id)
+	iiif.SetBaseURL(baseURL)
+	mux.Handle("/iiif/", iiif)
+	mux.HandleFunc("/manifest.json", func(w http.ResponseWriter, r *http.Request) {
+		base := iiif.BaseURL(r)
+		manifest := imagecollage.CreateIIIFManifest(
+			&layout,
+			base,
+			title,
+			base+"/iiif/"+id,
+			img.Bounds().Dx(),
+			img.Bounds().Dy(),
+			perImage)
+		w.Header().Set("Content-Type", "application/ld+json;profile=\"http://iiif.io/api/presentation/3/context.json\"")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		json.NewEncoder(w).Encode(manifest)
+	})
+	return mux
+}
+
+// serve starts a local IIIF image server for an atlas and returns the exit code
+func serve(args []string) int {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	var addr = flags.String("addr", "localhost:8080", "listen address")
+	var layoutFile = flags.String("layout", "", "layout json file (default: <atlas>.json)")
+	var id = flags.String("id", "", "iiif id of the atlas (default: name of the atlas without extension and scale suffix)")
+	var perImage = flags.Bool("perimage", false, "one canvas per image in manifest.json")
+	var baseURL = flags.String("baseurl", "", "public base url of the server, e.g. behind a proxy (default: scheme and host of the request)")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "usage: %s serve [-addr host:port] [-layout Y] [-baseurl url] atlas.png\n", filepath.Base(os.Args[0]))
+		return 2
+	}
+	atlasFile := filepath.Clean(flags.Arg(0))
+	if *layoutFile == "" {
+		*layoutFile = atlasFile + ".json"
+	}
+	if *id == "" {
+		*id = iiifServiceID(atlasFile)
+	}
+	img, err := loadImage(atlasFile)
+	if err != nil {
+		log.Print(err)
+		return 2
+	}
+	layoutBytes, err := os.ReadFile(*layoutFile)
+	if err != nil {
+		log.Printf("cannot read layout %s: %v", *layoutFile, err)
+		return 2
+	}
+	layout := PictureFS.Layout{}
+	if err := json.Unmarshal(layoutBytes, &layout); err != nil {
+		log.Printf("cannot decode layout %s: %v", *layoutFile, err)
+		return 2
+	}
+
+	handler := serveHandler(img, layout, strings.TrimSuffix(filepath.Base(atlasFile), filepath.Ext(atlasFile)), *id, *baseURL, *perImage)
+	log.Printf("serving %s on http://%s/manifest.json", atlasFile, *addr)
+	if err := http.ListenAndServe(*addr, handler); err != nil {
+		log.Print(err)
+		return 1
+	}
+	return 0
+}
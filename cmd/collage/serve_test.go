@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/je4/PictureFS/v2/pkg/PictureFS"
+	"github.com/je4/PictureFS/v2/pkg/imagecollage"
+	"image"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestIIIFServiceID(t *testing.T) {
+	for file, id := range map[string]string{
+		"collage.png":          "collage",
+		"out/atlas.png":        "atlas",
+		"out/atlas@3x.png":     "atlas",
+		"out/atlas@2x.png":     "atlas",
+		"out/atlas@home.png":   "atlas@home",
+		"out/atlas.v2@12x.png": "atlas.v2",
+	} {
+		if result := iiifServiceID(file); result != id {
+			t.Errorf("iiifServiceID(%s) = %s, expected %s", file, result, id)
+		}
+	}
+}
+
+func TestServe(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 40, 20))
+	layout := PictureFS.Layout{Images: []PictureFS.Rect{{Path: "/a.png", X: 0, Y: 0, Width: 20, Height: 20}}}
+	server := httptest.NewServer(serveHandler(img, layout, "atlas@2x", iiifServiceID("atlas@2x.png"), "", false))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/manifest.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("manifest.json: status %v", resp.StatusCode)
+	}
+	manifest := imagecollage.IIIFManifest{}
+	if err := json.NewDecoder(resp.Body).Decode(&manifest); err != nil {
+		t.Fatal(err)
+	}
+	if manifest.ID != server.URL+"/manifest.json" || len(manifest.Items) != 1 || manifest.Items[0].Width != 40 {
+		t.Fatalf("unexpected manifest %+v", manifest)
+	}
+	// the service of the manifest is the service of -presentation: <base>/iiif/<atlas name without scale>
+	body := manifest.Items[0].Items[0].Items[0].Body.(map[string]interface{})
+	service := body["service"].([]interface{})[0].(map[string]interface{})["id"].(string)
+	if service != server.URL+"/iiif/atlas" {
+		t.Errorf("unexpected image service %s", service)
+	}
+
+	for path, status := range map[string]int{
+		"/iiif/atlas/info.json":              http.StatusOK,
+		"/iiif/a.png/full/max/0/default.png": http.StatusOK,
+		"/iiif/atlas@2x/info.json":           http.StatusNotFound,
+		"/iiif/atlas/full/max/0/default.tif": http.StatusBadRequest,
+	} {
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != status {
+			t.Errorf("%s: status %v, expected %v", path, resp.StatusCode, status)
+		}
+	}
+}
+
+func TestServeBaseURL(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 40, 20))
+	layout := PictureFS.Layout{Images: []PictureFS.Rect{{Path: "/a.png", X: 0, Y: 0, Width: 20, Height: 20}}}
+	handler := serveHandler(img, layout, "atlas", "atlas", "https://example.com/collage/", false)
+	for _, test := range []struct {
+		path string
+		id   func(body []byte) (string, error)
+	}{
+		{"/manifest.json", func(body []byte) (string, error) {
+			manifest := imagecollage.IIIFManifest{}
+			err := json.Unmarshal(body, &manifest)
+			return manifest.ID, err
+		}},
+		{"/iiif/atlas/info.json", func(body []byte) (string, error) {
+			info := PictureFS.IIIFImageInfo{}
+			err := json.Unmarshal(body, &info)
+			return info.ID, err
+		}},
+	} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://localhost:8080"+test.path, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: status %v", test.path, w.Code)
+		}
+		id, err := test.id(w.Body.Bytes())
+		if err != nil {
+			t.Fatalf("%s: %v", test.path, err)
+		}
+		if !strings.HasPrefix(id, "https://example.com/collage/") {
+			t.Errorf("%s: unexpected id %s", test.path, id)
+		}
+	}
+	// the scheme of tls requests
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "https://localhost:8443/manifest.json", nil)
+	serveHandler(img, layout, "atlas", "atlas", "", false).ServeHTTP(w, r)
+	manifest := imagecollage.IIIFManifest{}
+	if err := json.Unmarshal(w.Body.Bytes(), &manifest); err != nil {
+		t.Fatal(err)
+	}
+	if manifest.ID != "https://localhost:8443/manifest.json" {
+		t.Errorf("unexpected manifest id %s", manifest.ID)
+	}
+}
@@ -0,0 +1,349 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/je4/PictureFS/v2/pkg/PictureFS"
+	"github.com/je4/PictureFS/v2/pkg/imagecollage"
+	"github.com/pkg/errors"
+	"golang.org/x/image/draw"
+	"image"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	VerifyMissing = "missing"
+	VerifyExtra   = "extra"
+	VerifyResized = "resized"
+	VerifyChanged = "changed"
+)
+
+// VerifyEntry describes a single difference between atlas and source folder
+type VerifyEntry struct {
+	Path    string
+	Status  string
+	Message string `json:",omitempty"`
+	MaxDiff int    `json:",omitempty"`
+}
+
+// VerifyReport is the machine-readable result of the verify command
+type VerifyReport struct {
+	Atlas   string
+	Layout  string
+	Folder  string
+	Checked int
+	Drift   bool
+	Entries []VerifyEntry
+}
+
+// layout path of a page of a multi-page tiff image
+var pagePattern = regexp.MustCompile(`(?i)^(.+\.tiff?)/page-(\d+)\.png$`)
+
+type pageRef struct {
+	source string
+	index  int
+}
+
+// normalizes layout and folder paths to slash separated paths without leading slash
+func verifyPath(path string) string {
+	return strings.TrimPrefix(filepath.ToSlash(filepath.Clean("/"+path)), "/")
+}
+
+// maximum difference of a single color channel between two images of same size
+func maxDiff(a, b *image.NRGBA) int {
+	var result int
+	for i := 0; i < len(a.Pix) && i < len(b.Pix); i++ {
+		d := int(a.Pix[i]) - int(b.Pix[i])
+		if d < 0 {
+			d = -d
+		}
+		if d > result {
+			result = d
+		}
+	}
+	return result
+}
+
+// copies a region into a new NRGBA image the same way collage and PictureFS do
+func toNRGBA(src image.Image, r image.Rectangle) *image.NRGBA {
+	img := image.NewNRGBA(image.Rectangle{Max: r.Size()})
+	draw.Copy(img, image.Point{}, src, r, draw.Over, nil)
+	return img
+}
+
+// atlasColors copies region r of src with the colors of a gray or paletted atlas (-colormode)
+func atlasColors(src image.Image, r image.Rectangle, atlas image.Image) *image.NRGBA {
+	var converted draw.Image
+	switch a := atlas.(type) {
+	case *image.Gray:
+		converted = image.NewGray(r)
+	case *image.Gray16:
+		converted = image.NewGray16(r)
+	case *image.Paletted:
+		converted = image.NewPaletted(r, a.Palette)
+	default:
+		return toNRGBA(src, r)
+	}
+	draw.Draw(converted, r, src, r.Min, draw.Src)
+	return toNRGBA(converted, r)
+}
+
+// gif images are added twice to the layout (.gif and .png) with the same coordinates
+func isGIFAlias(rect PictureFS.Rect, rects map[string]PictureFS.Rect) bool {
+	if strings.ToLower(filepath.Ext(rect.Path)) != ".png" {
+		return false
+	}
+	base := strings.TrimSuffix(rect.Path, filepath.Ext(rect.Path))
+	for _, ext := range []string{".gif", ".GIF"} {
+		if r, ok := rects[verifyPath(base+ext)]; ok {
+			return r.X == rect.X && r.Y == rect.Y && r.Width == rect.Width && r.Height == rect.Height
+		}
+	}
+	return false
+}
+
+// loadSourceImage decodes the image path of fsys
+func loadSourceImage(fsys fs.FS, path string) (image.Image, error) {
+	f, err := fsys.Open(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot open image %s", path)
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot decode image %s", path)
+	}
+	return img, nil
+}
+
+// verifyAtlas compares an atlas with the images of folder, which may be a zip archive like the
+// folder of the collage command
+func verifyAtlas(atlasFile, layoutFile, folder string, tolerance, jpegTolerance int) (*VerifyReport, error) {
+	atlas, err := loadImage(atlasFile)
+	if err != nil {
+		return nil, err
+	}
+	layoutBytes, err := os.ReadFile(layoutFile)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot read layout %s", layoutFile)
+	}
+	layout := PictureFS.Layout{}
+	if err := json.Unmarshal(layoutBytes, &layout); err != nil {
+		return nil, errors.Wrapf(err, "cannot decode layout %s", layoutFile)
+	}
+	// the source images have the largest scale
+	if n := len(layout.Scales); n > 0 && layout.Scale != layout.Scales[n-1] {
+		return nil, errors.New(fmt.Sprintf("%s has scale %v, only the atlas of scale %v can be verified", layoutFile, layout.Scale, layout.Scales[n-1]))
+	}
+
+	// the sources are converted into the working space of the atlas
+	var profile *imagecollage.ICCProfile
+	if len(layout.ICCProfile) > 0 {
+		if profile, err = imagecollage.ParseICCProfile(layout.ICCProfile); err != nil {
+			return nil, errors.Wrapf(err, "cannot parse color profile of %s", layoutFile)
+		}
+	}
+
+	report := &VerifyReport{
+		Atlas:   atlasFile,
+		Layout:  layoutFile,
+		Folder:  folder,
+		Entries: []VerifyEntry{},
+	}
+
+	rects := map[string]PictureFS.Rect{}
+	for _, rect := range layout.Images {
+		rects[verifyPath(rect.Path)] = rect
+	}
+	// frames of animations are checked by their source only
+	frames := map[string]bool{}
+	animations := map[string]bool{}
+	for _, anim := range layout.Animations {
+		animations[verifyPath(anim.Path)] = true
+		for _, frame := range anim.Frames {
+			frames[verifyPath(frame.Path)] = true
+		}
+	}
+
+	// pages of multi-page tiff images
+	pages := map[string]pageRef{}
+	for path := range rects {
+		if m := pagePattern.FindStringSubmatch(path); m != nil {
+			index, _ := strconv.Atoi(m[2])
+			pages[path] = pageRef{source: m[1], index: index - 1}
+		}
+	}
+	pageSources := map[string]bool{}
+	for _, page := range pages {
+		pageSources[page.source] = true
+	}
+
+	fsys, closeFolder, err := openFolder(folder)
+	if err != nil {
+		return nil, err
+	}
+	defer closeFolder()
+	sources := map[string]bool{}
+	if err := fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		f, err := fsys.Open(path)
+		if err != nil {
+			return errors.Wrapf(err, "cannot open %s", path)
+		}
+		_, _, err = image.DecodeConfig(f)
+		f.Close()
+		if err != nil {
+			// not an image
+			return nil
+		}
+		sources[verifyPath(path)] = true
+		return nil
+	}); err != nil {
+		return nil, errors.Wrapf(err, "cannot walk folder %s", folder)
+	}
+
+	var tiffs = map[string]*imagecollage.TIFFFile{}
+	for _, rect := range layout.Images {
+		path := verifyPath(rect.Path)
+		if isGIFAlias(rect, rects) || frames[path] {
+			continue
+		}
+		report.Checked++
+		var src image.Image
+		// file with the color profile of the image
+		var filePath = path
+		if page, ok := pages[path]; ok && sources[page.source] {
+			filePath = page.source
+			tf, ok := tiffs[page.source]
+			if !ok {
+				if tf, err = imagecollage.ReadTIFF(fsys, page.source); err != nil {
+					report.Entries = append(report.Entries, VerifyEntry{Path: path, Status: VerifyMissing, Message: err.Error()})
+					continue
+				}
+				tiffs[page.source] = tf
+			}
+			if src, err = tf.Page(page.index); err != nil {
+				report.Entries = append(report.Entries, VerifyEntry{Path: path, Status: VerifyMissing, Message: err.Error()})
+				continue
+			}
+		} else if rect.Source != nil {
+			// vector images are rasterized again with the parameters of the layout
+			source, ok := imagecollage.FindSource(append(imagecollage.DefaultSources(), &imagecollage.PDFSource{}), rect.Source.Format)
+			if _, err := fs.Stat(fsys, path); !ok || err != nil {
+				report.Entries = append(report.Entries, VerifyEntry{Path: path, Status: VerifyMissing})
+				continue
+			}
+			if src, err = source.Load(fsys, path, imagecollage.RasterizationFromInfo(rect.Source)); err != nil {
+				return nil, err
+			}
+		} else {
+			if !sources[path] {
+				report.Entries = append(report.Entries, VerifyEntry{Path: path, Status: VerifyMissing})
+				continue
+			}
+			if src, err = loadSourceImage(fsys, path); err != nil {
+				return nil, err
+			}
+		}
+		if src, err = imagecollage.ConvertFileColors(fsys, src, filePath, profile); err != nil {
+			return nil, err
+		}
+		width, height := rect.Width, rect.Height
+		srcRect := src.Bounds()
+		if rect.Trimmed() {
+			width, height = rect.SourceWidth, rect.SourceHeight
+			srcRect = image.Rect(rect.TrimX, rect.TrimY, rect.TrimX+rect.Width, rect.TrimY+rect.Height).Add(src.Bounds().Min)
+		}
+		if src.Bounds().Dx() != width || src.Bounds().Dy() != height {
+			report.Entries = append(report.Entries, VerifyEntry{
+				Path:    path,
+				Status:  VerifyResized,
+				Message: fmt.Sprintf("%vx%v in atlas, %vx%v in folder", width, height, src.Bounds().Dx(), src.Bounds().Dy()),
+			})
+			continue
+		}
+		crop := image.Rect(rect.X, rect.Y, rect.X+rect.Width, rect.Y+rect.Height)
+		if !crop.In(atlas.Bounds()) {
+			report.Entries = append(report.Entries, VerifyEntry{
+				Path:    path,
+				Status:  VerifyChanged,
+				Message: fmt.Sprintf("rect %v outside of atlas %v", crop, atlas.Bounds()),
+			})
+			continue
+		}
+		tol := tolerance
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".jpg", ".jpeg":
+			tol = jpegTolerance
+		}
+		if diff := maxDiff(toNRGBA(atlas, crop), atlasColors(src, srcRect, atlas)); diff > tol {
+			report.Entries = append(report.Entries, VerifyEntry{
+				Path:    path,
+				Status:  VerifyChanged,
+				MaxDiff: diff,
+			})
+		}
+	}
+
+	extra := []string{}
+	for path := range sources {
+		if _, ok := rects[path]; !ok && !animations[path] && !pageSources[path] {
+			extra = append(extra, path)
+		}
+	}
+	sort.Strings(extra)
+	for _, path := range extra {
+		report.Entries = append(report.Entries, VerifyEntry{Path: path, Status: VerifyExtra})
+	}
+
+	report.Drift = len(report.Entries) > 0
+	return report, nil
+}
+
+// verify compares an atlas with the images of its source folder and returns the exit code
+func verify(args []string) int {
+	flags := flag.NewFlagSet("verify", flag.ExitOnError)
+	var basedir = flags.String("folder", ".", "base folder or zip archive with image contents")
+	var layoutFile = flags.String("layout", "", "layout json file (default: <atlas>.json)")
+	var tolerance = flags.Int("tolerance", 0, "maximum difference per color channel")
+	var jpegTolerance = flags.Int("jpegtolerance", 8, "maximum difference per color channel for jpeg images")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "usage: %s verify [-folder X] [-layout Y] atlas.png\n", filepath.Base(os.Args[0]))
+		return 2
+	}
+	atlasFile := filepath.Clean(flags.Arg(0))
+	if *layoutFile == "" {
+		*layoutFile = atlasFile + ".json"
+	}
+
+	report, err := verifyAtlas(atlasFile, filepath.Clean(*layoutFile), filepath.Clean(*basedir), *tolerance, *jpegTolerance)
+	if err != nil {
+		log.Printf("cannot verify %s: %v", atlasFile, err)
+		return 2
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		log.Printf("cannot encode report: %v", err)
+		return 2
+	}
+	if report.Drift {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"archive/zip"
+	"github.com/je4/PictureFS/v2/pkg/imagecollage"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTestImage(t *testing.T, fullpath string, width, height int, col color.Color) {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	Rect(0, 0, width-1, height-1, 1, col, img)
+	f, err := os.Create(fullpath)
+	if err != nil {
+		t.Fatalf("cannot create %s: %v", fullpath, err)
+	}
+	defer f.Close()
+	if err := png.Encode(f, img); err != nil {
+		t.Fatalf("cannot encode %s: %v", fullpath, err)
+	}
+}
+
+// writeTestZip writes the files of dir into the zip archive filename
+func writeTestZip(t *testing.T, filename, dir string) {
+	f, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zw := zip.NewWriter(f)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		w, err := zw.Create(entry.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	dname := t.TempDir()
+	green := color.RGBA{G: 255, A: 255}
+	for i, name := range []string{"a.png", "b.png", "c.png", "d.png"} {
+		writeTestImage(t, filepath.Join(dname, name), 10+i*5, 20, green)
+	}
+
+	collage := imagecollage.NewSemibranCollage(os.DirFS(dname), 1, 1, 5, 5, 5, 5)
+	for _, name := range []string{"a.png", "b.png", "c.png", "d.png"} {
+		if err := collage.AddImageFile(name); err != nil {
+			t.Fatalf("cannot add %s: %v", name, err)
+		}
+	}
+	layout, err := collage.Pack()
+	if err != nil {
+		t.Fatalf("cannot pack: %v", err)
+	}
+	img, err := collage.CreateImage(layout)
+	if err != nil {
+		t.Fatalf("cannot create image: %v", err)
+	}
+	outimg := filepath.Join(t.TempDir(), "atlas.png")
+	f, err := os.Create(outimg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := png.Encode(f, img); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	jsonBytes, err := collage.CreateJSON(layout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(outimg+".json", jsonBytes, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := verifyAtlas(outimg, outimg+".json", dname, 0, 8)
+	if err != nil {
+		t.Fatalf("cannot verify: %v", err)
+	}
+	if report.Drift {
+		t.Fatalf("unexpected drift: %v", report.Entries)
+	}
+
+	// the same sources in a zip archive
+	archive := filepath.Join(t.TempDir(), "sources.zip")
+	writeTestZip(t, archive, dname)
+	if report, err = verifyAtlas(outimg, outimg+".json", archive, 0, 8); err != nil {
+		t.Fatalf("cannot verify archive: %v", err)
+	}
+	if report.Drift || report.Checked != 4 {
+		t.Fatalf("unexpected report of archive: %+v", report)
+	}
+
+	writeTestImage(t, filepath.Join(dname, "a.png"), 10, 20, color.RGBA{R: 255, A: 255})
+	writeTestImage(t, filepath.Join(dname, "b.png"), 16, 20, green)
+	if err := os.Remove(filepath.Join(dname, "c.png")); err != nil {
+		t.Fatal(err)
+	}
+	writeTestImage(t, filepath.Join(dname, "e.png"), 10, 10, green)
+
+	report, err = verifyAtlas(outimg, outimg+".json", dname, 0, 8)
+	if err != nil {
+		t.Fatalf("cannot verify: %v", err)
+	}
+	expected := map[string]string{
+		"a.png": VerifyChanged,
+		"b.png": VerifyResized,
+		"c.png": VerifyMissing,
+		"e.png": VerifyExtra,
+	}
+	if len(report.Entries) != len(expected) {
+		t.Fatalf("expected %v entries, got %v", len(expected), report.Entries)
+	}
+	for _, entry := range report.Entries {
+		if expected[entry.Path] != entry.Status {
+			t.Errorf("%s: expected status %s, got %s", entry.Path, expected[entry.Path], entry.Status)
+		}
+	}
+}
+
+// TestVerifyColors verifies atlases with color management and gray or paletted color mode
+func TestVerifyColors(t *testing.T) {
+	dname := t.TempDir()
+	img := image.NewNRGBA(image.Rect(0, 0, 16, 12))
+	for y := 0; y < 12; y++ {
+		for x := 0; x < 16; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{R: uint8(x * 16), G: uint8(y * 20), B: 90, A: 255})
+		}
+	}
+	f, err := os.Create(filepath.Join(dname, "a.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := png.Encode(f, img); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	p3, err := imagecollage.ParseColorSpace("p3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, test := range map[string]struct {
+		profile *imagecollage.ICCProfile
+		mode    imagecollage.ColorMode
+	}{
+		"p3":       {p3, imagecollage.ColorModeNRGBA},
+		"gray":     {nil, imagecollage.ColorModeGray},
+		"paletted": {p3, imagecollage.ColorModePaletted},
+	} {
+		collage := imagecollage.NewSemibranCollage(os.DirFS(dname), 1, 1, 2, 2, 2, 2)
+		collage.SetColorSpace(test.profile)
+		collage.SetColorMode(test.mode, 16)
+		if err := collage.AddImageFile("a.png"); err != nil {
+			t.Fatal(err)
+		}
+		layout, err := collage.Pack()
+		if err != nil {
+			t.Fatal(err)
+		}
+		atlas, err := collage.CreateImage(layout)
+		if err != nil {
+			t.Fatal(err)
+		}
+		outimg := filepath.Join(t.TempDir(), "atlas.png")
+		f, err := os.Create(outimg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := png.Encode(f, atlas); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+		jsonBytes, err := collage.CreateJSON(layout)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(outimg+".json", jsonBytes, 0666); err != nil {
+			t.Fatal(err)
+		}
+		report, err := verifyAtlas(outimg, outimg+".json", dname, 0, 8)
+		if err != nil {
+			t.Fatalf("%s: cannot verify: %v", name, err)
+		}
+		if report.Drift || report.Checked != 1 {
+			t.Errorf("%s: unexpected report %+v", name, report)
+		}
+	}
+}
@@ -1,8 +1,6 @@
 module github.com/je4/PictureFS/v2
 
-replace (
-	github.com/je4/PictureFS/v2 => ./
-)
+replace github.com/je4/PictureFS/v2 => ./
 
 go 1.17
 
@@ -13,4 +11,13 @@ require (
 	github.com/pkg/errors v0.9.1
 )
 
-require golang.org/x/image v0.0.0-20191009234506-e7c1f5e7dbb8 // indirect
+require (
+	github.com/srwiley/oksvg v0.0.0-20200311192757-870daf9aa564
+	github.com/srwiley/rasterx v0.0.0-20200120212402-85cb7272f5e9
+	golang.org/x/image v0.0.0-20191009234506-e7c1f5e7dbb8
+)
+
+require (
+	golang.org/x/net v0.11.0 // indirect
+	golang.org/x/text v0.13.0 // indirect
+)
@@ -0,0 +1,132 @@
+package PictureFS
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/pkg/errors"
+	"golang.org/x/image/draw"
+	"image"
+	"image/color"
+	"image/gif"
+	"path/filepath"
+	"strings"
+)
+
+// maximum number of colors of a gif frame
+const gifColors = 256
+
+// gifFrame converts a frame of an animated gif image to a paletted image. Frames with up to 256 colors
+// keep their colors exactly, the palette of other frames is reduced with the median cut quantizer
+func gifFrame(frame *image.NRGBA) *image.Paletted {
+	var hist = map[color.NRGBA]int{}
+	for y := frame.Rect.Min.Y; y < frame.Rect.Max.Y && len(hist) <= gifColors; y++ {
+		for x := frame.Rect.Min.X; x < frame.Rect.Max.X; x++ {
+			var c = frame.NRGBAAt(x, y)
+			// gif has a single transparent color
+			if c.A == 0 {
+				c = color.NRGBA{}
+			}
+			hist[c]++
+		}
+	}
+	if len(hist) > gifColors {
+		var p = image.NewPaletted(frame.Rect, MedianCut{}.Quantize(make(color.Palette, 0, gifColors), frame))
+		draw.FloydSteinberg.Draw(p, p.Rect, frame, frame.Rect.Min)
+		return p
+	}
+	var palette = SortedPalette(hist)
+	var index = map[color.NRGBA]uint8{}
+	for i, c := range palette {
+		index[c.(color.NRGBA)] = uint8(i)
+	}
+	var p = image.NewPaletted(frame.Rect, palette)
+	for y := frame.Rect.Min.Y; y < frame.Rect.Max.Y; y++ {
+		for x := frame.Rect.Min.X; x < frame.Rect.Max.X; x++ {
+			var c = frame.NRGBAAt(x, y)
+			if c.A == 0 {
+				c = color.NRGBA{}
+			}
+			p.SetColorIndex(x, y, index[c])
+		}
+	}
+	return p
+}
+
+// frameImages returns the set of image paths which contain animation frames
+func frameImages(animations []Animation) map[string]bool {
+	var result = map[string]bool{}
+	for _, anim := range animations {
+		for _, frame := range anim.Frames {
+			result[frame.Path] = true
+		}
+	}
+	return result
+}
+
+// encodeAnimation creates an animated gif or png file from the frames
+func encodeAnimation(anim Animation, frames []*image.NRGBA) ([]byte, error) {
+	var data = bytes.NewBuffer(nil)
+	switch strings.ToLower(filepath.Ext(anim.Path)) {
+	case ".gif":
+		var g = &gif.GIF{
+			Image: []*image.Paletted{},
+			Delay: []int{},
+			// gif counts the repetitions after the first run, -1 plays once
+			LoopCount: anim.Loops - 1,
+			Disposal:  []byte{},
+		}
+		if anim.Loops == 0 {
+			g.LoopCount = 0
+		}
+		for i, frame := range frames {
+			g.Image = append(g.Image, gifFrame(frame))
+			g.Delay = append(g.Delay, anim.Frames[i].Delay/10)
+			g.Disposal = append(g.Disposal, gif.DisposalBackground)
+		}
+		if err := gif.EncodeAll(data, g); err != nil {
+			return nil, errors.Wrapf(err, "cannot encode gif %s", anim.Path)
+		}
+	default:
+		var a = &APNG{
+			Frames: frames,
+			Delays: []int{},
+			Loops:  anim.Loops,
+		}
+		for _, frame := range anim.Frames {
+			a.Delays = append(a.Delays, frame.Delay)
+		}
+		if err := EncodeAPNG(data, a); err != nil {
+			return nil, errors.Wrapf(err, "cannot encode apng %s", anim.Path)
+		}
+	}
+	return data.Bytes(), nil
+}
+
+// addAnimations assembles the animations from the decoded frame images
+func (pfs *FS) addAnimations(animations []Animation, images map[string]*image.NRGBA) error {
+	for _, anim := range animations {
+		var frames = []*image.NRGBA{}
+		for _, frame := range anim.Frames {
+			img, ok := images[frame.Path]
+			if !ok {
+				return errors.New(fmt.Sprintf("frame %s of animation %s not found", frame.Path, anim.Path))
+			}
+			var r = image.Rect(frame.X, frame.Y, frame.X+anim.Width, frame.Y+anim.Height).Add(img.Bounds().Min)
+			if !r.In(img.Bounds()) {
+				return errors.New(fmt.Sprintf("frame %s of animation %s outside of image", frame.Path, anim.Path))
+			}
+			var f = image.NewNRGBA(image.Rect(0, 0, anim.Width, anim.Height))
+			draw.Copy(f, image.Point{}, img, r, draw.Src, nil)
+			frames = append(frames, f)
+		}
+		if len(frames) == 0 {
+			return errors.New(fmt.Sprintf("animation %s without frames", anim.Path))
+		}
+		data, err := encodeAnimation(anim, frames)
+		if err != nil {
+			return err
+		}
+		pfs.data["/"+strings.TrimPrefix(filepath.ToSlash(filepath.Clean(anim.Path)), "/")] = data
+	}
+	return nil
+}
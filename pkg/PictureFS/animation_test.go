@@ -0,0 +1,70 @@
+package PictureFS
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"testing"
+)
+
+func TestGIFAnimationColors(t *testing.T) {
+	// colors which are not web safe and a transparent pixel
+	var frames = []*image.NRGBA{}
+	for i := 0; i < 2; i++ {
+		frame := image.NewNRGBA(image.Rect(0, 0, 32, 32))
+		for y := 0; y < 32; y++ {
+			for x := 0; x < 32; x++ {
+				frame.SetNRGBA(x, y, color.NRGBA{R: uint8(17 + x%8), G: uint8(100 + y%8), B: uint8(200 + i), A: 255})
+			}
+		}
+		frame.SetNRGBA(0, 0, color.NRGBA{R: 12, A: 0})
+		frames = append(frames, frame)
+	}
+	// a gradient with more than 256 colors
+	gradient := image.NewNRGBA(image.Rect(0, 0, 32, 32))
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 32; x++ {
+			gradient.SetNRGBA(x, y, color.NRGBA{R: uint8(x * 8), G: uint8(y * 8), B: 128, A: 255})
+		}
+	}
+	frames = append(frames, gradient)
+	anim := Animation{Path: "/anim.gif", Width: 32, Height: 32, Loops: 0, Frames: []Frame{{Delay: 100}, {Delay: 100}, {Delay: 100}}}
+
+	data, err := encodeAnimation(anim, frames)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(g.Image) != 3 {
+		t.Fatalf("%v frames, expected 3", len(g.Image))
+	}
+	for i, frame := range frames[:2] {
+		for y := 0; y < 32; y++ {
+			for x := 0; x < 32; x++ {
+				expected := frame.NRGBAAt(x, y)
+				if expected.A == 0 {
+					expected = color.NRGBA{}
+				}
+				if c := color.NRGBAModel.Convert(g.Image[i].At(x, y)).(color.NRGBA); c != expected {
+					t.Fatalf("frame %v: pixel %v/%v is %v, expected %v", i, x, y, c, expected)
+				}
+			}
+		}
+	}
+	if len(g.Image[2].Palette) > 256 {
+		t.Errorf("palette with %v colors", len(g.Image[2].Palette))
+	}
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 32; x++ {
+			c := color.NRGBAModel.Convert(g.Image[2].At(x, y)).(color.NRGBA)
+			expected := gradient.NRGBAAt(x, y)
+			if d := int(c.R) - int(expected.R); d > 24 || d < -24 {
+				t.Fatalf("quantized pixel %v/%v is %v, expected %v", x, y, c, expected)
+			}
+		}
+	}
+}
@@ -0,0 +1,278 @@
+package PictureFS
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/pkg/errors"
+	"golang.org/x/image/draw"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"io"
+)
+
+// APNG is a decoded (animated) png image. All frames are fully composited and have the size of the animation
+type APNG struct {
+	Frames []*image.NRGBA
+	// Delays of the frames in milliseconds
+	Delays []int
+	// Loops is the number of times the animation is played (0: infinite)
+	Loops int
+}
+
+const (
+	apngDisposeNone       = 0
+	apngDisposeBackground = 1
+	apngDisposePrevious   = 2
+	apngBlendSource       = 0
+)
+
+type pngChunk struct {
+	typ  string
+	data []byte
+}
+
+// readChunks returns all chunks of a png stream
+func readChunks(r io.Reader) ([]pngChunk, error) {
+	var sig = make([]byte, len(pngSignature))
+	if _, err := io.ReadFull(r, sig); err != nil {
+		return nil, errors.Wrap(err, "cannot read png signature")
+	}
+	if !bytes.Equal(sig, pngSignature) {
+		return nil, errors.New("not a png image")
+	}
+	var chunks = []pngChunk{}
+	for {
+		length, typ, err := readChunkHeader(r)
+		if err != nil {
+			return nil, err
+		}
+		var data = make([]byte, length+4)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, errors.Wrapf(err, "cannot read png chunk %s", typ)
+		}
+		chunks = append(chunks, pngChunk{typ: typ, data: data[:length]})
+		if typ == "IEND" {
+			return chunks, nil
+		}
+	}
+}
+
+func writeChunk(w io.Writer, typ string, data []byte) error {
+	var buf = make([]byte, 8)
+	binary.BigEndian.PutUint32(buf[0:4], uint32(len(data)))
+	copy(buf[4:8], typ)
+	var crc = crc32.NewIEEE()
+	crc.Write(buf[4:8])
+	crc.Write(data)
+	if _, err := w.Write(buf); err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	binary.BigEndian.PutUint32(buf[0:4], crc.Sum32())
+	_, err := w.Write(buf[0:4])
+	return err
+}
+
+type apngFrameControl struct {
+	width, height, x, y int
+	delay               int
+	dispose, blend      byte
+	data                []byte
+}
+
+// DecodeAPNG decodes all frames of an animated png. A png without animation results in a single frame
+func DecodeAPNG(r io.Reader) (*APNG, error) {
+	chunks, err := readChunks(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(chunks) == 0 || chunks[0].typ != "IHDR" || len(chunks[0].data) != 13 {
+		return nil, errors.New("missing png header")
+	}
+	var ihdr = chunks[0].data
+	var width = int(binary.BigEndian.Uint32(ihdr[0:4]))
+	var height = int(binary.BigEndian.Uint32(ihdr[4:8]))
+	var result = &APNG{}
+	var header = []pngChunk{}
+	var frames = []*apngFrameControl{}
+	var current *apngFrameControl
+	var animated bool
+	var defaultImage = []byte{}
+	for _, chunk := range chunks[1:] {
+		switch chunk.typ {
+		case "acTL":
+			if len(chunk.data) != 8 {
+				return nil, errors.New("invalid apng animation control")
+			}
+			animated = true
+			result.Loops = int(binary.BigEndian.Uint32(chunk.data[4:8]))
+		case "fcTL":
+			if len(chunk.data) != 26 {
+				return nil, errors.New("invalid apng frame control")
+			}
+			var d = chunk.data
+			var num, den = int(binary.BigEndian.Uint16(d[20:22])), int(binary.BigEndian.Uint16(d[22:24]))
+			if den == 0 {
+				den = 100
+			}
+			current = &apngFrameControl{
+				width:   int(binary.BigEndian.Uint32(d[4:8])),
+				height:  int(binary.BigEndian.Uint32(d[8:12])),
+				x:       int(binary.BigEndian.Uint32(d[12:16])),
+				y:       int(binary.BigEndian.Uint32(d[16:20])),
+				delay:   num * 1000 / den,
+				dispose: d[24],
+				blend:   d[25],
+			}
+			frames = append(frames, current)
+		case "IDAT":
+			if current != nil {
+				current.data = append(current.data, chunk.data...)
+			} else {
+				defaultImage = append(defaultImage, chunk.data...)
+			}
+		case "fdAT":
+			if current == nil || len(chunk.data) < 4 {
+				return nil, errors.New("invalid apng frame data")
+			}
+			current.data = append(current.data, chunk.data[4:]...)
+		case "PLTE", "tRNS", "gAMA", "sRGB", "iCCP", "cHRM", "sBIT":
+			header = append(header, chunk)
+		}
+	}
+	if !animated || len(frames) == 0 {
+		frames = []*apngFrameControl{{width: width, height: height, data: defaultImage}}
+	}
+
+	var canvas = image.NewNRGBA(image.Rect(0, 0, width, height))
+	for i, frame := range frames {
+		var buf = bytes.NewBuffer(nil)
+		buf.Write(pngSignature)
+		var frameHeader = append([]byte{}, ihdr...)
+		binary.BigEndian.PutUint32(frameHeader[0:4], uint32(frame.width))
+		binary.BigEndian.PutUint32(frameHeader[4:8], uint32(frame.height))
+		writeChunk(buf, "IHDR", frameHeader)
+		for _, chunk := range header {
+			writeChunk(buf, chunk.typ, chunk.data)
+		}
+		writeChunk(buf, "IDAT", frame.data)
+		writeChunk(buf, "IEND", nil)
+		img, err := png.Decode(buf)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot decode apng frame %v", i)
+		}
+		var region = image.Rect(frame.x, frame.y, frame.x+frame.width, frame.y+frame.height)
+		if !region.In(canvas.Bounds()) {
+			return nil, errors.New(fmt.Sprintf("apng frame %v outside of image", i))
+		}
+		var previous *image.NRGBA
+		if frame.dispose == apngDisposePrevious && i > 0 {
+			previous = image.NewNRGBA(region)
+			draw.Copy(previous, region.Min, canvas, region, draw.Src, nil)
+		}
+		var op = draw.Over
+		if frame.blend == apngBlendSource {
+			op = draw.Src
+		}
+		draw.Copy(canvas, region.Min, img, img.Bounds(), op, nil)
+		var snapshot = image.NewNRGBA(canvas.Bounds())
+		copy(snapshot.Pix, canvas.Pix)
+		result.Frames = append(result.Frames, snapshot)
+		result.Delays = append(result.Delays, frame.delay)
+		switch {
+		case previous != nil:
+			draw.Copy(canvas, region.Min, previous, region, draw.Src, nil)
+		case frame.dispose == apngDisposeBackground || frame.dispose == apngDisposePrevious:
+			draw.Draw(canvas, region, image.Transparent, image.Point{}, draw.Src)
+		}
+	}
+	return result, nil
+}
+
+// transparentNRGBA forces the png encoder to write an alpha channel, so that all frames share the same color type
+type transparentNRGBA struct {
+	*image.NRGBA
+}
+
+func (t transparentNRGBA) Opaque() bool {
+	return false
+}
+
+// EncodeAPNG writes the frames of anim as animated png. All frames must have the size of the first frame
+func EncodeAPNG(w io.Writer, anim *APNG) error {
+	if len(anim.Frames) == 0 {
+		return errors.New("apng without frames")
+	}
+	var bounds = anim.Frames[0].Bounds()
+	if _, err := w.Write(pngSignature); err != nil {
+		return errors.Wrap(err, "cannot write png signature")
+	}
+	var seq uint32
+	for i, frame := range anim.Frames {
+		if frame.Bounds().Size() != bounds.Size() {
+			return errors.New(fmt.Sprintf("apng frame %v has size %v instead of %v", i, frame.Bounds().Size(), bounds.Size()))
+		}
+		var buf = bytes.NewBuffer(nil)
+		if err := png.Encode(buf, transparentNRGBA{frame}); err != nil {
+			return errors.Wrapf(err, "cannot encode apng frame %v", i)
+		}
+		chunks, err := readChunks(buf)
+		if err != nil {
+			return errors.Wrapf(err, "cannot read encoded apng frame %v", i)
+		}
+		if i == 0 {
+			if err := writeChunk(w, "IHDR", chunks[0].data); err != nil {
+				return errors.Wrap(err, "cannot write png header")
+			}
+			var actl = make([]byte, 8)
+			binary.BigEndian.PutUint32(actl[0:4], uint32(len(anim.Frames)))
+			binary.BigEndian.PutUint32(actl[4:8], uint32(anim.Loops))
+			if err := writeChunk(w, "acTL", actl); err != nil {
+				return errors.Wrap(err, "cannot write apng animation control")
+			}
+		}
+		var fctl = make([]byte, 26)
+		binary.BigEndian.PutUint32(fctl[0:4], seq)
+		binary.BigEndian.PutUint32(fctl[4:8], uint32(bounds.Dx()))
+		binary.BigEndian.PutUint32(fctl[8:12], uint32(bounds.Dy()))
+		var delay int
+		if i < len(anim.Delays) {
+			delay = anim.Delays[i]
+		}
+		if delay > 0xffff {
+			delay = 0xffff
+		}
+		binary.BigEndian.PutUint16(fctl[20:22], uint16(delay))
+		binary.BigEndian.PutUint16(fctl[22:24], 1000)
+		fctl[24] = apngDisposeNone
+		fctl[25] = apngBlendSource
+		seq++
+		if err := writeChunk(w, "fcTL", fctl); err != nil {
+			return errors.Wrap(err, "cannot write apng frame control")
+		}
+		for _, chunk := range chunks {
+			if chunk.typ != "IDAT" {
+				continue
+			}
+			if i == 0 {
+				err = writeChunk(w, "IDAT", chunk.data)
+			} else {
+				var fdat = make([]byte, 4, len(chunk.data)+4)
+				binary.BigEndian.PutUint32(fdat, seq)
+				seq++
+				err = writeChunk(w, "fdAT", append(fdat, chunk.data...))
+			}
+			if err != nil {
+				return errors.Wrapf(err, "cannot write data of apng frame %v", i)
+			}
+		}
+	}
+	if err := writeChunk(w, "IEND", nil); err != nil {
+		return errors.Wrap(err, "cannot write png end")
+	}
+	return nil
+}
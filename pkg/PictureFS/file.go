@@ -10,6 +10,20 @@ type File struct {
 	name string
 	fs   *FS
 	i    int64
+	// content of virtual files (transformed variants)
+	data []byte
+}
+
+// content returns the bytes of the file
+func (f *File) content() []byte {
+	if f.data != nil {
+		return f.data
+	}
+	return f.fs.data[f.name]
+}
+
+func (f *File) exists() bool {
+	return f.data != nil || f.fs.hasFile(f.name)
 }
 
 func (f *File) Close() error {
@@ -19,7 +33,7 @@ func (f *File) Close() error {
 }
 
 func (f *File) Stat() (FileInfo, error) {
-	if !f.fs.hasFile(f.name) {
+	if !f.exists() {
 		if !f.fs.hasDir(f.name) {
 			return nil, errors.New(fmt.Sprintf("invalid node: %s", f.name))
 		}
@@ -31,7 +45,7 @@ func (f *File) Stat() (FileInfo, error) {
 	}
 	return &fileStat{
 		name: f.name,
-		size: int64(len(f.fs.data[f.name])), // hasFile makes sure, it exists,
+		size: int64(len(f.content())), // exists makes sure, it exists,
 		dir:  false,
 	}, nil
 }
@@ -39,10 +53,10 @@ func (f *File) Stat() (FileInfo, error) {
 // Len returns the number of bytes of the unread portion of the
 // string.
 func (f *File) Len() int64 {
-	if !f.fs.hasFile(f.name) {
+	if !f.exists() {
 		return 0
 	}
-	var l int64 = int64(len(f.fs.data[f.name]))
+	var l int64 = int64(len(f.content()))
 	if f.i >= l {
 		return 0
 	}
@@ -50,20 +64,20 @@ func (f *File) Len() int64 {
 }
 
 func (f *File) Size() int64 {
-	if !f.fs.hasFile(f.name) {
+	if !f.exists() {
 		return 0
 	}
-	return int64(len(f.fs.data[f.name]))
+	return int64(len(f.content()))
 }
 
 func (f *File) Read(buf []byte) (n int, err error) {
-	if !f.fs.hasFile(f.name) {
+	if !f.exists() {
 		return 0, errors.New(fmt.Sprintf("invalid file: %s", f.name))
 	}
-	if f.i >= int64(len(f.fs.data[f.name])) {
+	if f.i >= int64(len(f.content())) {
 		return 0, io.EOF
 	}
-	n = copy(buf, f.fs.data[f.name][f.i:])
+	n = copy(buf, f.content()[f.i:])
 	f.i += int64(n)
 	return
 }
@@ -74,10 +88,10 @@ func (f *File) ReadAt(b []byte, off int64) (n int, err error) {
 	if off < 0 {
 		return 0, errors.New("PictureFS.File.ReadAt: negative offset")
 	}
-	if off >= int64(len(f.fs.data[f.name])) {
+	if off >= int64(len(f.content())) {
 		return 0, io.EOF
 	}
-	n = copy(b, f.fs.data[f.name][off:])
+	n = copy(b, f.content()[off:])
 	if n < len(b) {
 		err = io.EOF
 	}
@@ -86,10 +100,10 @@ func (f *File) ReadAt(b []byte, off int64) (n int, err error) {
 
 // ReadByte implements the io.ByteReader interface.
 func (f *File) ReadByte() (byte, error) {
-	if f.i >= int64(len(f.fs.data[f.name])) {
+	if f.i >= int64(len(f.content())) {
 		return 0, io.EOF
 	}
-	b := f.fs.data[f.name][f.i]
+	b := f.content()[f.i]
 	f.i++
 	return b, nil
 }
@@ -103,7 +117,7 @@ func (f *File) Seek(offset int64, whence int) (int64, error) {
 	case io.SeekCurrent:
 		abs = f.i + offset
 	case io.SeekEnd:
-		abs = int64(len(f.fs.data[f.name])) + offset
+		abs = int64(len(f.content())) + offset
 	default:
 		return 0, errors.New("strings.Reader.Seek: invalid whence")
 	}
@@ -5,7 +5,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/pkg/errors"
+	"golang.org/x/image/bmp"
 	"golang.org/x/image/draw"
+	"golang.org/x/image/tiff"
 	"image"
 	"image/gif"
 	"image/jpeg"
@@ -29,8 +31,13 @@ func Sub(fsys fs.FS, dir string) (fs.FS, error) {
 		return nil, errors.New(fmt.Sprintf("invalid directory: %s", newDir))
 	}
 	subFS := &FS{
-		base: newDir,
-		data: lfs.data,
+		base:     newDir,
+		data:     lfs.data,
+		profile:  lfs.profile,
+		atlas:    lfs.atlas,
+		rects:    lfs.rects,
+		variants: lfs.variants,
+		scale:    lfs.scale,
 	}
 	return subFS, nil
 }
@@ -118,8 +125,14 @@ func (pfs *FS) hasFile(path string) bool {
 }
 
 type FS struct {
-	base string
-	data fsData
+	base    string
+	data    fsData
+	profile []byte
+	// atlas is nil for filesystems created with NewFSReader
+	atlas    image.Image
+	rects    map[string]Rect
+	variants *variantCache
+	scale    int
 }
 
 func NewFSFile(img string, layout string) (*FS, error) {
@@ -147,55 +160,333 @@ func NewFSFile(img string, layout string) (*FS, error) {
 
 func NewFS(img image.Image, layout Layout) (*FS, error) {
 	pfs := &FS{
-		base: "/",
-		data: make(fsData),
+		base:     "/",
+		data:     make(fsData),
+		profile:  layout.ICCProfile,
+		atlas:    img,
+		rects:    layoutRects(layout),
+		variants: newVariantCache(),
+		scale:    layout.Scale,
 	}
+	// images with same coordinates and format share their data like hard links
+	var links = map[string][]byte{}
+	// frames of animations are not added as files, but assembled to the animated image
+	var isFrame = frameImages(layout.Animations)
+	var frames = map[string]*image.NRGBA{}
 	for _, rect := range layout.Images {
-		newImg := image.NewNRGBA(image.Rectangle{
-			Min: image.Point{},
-			Max: image.Point{X: rect.Width, Y: rect.Height},
-		})
-		draw.Copy(newImg,
-			image.Point{},
-			img,
-			image.Rectangle{
-				Min: image.Point{X: rect.X, Y: rect.Y},
-				Max: image.Point{X: rect.X + rect.Width, Y: rect.Y + rect.Height},
-			},
-			draw.Over,
-			nil,
-		)
-		var data = bytes.NewBuffer(nil)
-		var err error
-		ext := strings.ToLower(filepath.Ext(rect.Path))
-		switch ext {
-		case ".jpg":
-			err = jpeg.Encode(data, newImg, nil)
-		case ".jpeg":
-			err = jpeg.Encode(data, newImg, nil)
-			//		case ".png":
-			//			err = png.Encode(data, newImg)
-		case ".gif":
-			err = gif.Encode(data, newImg, nil)
-		default:
-			err = png.Encode(data, newImg)
-			//return nil, errors.New(fmt.Sprintf("invalid image extension %s in path %s", ext, rect.Path))
+		var srcRect = image.Rectangle{
+			Min: image.Point{X: rect.X, Y: rect.Y},
+			Max: image.Point{X: rect.X + rect.Width, Y: rect.Y + rect.Height},
+		}
+		if isFrame[rect.Path] {
+			frames[rect.Path] = cropImage(img, srcRect, rect)
+			continue
+		}
+		if err := pfs.addImage(rect, img, srcRect, links); err != nil {
+			return nil, err
+		}
+	}
+	if err := pfs.addAnimations(layout.Animations, frames); err != nil {
+		return nil, err
+	}
+	return pfs, nil
+}
+
+// NewFSReader creates the filesystem from a png image, which is decoded row by row.
+// Only the rows of the images which overlap the current row are kept in memory.
+// 16 bit images keep their depth
+func NewFSReader(r io.Reader, layout Layout) (*FS, error) {
+	pfs := &FS{
+		base:     "/",
+		data:     make(fsData),
+		profile:  layout.ICCProfile,
+		rects:    layoutRects(layout),
+		variants: newVariantCache(),
+		scale:    layout.Scale,
+	}
+	rr, err := newPNGRowReader(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot read png image")
+	}
+	var rects = make([]Rect, len(layout.Images))
+	copy(rects, layout.Images)
+	sort.SliceStable(rects, func(i, j int) bool { return rects[i].Y < rects[j].Y })
+	for _, rect := range rects {
+		if !image.Rect(rect.X, rect.Y, rect.X+rect.Width, rect.Y+rect.Height).In(rr.Bounds()) {
+			return nil, errors.New(fmt.Sprintf("image %s outside of collage", rect.Path))
+		}
+	}
+
+	type activeRect struct {
+		rect Rect
+		img  draw.Image
+	}
+	var links = map[string][]byte{}
+	var isFrame = frameImages(layout.Animations)
+	var frames = map[string]*image.NRGBA{}
+	var active = []activeRect{}
+	var next = 0
+	var deep = rr.bitDepth == 16
+	var row draw.Image
+	if deep {
+		row = image.NewNRGBA64(image.Rect(0, 0, rr.Bounds().Dx(), 1))
+	} else {
+		row = image.NewNRGBA(image.Rect(0, 0, rr.Bounds().Dx(), 1))
+	}
+	for y := 0; y < rr.Bounds().Dy(); y++ {
+		if deep {
+			err = rr.ReadRow64(row.(*image.NRGBA64))
+		} else {
+			err = rr.ReadRow(row.(*image.NRGBA))
 		}
 		if err != nil {
-			return nil, errors.Wrapf(err, "cannot encode image %s", rect.Path)
+			return nil, err
+		}
+		for ; next < len(rects) && rects[next].Y <= y; next++ {
+			var bounds = image.Rect(0, 0, rects[next].Width, rects[next].Height)
+			var img draw.Image = image.NewNRGBA(bounds)
+			if deep {
+				img = image.NewNRGBA64(bounds)
+			}
+			active = append(active, activeRect{rect: rects[next], img: img})
 		}
-		pfs.data[strings.Replace(
-			filepath.ToSlash(
-				filepath.Clean(
-					"/"+strings.TrimPrefix(rect.Path, "/"))), "//", "/", -1)] = data.Bytes()
+		var stillActive = []activeRect{}
+		for _, ar := range active {
+			CopyImage(ar.img, image.Point{Y: y - ar.rect.Y}, row, image.Rect(ar.rect.X, 0, ar.rect.X+ar.rect.Width, 1))
+			if y < ar.rect.Y+ar.rect.Height-1 {
+				stillActive = append(stillActive, ar)
+				continue
+			}
+			if isFrame[ar.rect.Path] {
+				frames[ar.rect.Path] = cropImage(ar.img, ar.img.Bounds(), ar.rect)
+				continue
+			}
+			if err := pfs.addImage(ar.rect, ar.img, ar.img.Bounds(), links); err != nil {
+				return nil, err
+			}
+		}
+		active = stillActive
+	}
+	// images without height
+	for ; next < len(rects); next++ {
+		if err := pfs.addImage(rects[next], image.NewNRGBA(image.Rectangle{}), image.Rectangle{}, links); err != nil {
+			return nil, err
+		}
+	}
+	if err := pfs.addAnimations(layout.Animations, frames); err != nil {
+		return nil, err
 	}
 	return pfs, nil
 }
 
+// NewFSFileStream is the same as NewFSFile, but the png image is decoded row by row
+func NewFSFileStream(img string, layout string) (*FS, error) {
+	fImg, err := os.Open(img)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot open image file %s", img)
+	}
+	defer fImg.Close()
+	fJSON, err := os.Open(layout)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot open json file %s", layout)
+	}
+	defer fJSON.Close()
+	dec := json.NewDecoder(fJSON)
+	l := Layout{}
+	if err := dec.Decode(&l); err != nil {
+		return nil, errors.Wrapf(err, "cannot decode json file %s", layout)
+	}
+	return NewFSReader(fImg, l)
+}
+
+// cropImage copies the region srcRect of img into a new image and reconstructs the
+// transparent borders of trimmed images
+func cropImage(img image.Image, srcRect image.Rectangle, rect Rect) *image.NRGBA {
+	newImg := image.NewNRGBA(cropBounds(rect))
+	cropInto(newImg, img, srcRect, rect)
+	return newImg
+}
+
+// cropBounds returns the bounds of the (untrimmed) image rect
+func cropBounds(rect Rect) image.Rectangle {
+	if rect.Trimmed() {
+		return image.Rect(0, 0, rect.SourceWidth, rect.SourceHeight)
+	}
+	return image.Rect(0, 0, rect.Width, rect.Height)
+}
+
+// cropInto copies the region srcRect of img into dst
+func cropInto(dst draw.Image, img image.Image, srcRect image.Rectangle, rect Rect) {
+	var pos = image.Point{}
+	if rect.Trimmed() {
+		// reconstruct the transparent borders
+		pos = image.Point{X: rect.TrimX, Y: rect.TrimY}
+	}
+	CopyImage(dst, pos, img, srcRect)
+}
+
+// CopyImage copies the region sr of src to dp in the transparent image dst. NRGBA and NRGBA64 images
+// are copied without premultiplication, so that semi transparent pixels keep their exact values
+func CopyImage(dst draw.Image, dp image.Point, src image.Image, sr image.Rectangle) {
+	var r = sr.Sub(sr.Min).Add(dp).Intersect(dst.Bounds())
+	sr = r.Sub(dp).Add(sr.Min).Intersect(src.Bounds())
+	r = sr.Sub(sr.Min).Add(r.Min)
+	var bpp int
+	var dPix, sPix []uint8
+	var dOffset, sOffset func(x, y int) int
+	var dStride, sStride int
+	switch d := dst.(type) {
+	case *image.NRGBA:
+		if s, ok := src.(*image.NRGBA); ok {
+			bpp, dPix, sPix, dOffset, sOffset, dStride, sStride = 4, d.Pix, s.Pix, d.PixOffset, s.PixOffset, d.Stride, s.Stride
+		}
+	case *image.NRGBA64:
+		if s, ok := src.(*image.NRGBA64); ok {
+			bpp, dPix, sPix, dOffset, sOffset, dStride, sStride = 8, d.Pix, s.Pix, d.PixOffset, s.PixOffset, d.Stride, s.Stride
+		}
+	}
+	if bpp == 0 {
+		draw.Copy(dst, r.Min, src, sr, draw.Over, nil)
+		return
+	}
+	if r.Empty() {
+		return
+	}
+	var d, s = dOffset(r.Min.X, r.Min.Y), sOffset(sr.Min.X, sr.Min.Y)
+	for y := 0; y < r.Dy(); y++ {
+		copy(dPix[d:d+r.Dx()*bpp], sPix[s:s+r.Dx()*bpp])
+		d += dStride
+		s += sStride
+	}
+}
+
+// keepImageType returns the cropped region with the type of the atlas, so that the encoded files
+// stay small (gray and paletted) or keep their depth (16 bit). Trimmed images may need transparent
+// borders and use NRGBA or NRGBA64
+func keepImageType(img image.Image, srcRect image.Rectangle, rect Rect, cropped *image.NRGBA) image.Image {
+	var exact = !rect.Trimmed() && srcRect.In(img.Bounds())
+	switch src := img.(type) {
+	case *image.Gray:
+		if !exact {
+			return cropped
+		}
+		var result = image.NewGray(cropped.Rect)
+		draw.Copy(result, image.Point{}, src, srcRect, draw.Src, nil)
+		return result
+	case *image.Paletted:
+		if !exact {
+			return cropped
+		}
+		var result = image.NewPaletted(cropped.Rect, src.Palette)
+		for y := 0; y < srcRect.Dy(); y++ {
+			copy(result.Pix[result.PixOffset(0, y):result.PixOffset(srcRect.Dx(), y)],
+				src.Pix[src.PixOffset(srcRect.Min.X, srcRect.Min.Y+y):src.PixOffset(srcRect.Max.X, srcRect.Min.Y+y)])
+		}
+		return result
+	case *image.Gray16:
+		if exact {
+			var result = image.NewGray16(cropped.Rect)
+			draw.Copy(result, image.Point{}, src, srcRect, draw.Src, nil)
+			return result
+		}
+		var result = image.NewNRGBA64(cropped.Rect)
+		cropInto(result, img, srcRect, rect)
+		return result
+	case *image.NRGBA64, *image.RGBA64:
+		var result = image.NewNRGBA64(cropped.Rect)
+		cropInto(result, img, srcRect, rect)
+		return result
+	}
+	return cropped
+}
+
+// addImage encodes the region srcRect of img as file rect.Path
+func (pfs *FS) addImage(rect Rect, img image.Image, srcRect image.Rectangle, links map[string][]byte) error {
+	ext := strings.ToLower(filepath.Ext(rect.Path))
+	var format = imageFormat(ext)
+	var linkKey = fmt.Sprintf("%s/%v/%v/%v/%v/%v/%v/%v/%v", format,
+		rect.X, rect.Y, rect.Width, rect.Height, rect.TrimX, rect.TrimY, rect.SourceWidth, rect.SourceHeight)
+	var name = strings.Replace(
+		filepath.ToSlash(
+			filepath.Clean(
+				"/"+strings.TrimPrefix(rect.Path, "/"))), "//", "/", -1)
+	if data, ok := links[linkKey]; ok {
+		pfs.data[name] = data
+		return nil
+	}
+	newImg := keepImageType(img, srcRect, rect, cropImage(img, srcRect, rect))
+	var data = bytes.NewBuffer(nil)
+	if err := pfs.encodeImage(data, newImg, ext); err != nil {
+		return errors.Wrapf(err, "cannot encode image %s", rect.Path)
+	}
+	pfs.data[name] = data.Bytes()
+	links[linkKey] = data.Bytes()
+	return nil
+}
+
+// imageFormat returns the encoding of the file extension ext
+func imageFormat(ext string) string {
+	switch ext {
+	case ".jpg", ".jpeg":
+		return "jpeg"
+	case ".gif":
+		return "gif"
+	case ".tif", ".tiff":
+		return "tiff"
+	case ".bmp":
+		return "bmp"
+	case ".webp":
+		return "webp"
+	default:
+		return "png"
+	}
+}
+
+// encodeImage writes img in the format of the file extension ext (default: png)
+func (pfs *FS) encodeImage(data io.Writer, img image.Image, ext string) error {
+	var format = imageFormat(ext)
+	// re-attach the color profile of the atlas
+	var w = data
+	if _, gray := img.(*image.Gray); (format == "png" || format == "jpeg") && !gray {
+		var err error
+		if w, err = NewICCWriter(data, format, pfs.profile); err != nil {
+			return err
+		}
+	}
+	switch format {
+	case "jpeg":
+		return jpeg.Encode(w, img, nil)
+	case "gif":
+		return gif.Encode(w, img, nil)
+	case "tiff":
+		return tiff.Encode(w, img, &tiff.Options{Compression: tiff.Deflate})
+	case "bmp":
+		return bmp.Encode(w, img)
+	case "webp":
+		return EncodeWebP(w, img)
+	default:
+		return png.Encode(w, img)
+	}
+}
+
+// fullPath returns the absolute path of name
+func (pfs *FS) fullPath(name string) string {
+	return "/" + strings.TrimPrefix(filepath.ToSlash(filepath.Clean(filepath.Join(pfs.base, name))), "/")
+}
+
 func (pfs *FS) Open(name string) (fs.File, error) {
-	fullpath := "/" + strings.TrimPrefix(filepath.ToSlash(filepath.Clean(filepath.Join(pfs.base, name))), "/")
+	fullpath := pfs.fullPath(name)
 	if !pfs.hasFile(fullpath) {
-		return nil, fs.ErrNotExist
+		// transformed variant of an image
+		data, err := pfs.openVariant(fullpath)
+		if err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+		return &File{
+			name: fullpath,
+			fs:   pfs,
+			data: data,
+		}, nil
 	}
 	return &File{
 		name: fullpath,
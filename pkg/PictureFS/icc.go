@@ -0,0 +1,221 @@
+package PictureFS
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"sort"
+)
+
+var iccMarker = []byte("ICC_PROFILE\x00")
+
+// maximum profile data in one jpeg APP2 segment
+const iccSegmentSize = 65519
+
+// ExtractICCProfile returns the embedded icc profile of a png or jpeg image. Images without profile return nil
+func ExtractICCProfile(r io.Reader) ([]byte, error) {
+	var br = bufio.NewReader(r)
+	head, err := br.Peek(2)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot read image header")
+	}
+	if head[0] == 0xff && head[1] == 0xd8 {
+		return extractJPEGProfile(br)
+	}
+	return extractPNGProfile(br)
+}
+
+func extractPNGProfile(r io.Reader) ([]byte, error) {
+	var sig = make([]byte, len(pngSignature))
+	if _, err := io.ReadFull(r, sig); err != nil {
+		return nil, errors.Wrap(err, "cannot read png signature")
+	}
+	if !bytes.Equal(sig, pngSignature) {
+		return nil, nil
+	}
+	for {
+		length, typ, err := readChunkHeader(r)
+		if err != nil {
+			return nil, err
+		}
+		if typ == "IDAT" || typ == "IEND" {
+			return nil, nil
+		}
+		var data = make([]byte, length+4)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, errors.Wrapf(err, "cannot read png chunk %s", typ)
+		}
+		if typ != "iCCP" {
+			continue
+		}
+		// profile name, null separator, compression method
+		var i = bytes.IndexByte(data[:length], 0)
+		if i < 0 || int(length) < i+2 {
+			return nil, errors.New("invalid png iCCP chunk")
+		}
+		zr, err := zlib.NewReader(bytes.NewReader(data[i+2 : length]))
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot decompress icc profile")
+		}
+		defer zr.Close()
+		profile, err := io.ReadAll(zr)
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot decompress icc profile")
+		}
+		return profile, nil
+	}
+}
+
+func extractJPEGProfile(r io.Reader) ([]byte, error) {
+	var buf = make([]byte, 4)
+	if _, err := io.ReadFull(r, buf[:2]); err != nil {
+		return nil, errors.Wrap(err, "cannot read jpeg header")
+	}
+	var segments = map[int][]byte{}
+	for {
+		if _, err := io.ReadFull(r, buf[:2]); err != nil {
+			return nil, errors.Wrap(err, "cannot read jpeg marker")
+		}
+		if buf[0] != 0xff {
+			return nil, errors.New("invalid jpeg marker")
+		}
+		var marker = buf[1]
+		if marker == 0xff {
+			// fill byte
+			continue
+		}
+		if marker >= 0xd0 && marker <= 0xd9 || marker == 0x01 {
+			continue
+		}
+		if _, err := io.ReadFull(r, buf[2:4]); err != nil {
+			return nil, errors.Wrap(err, "cannot read jpeg segment length")
+		}
+		var length = int(binary.BigEndian.Uint16(buf[2:4])) - 2
+		if length < 0 {
+			return nil, errors.New("invalid jpeg segment length")
+		}
+		if marker == 0xda {
+			// start of scan, no more profile data
+			break
+		}
+		var data = make([]byte, length)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, errors.Wrap(err, "cannot read jpeg segment")
+		}
+		if marker == 0xe2 && len(data) > len(iccMarker)+2 && bytes.Equal(data[:len(iccMarker)], iccMarker) {
+			segments[int(data[len(iccMarker)])] = data[len(iccMarker)+2:]
+		}
+	}
+	if len(segments) == 0 {
+		return nil, nil
+	}
+	var seqs = []int{}
+	for seq := range segments {
+		seqs = append(seqs, seq)
+	}
+	sort.Ints(seqs)
+	var profile = []byte{}
+	for _, seq := range seqs {
+		profile = append(profile, segments[seq]...)
+	}
+	return profile, nil
+}
+
+// iccWriter inserts an icc profile into a png or jpeg stream
+type iccWriter struct {
+	w       io.Writer
+	format  string
+	profile []byte
+	head    []byte
+	done    bool
+}
+
+// NewICCWriter returns a writer, which embeds profile into the png or jpeg stream written to w.
+// The profile is not embedded into grayscale png images
+func NewICCWriter(w io.Writer, format string, profile []byte) (io.Writer, error) {
+	switch format {
+	case "png", "jpeg", "jpg":
+	default:
+		return nil, errors.New(fmt.Sprintf("cannot embed icc profile into %s", format))
+	}
+	if len(profile) == 0 {
+		return w, nil
+	}
+	return &iccWriter{w: w, format: format, profile: profile}, nil
+}
+
+func (iw *iccWriter) Write(p []byte) (int, error) {
+	if iw.done {
+		return iw.w.Write(p)
+	}
+	iw.head = append(iw.head, p...)
+	// png: signature and IHDR chunk, jpeg: SOI marker
+	var size = 2
+	if iw.format == "png" {
+		size = len(pngSignature) + 8 + 13 + 4
+	}
+	if len(iw.head) < size {
+		return len(p), nil
+	}
+	iw.done = true
+	if _, err := iw.w.Write(iw.head[:size]); err != nil {
+		return 0, err
+	}
+	var err error
+	if iw.format == "png" {
+		// gray png images must not use rgb profiles
+		if colorType := iw.head[len(pngSignature)+8+9]; colorType != pngGray && colorType != pngGrayAlpha {
+			err = writeICCChunk(iw.w, iw.profile)
+		}
+	} else {
+		err = writeICCSegments(iw.w, iw.profile)
+	}
+	if err != nil {
+		return 0, errors.Wrap(err, "cannot write icc profile")
+	}
+	if _, err := iw.w.Write(iw.head[size:]); err != nil {
+		return 0, err
+	}
+	iw.head = nil
+	return len(p), nil
+}
+
+func writeICCChunk(w io.Writer, profile []byte) error {
+	var data = bytes.NewBufferString("ICC Profile\x00\x00")
+	zw := zlib.NewWriter(data)
+	if _, err := zw.Write(profile); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	return writeChunk(w, "iCCP", data.Bytes())
+}
+
+func writeICCSegments(w io.Writer, profile []byte) error {
+	var count = (len(profile) + iccSegmentSize - 1) / iccSegmentSize
+	if count > 255 {
+		return errors.New("icc profile too large for jpeg")
+	}
+	for i := 0; i < count; i++ {
+		var part = profile[i*iccSegmentSize:]
+		if len(part) > iccSegmentSize {
+			part = part[:iccSegmentSize]
+		}
+		var header = []byte{0xff, 0xe2, 0, 0}
+		binary.BigEndian.PutUint16(header[2:], uint16(2+len(iccMarker)+2+len(part)))
+		header = append(header, iccMarker...)
+		header = append(header, byte(i+1), byte(count))
+		if _, err := w.Write(header); err != nil {
+			return err
+		}
+		if _, err := w.Write(part); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,411 @@
+package PictureFS
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"golang.org/x/image/draw"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// IIIFHandler is a minimal IIIF Image API 3.0 server for a collage.
+// The collage is served with the configured id, all images of the layout with their
+// path escaped as id (e.g. "dir%2Fimage.png")
+//
+//	{prefix}/{id}/info.json
+//	{prefix}/{id}/{region}/{size}/{rotation}/{quality}.{format}
+type IIIFHandler struct {
+	prefix    string
+	id        string
+	img       image.Image
+	rects     map[string]Rect
+	baseURL   string
+	maxWidth  int
+	maxHeight int
+	maxArea   int
+}
+
+// default maximum width and height of a requested image
+const iiifMaxSize = 8192
+
+// default maximum number of pixels of a requested image
+const iiifMaxArea = 1 << 24
+
+func NewIIIFHandler(img image.Image, layout Layout, prefix, id string) *IIIFHandler {
+	h := &IIIFHandler{
+		prefix:    "/" + strings.Trim(prefix, "/"),
+		id:        id,
+		img:       img,
+		rects:     map[string]Rect{},
+		maxWidth:  iiifMaxSize,
+		maxHeight: iiifMaxSize,
+		maxArea:   iiifMaxArea,
+	}
+	if h.prefix == "/" {
+		h.prefix = ""
+	}
+	for _, rect := range layout.Images {
+		h.rects[strings.TrimPrefix(rect.Path, "/")] = rect
+	}
+	return h
+}
+
+// SetMaxSize sets the maximum width, height and number of pixels of a requested image
+func (h *IIIFHandler) SetMaxSize(width, height, area int) {
+	h.maxWidth = width
+	h.maxHeight = height
+	h.maxArea = area
+}
+
+// SetBaseURL sets the scheme and host of the image ids (e.g. behind a proxy). Without base url,
+// they are taken from the request
+func (h *IIIFHandler) SetBaseURL(baseURL string) {
+	h.baseURL = strings.TrimRight(baseURL, "/")
+}
+
+// BaseURL returns the scheme and host of the image ids of request r
+func (h *IIIFHandler) BaseURL(r *http.Request) string {
+	if h.baseURL != "" {
+		return h.baseURL
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s", scheme, r.Host)
+}
+
+const IIIF_IMAGE_CONTEXT = "http://iiif.io/api/image/3/context.json"
+
+type IIIFTile struct {
+	Width        int   `json:"width"`
+	Height       int   `json:"height,omitempty"`
+	ScaleFactors []int `json:"scaleFactors"`
+}
+
+type IIIFSize struct {
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+// IIIFImageInfo is the info.json of the IIIF Image API 3.0
+type IIIFImageInfo struct {
+	Context   string     `json:"@context"`
+	ID        string     `json:"id"`
+	Type      string     `json:"type"`
+	Protocol  string     `json:"protocol"`
+	Profile   string     `json:"profile"`
+	Width     int        `json:"width"`
+	Height    int        `json:"height"`
+	MaxWidth  int        `json:"maxWidth,omitempty"`
+	MaxHeight int        `json:"maxHeight,omitempty"`
+	MaxArea   int        `json:"maxArea,omitempty"`
+	Sizes     []IIIFSize `json:"sizes,omitempty"`
+	Tiles     []IIIFTile `json:"tiles,omitempty"`
+	Formats   []string   `json:"extraFormats,omitempty"`
+	Features  []string   `json:"extraFeatures,omitempty"`
+	Quality   []string   `json:"extraQualities,omitempty"`
+}
+
+// NewIIIFImageInfo creates the info.json content of a level 0 image with the given id
+func NewIIIFImageInfo(id string, width, height int) *IIIFImageInfo {
+	return &IIIFImageInfo{
+		Context:  IIIF_IMAGE_CONTEXT,
+		ID:       id,
+		Type:     "ImageService3",
+		Protocol: "http://iiif.io/api/image",
+		Profile:  "level0",
+		Width:    width,
+		Height:   height,
+	}
+}
+
+// image returns the collage or a cropped image of the layout
+func (h *IIIFHandler) image(id string) (image.Image, bool) {
+	if id == h.id {
+		return h.img, true
+	}
+	rect, ok := h.rects[strings.TrimPrefix(id, "/")]
+	if !ok {
+		return nil, false
+	}
+	return cropImage(h.img, image.Rect(rect.X, rect.Y, rect.X+rect.Width, rect.Y+rect.Height), rect), true
+}
+
+func iiifError(w http.ResponseWriter, status int, err error) {
+	http.Error(w, err.Error(), status)
+}
+
+func (h *IIIFHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.URL.EscapedPath(), h.prefix+"/") {
+		iiifError(w, http.StatusNotFound, errors.New(fmt.Sprintf("%s not found", r.URL.Path)))
+		return
+	}
+	parts := strings.Split(strings.TrimPrefix(r.URL.EscapedPath(), h.prefix+"/"), "/")
+	id, err := url.PathUnescape(parts[0])
+	if err != nil {
+		iiifError(w, http.StatusBadRequest, errors.Wrapf(err, "invalid id %s", parts[0]))
+		return
+	}
+	img, ok := h.image(id)
+	if !ok {
+		iiifError(w, http.StatusNotFound, errors.New(fmt.Sprintf("image %s not found", id)))
+		return
+	}
+	switch {
+	case len(parts) == 1 || len(parts) == 2 && parts[1] == "":
+		http.Redirect(w, r, h.prefix+"/"+parts[0]+"/info.json", http.StatusSeeOther)
+	case len(parts) == 2 && parts[1] == "info.json":
+		info := NewIIIFImageInfo(h.BaseURL(r)+h.prefix+"/"+parts[0], img.Bounds().Dx(), img.Bounds().Dy())
+		info.Profile = "level2"
+		info.MaxWidth, info.MaxHeight, info.MaxArea = h.maxWidth, h.maxHeight, h.maxArea
+		info.Formats = []string{"gif"}
+		info.Features = []string{"mirroring", "regionSquare", "sizeUpscaling"}
+		info.Quality = []string{"color", "gray", "bitonal"}
+		w.Header().Set("Content-Type", "application/ld+json;profile=\"http://iiif.io/api/image/3/context.json\"")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		json.NewEncoder(w).Encode(info)
+	case len(parts) == 5:
+		// region, size and rotation may contain escaped characters (e.g. ^ for upscaling)
+		for i := 1; i < len(parts); i++ {
+			if parts[i], err = url.PathUnescape(parts[i]); err != nil {
+				iiifError(w, http.StatusBadRequest, errors.Wrapf(err, "invalid parameter %s", parts[i]))
+				return
+			}
+		}
+		quality, format := parts[4], ""
+		if pos := strings.LastIndex(quality, "."); pos >= 0 {
+			quality, format = quality[:pos], quality[pos+1:]
+		}
+		result, err := IIIFTransform(img, parts[1], parts[2], parts[3], quality, h.maxWidth, h.maxHeight, h.maxArea)
+		if err != nil {
+			iiifError(w, http.StatusBadRequest, err)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		switch format {
+		case "jpg":
+			w.Header().Set("Content-Type", "image/jpeg")
+			err = jpeg.Encode(w, result, nil)
+		case "png":
+			w.Header().Set("Content-Type", "image/png")
+			err = png.Encode(w, result)
+		case "gif":
+			w.Header().Set("Content-Type", "image/gif")
+			err = gif.Encode(w, result, nil)
+		default:
+			iiifError(w, http.StatusBadRequest, errors.New(fmt.Sprintf("invalid format %s", format)))
+			return
+		}
+		if err != nil {
+			iiifError(w, http.StatusInternalServerError, errors.Wrap(err, "cannot encode image"))
+		}
+	default:
+		iiifError(w, http.StatusBadRequest, errors.New(fmt.Sprintf("invalid request %s", r.URL.Path)))
+	}
+}
+
+// parseNumbers parses a comma separated list of n numbers
+func parseNumbers(s string, n int) ([]float64, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != n {
+		return nil, errors.New(fmt.Sprintf("invalid parameter %s", s))
+	}
+	result := []float64{}
+	for _, p := range parts {
+		f, err := strconv.ParseFloat(p, 64)
+		if err != nil || f < 0 {
+			return nil, errors.New(fmt.Sprintf("invalid parameter %s", s))
+		}
+		result = append(result, f)
+	}
+	return result, nil
+}
+
+// iiifRegion returns the requested region of bounds
+func iiifRegion(bounds image.Rectangle, region string) (image.Rectangle, error) {
+	var r image.Rectangle
+	switch {
+	case region == "full":
+		r = bounds
+	case region == "square":
+		s := bounds.Dx()
+		if bounds.Dy() < s {
+			s = bounds.Dy()
+		}
+		r = image.Rect(0, 0, s, s).Add(bounds.Min).Add(image.Point{X: (bounds.Dx() - s) / 2, Y: (bounds.Dy() - s) / 2})
+	case strings.HasPrefix(region, "pct:"):
+		v, err := parseNumbers(strings.TrimPrefix(region, "pct:"), 4)
+		if err != nil {
+			return r, err
+		}
+		w, h := float64(bounds.Dx())/100, float64(bounds.Dy())/100
+		r = image.Rect(
+			int(math.Round(v[0]*w)),
+			int(math.Round(v[1]*h)),
+			int(math.Round((v[0]+v[2])*w)),
+			int(math.Round((v[1]+v[3])*h)),
+		).Add(bounds.Min)
+	default:
+		v, err := parseNumbers(region, 4)
+		if err != nil {
+			return r, err
+		}
+		r = image.Rect(int(v[0]), int(v[1]), int(v[0]+v[2]), int(v[1]+v[3])).Add(bounds.Min)
+	}
+	r = r.Intersect(bounds)
+	if r.Empty() {
+		return r, errors.New(fmt.Sprintf("empty region %s", region))
+	}
+	return r, nil
+}
+
+// iiifSize returns the requested size of a region. Sizes above the maximum width, height or
+// area (0: no limit) are invalid, max is reduced to them
+func iiifSize(region image.Rectangle, size string, maxWidth, maxHeight, maxArea int) (image.Point, error) {
+	var upscale = strings.HasPrefix(size, "^")
+	size = strings.TrimPrefix(size, "^")
+	var rw, rh = float64(region.Dx()), float64(region.Dy())
+	var w, h float64
+	switch {
+	case size == "max":
+		scale := 1.0
+		if maxWidth > 0 {
+			scale = math.Min(scale, float64(maxWidth)/rw)
+		}
+		if maxHeight > 0 {
+			scale = math.Min(scale, float64(maxHeight)/rh)
+		}
+		if maxArea > 0 {
+			scale = math.Min(scale, math.Sqrt(float64(maxArea)/(rw*rh)))
+		}
+		w, h = math.Floor(rw*scale), math.Floor(rh*scale)
+	case strings.HasPrefix(size, "pct:"):
+		v, err := strconv.ParseFloat(strings.TrimPrefix(size, "pct:"), 64)
+		if err != nil || v <= 0 {
+			return image.Point{}, errors.New(fmt.Sprintf("invalid size %s", size))
+		}
+		w, h = rw*v/100, rh*v/100
+	case strings.HasPrefix(size, "!"):
+		v, err := parseNumbers(strings.TrimPrefix(size, "!"), 2)
+		if err != nil {
+			return image.Point{}, err
+		}
+		scale := math.Min(v[0]/rw, v[1]/rh)
+		w, h = rw*scale, rh*scale
+	case strings.HasSuffix(size, ","):
+		v, err := strconv.ParseFloat(strings.TrimSuffix(size, ","), 64)
+		if err != nil {
+			return image.Point{}, errors.New(fmt.Sprintf("invalid size %s", size))
+		}
+		w, h = v, rh*v/rw
+	case strings.HasPrefix(size, ","):
+		v, err := strconv.ParseFloat(strings.TrimPrefix(size, ","), 64)
+		if err != nil {
+			return image.Point{}, errors.New(fmt.Sprintf("invalid size %s", size))
+		}
+		w, h = rw*v/rh, v
+	default:
+		v, err := parseNumbers(size, 2)
+		if err != nil {
+			return image.Point{}, err
+		}
+		w, h = v[0], v[1]
+	}
+	var result = image.Point{X: int(math.Round(w)), Y: int(math.Round(h))}
+	if result.X <= 0 || result.Y <= 0 {
+		return result, errors.New(fmt.Sprintf("invalid size %s", size))
+	}
+	if !upscale && (result.X > region.Dx() || result.Y > region.Dy()) {
+		return result, errors.New(fmt.Sprintf("size %s needs upscaling", size))
+	}
+	if maxWidth > 0 && result.X > maxWidth || maxHeight > 0 && result.Y > maxHeight || maxArea > 0 && result.X*result.Y > maxArea {
+		return result, errors.New(fmt.Sprintf("size %s exceeds the maximum size", size))
+	}
+	return result, nil
+}
+
+// rotate90 rotates img clockwise by 90 degrees
+func rotate90(img *image.NRGBA) *image.NRGBA {
+	b := img.Bounds()
+	result := image.NewNRGBA(image.Rect(0, 0, b.Dy(), b.Dx()))
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			result.SetNRGBA(b.Dy()-1-y, x, img.NRGBAAt(b.Min.X+x, b.Min.Y+y))
+		}
+	}
+	return result
+}
+
+// mirror flips img horizontally
+func mirror(img *image.NRGBA) *image.NRGBA {
+	b := img.Bounds()
+	result := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			result.SetNRGBA(b.Dx()-1-x, y, img.NRGBAAt(b.Min.X+x, b.Min.Y+y))
+		}
+	}
+	return result
+}
+
+// IIIFTransform applies the IIIF Image API parameters region, size, rotation and quality to img.
+// The size is limited by maxWidth, maxHeight and maxArea (0: no limit)
+func IIIFTransform(img image.Image, region, size, rotation, quality string, maxWidth, maxHeight, maxArea int) (image.Image, error) {
+	r, err := iiifRegion(img.Bounds(), region)
+	if err != nil {
+		return nil, err
+	}
+	s, err := iiifSize(r, size, maxWidth, maxHeight, maxArea)
+	if err != nil {
+		return nil, err
+	}
+	var result = image.NewNRGBA(image.Rectangle{Max: s})
+	if s == r.Size() {
+		draw.Copy(result, image.Point{}, img, r, draw.Src, nil)
+	} else {
+		draw.ApproxBiLinear.Scale(result, result.Bounds(), img, r, draw.Src, nil)
+	}
+
+	if strings.HasPrefix(rotation, "!") {
+		result = mirror(result)
+		rotation = strings.TrimPrefix(rotation, "!")
+	}
+	degrees, err := strconv.ParseFloat(rotation, 64)
+	if err != nil || math.Mod(degrees, 90) != 0 || degrees < 0 || degrees >= 360 {
+		return nil, errors.New(fmt.Sprintf("unsupported rotation %s", rotation))
+	}
+	for i := 0; i < int(degrees)/90; i++ {
+		result = rotate90(result)
+	}
+
+	switch quality {
+	case "default", "color":
+		return result, nil
+	case "gray":
+		gray := image.NewGray(result.Bounds())
+		draw.Draw(gray, gray.Bounds(), result, image.Point{}, draw.Src)
+		return gray, nil
+	case "bitonal":
+		bitonal := image.NewGray(result.Bounds())
+		for y := 0; y < result.Bounds().Dy(); y++ {
+			for x := 0; x < result.Bounds().Dx(); x++ {
+				if color.GrayModel.Convert(result.At(x, y)).(color.Gray).Y >= 128 {
+					bitonal.SetGray(x, y, color.Gray{Y: 255})
+				}
+			}
+		}
+		return bitonal, nil
+	default:
+		return nil, errors.New(fmt.Sprintf("unsupported quality %s", quality))
+	}
+}
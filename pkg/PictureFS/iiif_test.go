@@ -0,0 +1,161 @@
+package PictureFS
+
+import (
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newTestIIIFHandler serves a 20x10 collage with a red left and a blue right half.
+// The right half is the image dir/a.png
+func newTestIIIFHandler() *IIIFHandler {
+	img := image.NewNRGBA(image.Rect(0, 0, 20, 10))
+	draw.Draw(img, image.Rect(0, 0, 10, 10), image.NewUniform(color.NRGBA{R: 255, A: 255}), image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(10, 0, 20, 10), image.NewUniform(color.NRGBA{B: 255, A: 255}), image.Point{}, draw.Src)
+	layout := Layout{Images: []Rect{{Path: "/dir/a.png", X: 10, Y: 0, Width: 10, Height: 10}}}
+	return NewIIIFHandler(img, layout, "/iiif/", "collage")
+}
+
+func iiifRequest(h http.Handler, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://example.com"+path, nil))
+	return w
+}
+
+func TestIIIFInfo(t *testing.T) {
+	h := newTestIIIFHandler()
+	for path, size := range map[string]image.Point{
+		"/iiif/collage/info.json":     {X: 20, Y: 10},
+		"/iiif/dir%2Fa.png/info.json": {X: 10, Y: 10},
+	} {
+		w := iiifRequest(h, path)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: status %v", path, w.Code)
+		}
+		info := IIIFImageInfo{}
+		if err := json.Unmarshal(w.Body.Bytes(), &info); err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		id := "http://example.com" + path[:len(path)-len("/info.json")]
+		if info.ID != id || info.Width != size.X || info.Height != size.Y || info.Profile != "level2" || info.Context != IIIF_IMAGE_CONTEXT {
+			t.Errorf("%s: unexpected info %+v", path, info)
+		}
+		if info.MaxWidth != iiifMaxSize || info.MaxHeight != iiifMaxSize || info.MaxArea != iiifMaxArea {
+			t.Errorf("%s: unexpected limits %+v", path, info)
+		}
+	}
+	w := iiifRequest(h, "/iiif/collage")
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/iiif/collage/info.json" {
+		t.Errorf("expected redirect to info.json, got %v %s", w.Code, w.Header().Get("Location"))
+	}
+}
+
+func TestIIIFImageRequests(t *testing.T) {
+	h := newTestIIIFHandler()
+	red := color.NRGBA{R: 255, A: 255}
+	blue := color.NRGBA{B: 255, A: 255}
+	for _, test := range []struct {
+		path  string
+		size  image.Point
+		pixel color.NRGBA
+	}{
+		{"/iiif/collage/full/max/0/default.png", image.Point{X: 20, Y: 10}, red},
+		{"/iiif/collage/10,0,10,10/max/0/default.png", image.Point{X: 10, Y: 10}, blue},
+		{"/iiif/collage/5,5,100,100/max/0/default.png", image.Point{X: 15, Y: 5}, red},
+		{"/iiif/collage/pct:50,0,50,100/max/0/default.png", image.Point{X: 10, Y: 10}, blue},
+		{"/iiif/collage/square/max/0/default.png", image.Point{X: 10, Y: 10}, red},
+		{"/iiif/collage/full/10,/0/default.png", image.Point{X: 10, Y: 5}, red},
+		{"/iiif/collage/full/,5/0/default.png", image.Point{X: 10, Y: 5}, red},
+		{"/iiif/collage/full/!8,8/0/default.png", image.Point{X: 8, Y: 4}, red},
+		{"/iiif/collage/full/pct:50/0/default.png", image.Point{X: 10, Y: 5}, red},
+		{"/iiif/collage/full/^40,20/0/default.png", image.Point{X: 40, Y: 20}, red},
+		{"/iiif/collage/full/max/90/default.png", image.Point{X: 10, Y: 20}, red},
+		{"/iiif/collage/full/max/180/default.png", image.Point{X: 20, Y: 10}, blue},
+		{"/iiif/collage/full/max/!0/default.png", image.Point{X: 20, Y: 10}, blue},
+		{"/iiif/collage/full/max/0/gray.png", image.Point{X: 20, Y: 10}, color.NRGBA{R: 76, G: 76, B: 76, A: 255}},
+		{"/iiif/collage/full/max/0/bitonal.png", image.Point{X: 20, Y: 10}, color.NRGBA{A: 255}},
+		{"/iiif/dir%2Fa.png/full/max/0/default.png", image.Point{X: 10, Y: 10}, blue},
+	} {
+		w := iiifRequest(h, test.path)
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: status %v: %s", test.path, w.Code, w.Body.String())
+			continue
+		}
+		if w.Header().Get("Content-Type") != "image/png" {
+			t.Errorf("%s: content type %s", test.path, w.Header().Get("Content-Type"))
+		}
+		img, err := png.Decode(w.Body)
+		if err != nil {
+			t.Errorf("%s: %v", test.path, err)
+			continue
+		}
+		if img.Bounds().Size() != test.size {
+			t.Errorf("%s: size %v, expected %v", test.path, img.Bounds().Size(), test.size)
+		}
+		if c := color.NRGBAModel.Convert(img.At(0, 0)).(color.NRGBA); c != test.pixel {
+			t.Errorf("%s: pixel %v, expected %v", test.path, c, test.pixel)
+		}
+	}
+	if w := iiifRequest(h, "/iiif/collage/full/max/0/default.gif"); w.Code != http.StatusOK || w.Header().Get("Content-Type") != "image/gif" {
+		t.Errorf("gif: status %v, content type %s", w.Code, w.Header().Get("Content-Type"))
+	}
+}
+
+func TestIIIFErrors(t *testing.T) {
+	h := newTestIIIFHandler()
+	for path, status := range map[string]int{
+		"/other/collage/info.json":                        http.StatusNotFound,
+		"/iiif/unknown/info.json":                         http.StatusNotFound,
+		"/iiif/dir/a.png/info.json":                       http.StatusNotFound,
+		"/iiif/collage/full/max":                          http.StatusBadRequest,
+		"/iiif/collage/100,100,5,5/max/0/default.png":     http.StatusBadRequest,
+		"/iiif/collage/0,0,5/max/0/default.png":           http.StatusBadRequest,
+		"/iiif/collage/pct:a,0,5,5/max/0/default.png":     http.StatusBadRequest,
+		"/iiif/collage/full/40,20/0/default.png":          http.StatusBadRequest,
+		"/iiif/collage/full/0,/0/default.png":             http.StatusBadRequest,
+		"/iiif/collage/full/pct:0/0/default.png":          http.StatusBadRequest,
+		"/iiif/collage/full/max/45/default.png":           http.StatusBadRequest,
+		"/iiif/collage/full/max/360/default.png":          http.StatusBadRequest,
+		"/iiif/collage/full/max/0/sepia.png":              http.StatusBadRequest,
+		"/iiif/collage/full/max/0/default.tif":            http.StatusBadRequest,
+		"/iiif/collage/full/max/0/default":                http.StatusBadRequest,
+		"/iiif/collage/full/^100000,100000/0/default.png": http.StatusBadRequest,
+		"/iiif/collage/full/^pct:100000/0/default.png":    http.StatusBadRequest,
+	} {
+		if w := iiifRequest(h, path); w.Code != status {
+			t.Errorf("%s: status %v, expected %v", path, w.Code, status)
+		}
+	}
+}
+
+func TestIIIFMaxSize(t *testing.T) {
+	h := newTestIIIFHandler()
+	h.SetMaxSize(16, 12, 100)
+	for path, status := range map[string]int{
+		"/iiif/collage/full/^16,8/0/default.png":  http.StatusBadRequest,
+		"/iiif/collage/full/^17,4/0/default.png":  http.StatusBadRequest,
+		"/iiif/collage/full/^10,13/0/default.png": http.StatusBadRequest,
+		"/iiif/collage/full/^10,10/0/default.png": http.StatusOK,
+	} {
+		if w := iiifRequest(h, path); w.Code != status {
+			t.Errorf("%s: status %v, expected %v", path, w.Code, status)
+		}
+	}
+	// max is reduced to the limits
+	w := iiifRequest(h, "/iiif/collage/full/max/0/default.png")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %v: %s", w.Code, w.Body.String())
+	}
+	img, err := png.Decode(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := img.Bounds().Size(); size.X > 16 || size.Y > 12 || size.X*size.Y > 100 || size.X != 2*size.Y {
+		t.Errorf("unexpected size %v", size)
+	}
+}
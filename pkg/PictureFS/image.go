@@ -0,0 +1,127 @@
+package PictureFS
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/pkg/errors"
+	"image"
+	"image/color"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// layoutRects returns the rects of the files of the layout by absolute path.
+// Animation frames are not files and are skipped
+func layoutRects(layout Layout) map[string]Rect {
+	var isFrame = frameImages(layout.Animations)
+	var result = map[string]Rect{}
+	for _, rect := range layout.Images {
+		if isFrame[rect.Path] {
+			continue
+		}
+		result["/"+strings.TrimPrefix(filepath.ToSlash(filepath.Clean(rect.Path)), "/")] = rect
+	}
+	return result
+}
+
+// atlasRect returns the region of rect within the atlas
+func atlasRect(rect Rect) image.Rectangle {
+	return image.Rect(rect.X, rect.Y, rect.X+rect.Width, rect.Y+rect.Height)
+}
+
+// imageRect returns the region of the whole image in atlas coordinates. Trimmed images
+// exceed the atlas region by their transparent borders
+func imageRect(rect Rect) image.Rectangle {
+	if !rect.Trimmed() {
+		return atlasRect(rect)
+	}
+	var min = image.Point{X: rect.X - rect.TrimX, Y: rect.Y - rect.TrimY}
+	return image.Rectangle{Min: min, Max: min.Add(image.Point{X: rect.SourceWidth, Y: rect.SourceHeight})}
+}
+
+// trimmedImage is the view of a trimmed image, which adds the transparent borders. Its color
+// model has an alpha channel, even if the atlas is gray or paletted
+type trimmedImage struct {
+	image.Image
+	bounds image.Rectangle
+}
+
+func (ti *trimmedImage) Bounds() image.Rectangle {
+	return ti.bounds
+}
+
+func (ti *trimmedImage) ColorModel() color.Model {
+	switch ti.Image.(type) {
+	case *image.NRGBA64, *image.RGBA64, *image.Gray16:
+		return color.NRGBA64Model
+	}
+	return color.NRGBAModel
+}
+
+func (ti *trimmedImage) At(x, y int) color.Color {
+	if !(image.Point{X: x, Y: y}).In(ti.Image.Bounds()) {
+		return ti.ColorModel().Convert(color.Transparent)
+	}
+	return ti.ColorModel().Convert(ti.Image.At(x, y))
+}
+
+// Bounds returns the region of image name in atlas coordinates. It has the size of the file,
+// so trimmed images include their transparent borders. Unknown images return an empty rectangle
+func (pfs *FS) Bounds(name string) image.Rectangle {
+	rect, ok := pfs.rects[pfs.fullPath(name)]
+	if !ok {
+		return image.Rectangle{}
+	}
+	return imageRect(rect)
+}
+
+// Image returns image name as view of the atlas without copying or encoding the pixels. The bounds
+// of the result are Bounds(name), the pixels are the pixels of the file.
+// Filesystems without atlas (NewFSReader) and animations decode the file instead
+func (pfs *FS) Image(name string) (image.Image, error) {
+	var fullpath = pfs.fullPath(name)
+	rect, ok := pfs.rects[fullpath]
+	if ok && pfs.atlas != nil {
+		sub, ok := pfs.atlas.(interface {
+			SubImage(r image.Rectangle) image.Image
+		})
+		if !ok {
+			return nil, errors.New(fmt.Sprintf("cannot create view of atlas type %T", pfs.atlas))
+		}
+		var view = sub.SubImage(atlasRect(rect))
+		if rect.Trimmed() {
+			return &trimmedImage{Image: view, bounds: imageRect(rect)}, nil
+		}
+		return view, nil
+	}
+	data, ok := pfs.data[fullpath]
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot decode %s", fullpath)
+	}
+	return img, nil
+}
+
+// WalkImages calls fn for every image file of the layout ordered by path. Animation frames, which
+// are only part of the animated files, are skipped. The walk stops at the first error
+func (pfs *FS) WalkImages(fn func(name string, rect Rect) error) error {
+	var prefix = strings.TrimRight(pfs.base, "/") + "/"
+	var names = []string{}
+	for path := range pfs.rects {
+		if strings.HasPrefix(path, prefix) {
+			names = append(names, path)
+		}
+	}
+	sort.Strings(names)
+	for _, path := range names {
+		if err := fn("/"+strings.TrimPrefix(path, prefix), pfs.rects[path]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
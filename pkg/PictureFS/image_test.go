@@ -0,0 +1,167 @@
+package PictureFS
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"io/fs"
+	"testing"
+)
+
+// newTestAtlas returns a 24x16 atlas with distinct opaque pixels and a layout with the plain images
+// a.png and sub/b.png, the trimmed image c.png and the animation anim.gif with two frames
+func newTestAtlas() (*image.NRGBA, Layout) {
+	atlas := image.NewNRGBA(image.Rect(0, 0, 24, 16))
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 24; x++ {
+			atlas.SetNRGBA(x, y, color.NRGBA{R: uint8(x * 10), G: uint8(y * 15), B: 77, A: 255})
+		}
+	}
+	layout := Layout{
+		Images: []Rect{
+			{Path: "/a.png", X: 0, Y: 0, Width: 8, Height: 6},
+			{Path: "/sub/b.png", X: 9, Y: 0, Width: 5, Height: 7},
+			{Path: "/c.png", X: 15, Y: 0, Width: 4, Height: 3, TrimX: 2, TrimY: 1, SourceWidth: 7, SourceHeight: 6},
+			{Path: "/anim.gif/frame-0001.png", X: 0, Y: 8, Width: 4, Height: 4},
+			{Path: "/anim.gif/frame-0002.png", X: 5, Y: 8, Width: 4, Height: 4},
+		},
+		Animations: []Animation{{Path: "/anim.gif", Width: 4, Height: 4, Frames: []Frame{
+			{Path: "/anim.gif/frame-0001.png", Delay: 100},
+			{Path: "/anim.gif/frame-0002.png", Delay: 100},
+		}}},
+	}
+	return atlas, layout
+}
+
+func TestFSImage(t *testing.T) {
+	atlas, layout := newTestAtlas()
+	pfs, err := NewFS(atlas, layout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := &bytes.Buffer{}
+	if err := png.Encode(buf, atlas); err != nil {
+		t.Fatal(err)
+	}
+	streamFS, err := NewFSReader(buf, layout)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	walked := []string{}
+	if err := pfs.WalkImages(func(name string, rect Rect) error {
+		walked = append(walked, name)
+		// Image and Bounds agree with the file
+		data, err := ReadFile(pfs, name[1:])
+		if err != nil {
+			t.Fatalf("cannot read %s: %v", name, err)
+		}
+		file, err := png.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("cannot decode %s: %v", name, err)
+		}
+		bounds := pfs.Bounds(name)
+		if bounds.Size() != file.Bounds().Size() {
+			t.Errorf("%s: bounds %v, file size %v", name, bounds, file.Bounds().Size())
+		}
+		if bounds.Min != image.Pt(rect.X-rect.TrimX, rect.Y-rect.TrimY) {
+			t.Errorf("%s: bounds %v do not start at the atlas position", name, bounds)
+		}
+		view, err := pfs.Image(name)
+		if err != nil {
+			t.Fatalf("cannot get image %s: %v", name, err)
+		}
+		if view.Bounds() != bounds {
+			t.Errorf("%s: expected view bounds %v, got %v", name, bounds, view.Bounds())
+		}
+		for y := 0; y < bounds.Dy(); y++ {
+			for x := 0; x < bounds.Dx(); x++ {
+				a := color.NRGBAModel.Convert(view.At(bounds.Min.X+x, bounds.Min.Y+y))
+				b := color.NRGBAModel.Convert(file.At(x, y))
+				if a != b {
+					t.Fatalf("%s pixel %v/%v: file %v, view %v", name, x, y, b, a)
+				}
+			}
+		}
+		// the view shares the pixels of the atlas
+		if !rect.Trimmed() && &view.(*image.NRGBA).Pix[0] != &atlas.Pix[atlas.PixOffset(rect.X, rect.Y)] {
+			t.Errorf("%s: view is a copy", name)
+		}
+		// filesystems without atlas decode the same file
+		data2, err := ReadFile(streamFS, name[1:])
+		if err != nil {
+			t.Fatalf("cannot read streamed %s: %v", name, err)
+		}
+		if !bytes.Equal(data, data2) {
+			t.Errorf("streamed %s differs", name)
+		}
+		decoded, err := streamFS.Image(name)
+		if err != nil {
+			t.Fatalf("cannot decode image %s: %v", name, err)
+		}
+		if decoded.Bounds().Size() != bounds.Size() {
+			t.Errorf("streamed %s: size %v, expected %v", name, decoded.Bounds().Size(), bounds.Size())
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	// animation frames are not files
+	expected := []string{"/a.png", "/c.png", "/sub/b.png"}
+	if len(walked) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, walked)
+	}
+	for i, name := range expected {
+		if walked[i] != name {
+			t.Errorf("expected %v, got %v", expected, walked)
+		}
+	}
+	if _, err := pfs.Open("anim.gif/frame-0001.png"); err == nil {
+		t.Error("expected error for animation frame")
+	}
+	for _, name := range []string{"missing.png", "anim.gif/frame-0001.png"} {
+		if _, err := pfs.Image(name); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+		if !pfs.Bounds(name).Empty() {
+			t.Errorf("%s: expected empty bounds", name)
+		}
+	}
+	if _, err := pfs.Image("anim.gif"); err != nil {
+		t.Errorf("cannot decode animation: %v", err)
+	}
+	if _, err := fs.Stat(pfs, "sub/b.png"); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestTrimmedImageModel checks the transparent borders of trimmed images of atlases without alpha channel
+func TestTrimmedImageModel(t *testing.T) {
+	layout := Layout{Images: []Rect{{Path: "/a.png", X: 1, Y: 1, Width: 4, Height: 3, TrimX: 2, TrimY: 1, SourceWidth: 7, SourceHeight: 6}}}
+	bounds := image.Rect(0, 0, 8, 8)
+	for _, atlas := range []image.Image{
+		image.NewGray(bounds),
+		image.NewGray16(bounds),
+		image.NewPaletted(bounds, color.Palette{color.Black, color.White}),
+	} {
+		pfs, err := NewFS(atlas, layout)
+		if err != nil {
+			t.Fatal(err)
+		}
+		img, err := pfs.Image("a.png")
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := img.Bounds()
+		if _, _, _, a := img.At(b.Min.X, b.Min.Y).RGBA(); a != 0 {
+			t.Errorf("%T: trimmed border not transparent", atlas)
+		}
+		if _, _, _, a := img.At(b.Min.X+2, b.Min.Y+1).RGBA(); a != 0xffff {
+			t.Errorf("%T: image not opaque", atlas)
+		}
+		if c := img.ColorModel().Convert(color.Transparent); c != img.At(b.Min.X, b.Min.Y) {
+			t.Errorf("%T: border %v not in color model", atlas, img.At(b.Min.X, b.Min.Y))
+		}
+	}
+}
@@ -6,9 +6,73 @@ type Rect struct {
 	Path          string
 	X, Y          int
 	Width, Height int
+	// trimmed images: offset of the content within the source image and size of the source image
+	TrimX, TrimY              int `json:",omitempty"`
+	SourceWidth, SourceHeight int `json:",omitempty"`
+	// rasterized vector images: format and parameters of the rasterization
+	Source *SourceInfo `json:",omitempty"`
+}
+
+// SourceInfo describes the rasterization of a vector image
+type SourceInfo struct {
+	Format string
+	DPI    float64 `json:",omitempty"`
+	// size the image was fitted into
+	Width, Height int `json:",omitempty"`
+}
+
+// Trimmed returns true, if transparent borders were removed from the image
+func (r Rect) Trimmed() bool {
+	return r.SourceWidth > 0 && r.SourceHeight > 0
+}
+
+// UV origins of texture coordinates
+const (
+	UVTopLeft    = "topleft"
+	UVBottomLeft = "bottomleft"
+)
+
+// UVRect contains the normalized texture coordinates of an image.
+// U0/V0 is the corner with the smaller coordinates of the chosen origin
+type UVRect struct {
+	Path           string
+	U0, V0, U1, V1 float64
+}
+
+// UVLayout contains the texture coordinates of all images relative to the size of the atlas
+type UVLayout struct {
+	Origin        string
+	Width, Height int
+	// coordinates are inset by half a texel to avoid bleeding with linear filtering
+	HalfTexel bool `json:",omitempty"`
+	Rects     []UVRect
+}
+
+// Frame references the image of an animation frame. X and Y are the offset within
+// the image Path (filmstrips contain all frames side by side)
+type Frame struct {
+	Path  string
+	X, Y  int `json:",omitempty"`
+	Delay int // milliseconds
+}
+
+// Animation is an animated gif or png image, which is assembled from its frames
+type Animation struct {
+	Path          string
+	Width, Height int
+	// number of times the animation is played (0: infinite)
+	Loops  int `json:",omitempty"`
+	Frames []Frame
 }
 
 type Layout struct {
-	Version string
-	Images  []Rect
+	Version    string
+	Images     []Rect
+	UV         *UVLayout   `json:",omitempty"`
+	Animations []Animation `json:",omitempty"`
+	// icc profile of the color space of the atlas
+	ICCProfile []byte `json:",omitempty"`
+	// multi-scale atlases: scale factor of the atlas and all scales of the collage
+	Scale  int   `json:",omitempty"`
+	Scales []int `json:",omitempty"`
 }
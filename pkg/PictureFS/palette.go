@@ -0,0 +1,150 @@
+package PictureFS
+
+import (
+	"image"
+	"image/color"
+	"sort"
+)
+
+// SortedPalette returns the colors of hist ordered by frequency
+func SortedPalette(hist map[color.NRGBA]int) color.Palette {
+	var colors = make([]color.NRGBA, 0, len(hist))
+	for c := range hist {
+		colors = append(colors, c)
+	}
+	sort.Slice(colors, func(i, j int) bool {
+		if hist[colors[i]] != hist[colors[j]] {
+			return hist[colors[i]] > hist[colors[j]]
+		}
+		return colorKey(colors[i]) < colorKey(colors[j])
+	})
+	var palette = make(color.Palette, 0, len(colors))
+	for _, c := range colors {
+		palette = append(palette, c)
+	}
+	return palette
+}
+
+func colorKey(c color.NRGBA) uint32 {
+	return uint32(c.R)<<24 | uint32(c.G)<<16 | uint32(c.B)<<8 | uint32(c.A)
+}
+
+// MedianCut is a median cut quantizer. It implements draw.Quantizer
+type MedianCut struct{}
+
+type colorCount struct {
+	c     color.NRGBA
+	count int
+}
+
+// colorBox is a set of colors of the median cut algorithm
+type colorBox []colorCount
+
+func channel(c color.NRGBA, ch int) uint8 {
+	switch ch {
+	case 0:
+		return c.R
+	case 1:
+		return c.G
+	case 2:
+		return c.B
+	default:
+		return c.A
+	}
+}
+
+// widest returns the channel with the largest range and the range
+func (b colorBox) widest() (int, int) {
+	var bestCh, bestRange = 0, -1
+	for ch := 0; ch < 4; ch++ {
+		var min, max = 255, 0
+		for _, cc := range b {
+			v := int(channel(cc.c, ch))
+			if v < min {
+				min = v
+			}
+			if v > max {
+				max = v
+			}
+		}
+		if max-min > bestRange {
+			bestCh, bestRange = ch, max-min
+		}
+	}
+	return bestCh, bestRange
+}
+
+// average returns the weighted mean color of the box
+func (b colorBox) average() color.NRGBA {
+	var sum [4]int
+	var total int
+	for _, cc := range b {
+		for ch := 0; ch < 4; ch++ {
+			sum[ch] += int(channel(cc.c, ch)) * cc.count
+		}
+		total += cc.count
+	}
+	return color.NRGBA{
+		R: uint8((sum[0] + total/2) / total),
+		G: uint8((sum[1] + total/2) / total),
+		B: uint8((sum[2] + total/2) / total),
+		A: uint8((sum[3] + total/2) / total),
+	}
+}
+
+// Quantize appends up to cap(p) - len(p) colors of m to p
+func (MedianCut) Quantize(p color.Palette, m image.Image) color.Palette {
+	var n = cap(p) - len(p)
+	if n <= 0 {
+		return p
+	}
+	var hist = map[color.NRGBA]int{}
+	var b = m.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			hist[color.NRGBAModel.Convert(m.At(x, y)).(color.NRGBA)]++
+		}
+	}
+	if len(hist) <= n {
+		return append(p, SortedPalette(hist)...)
+	}
+	var all = colorBox{}
+	for c, count := range hist {
+		all = append(all, colorCount{c: c, count: count})
+	}
+	sort.Slice(all, func(i, j int) bool { return colorKey(all[i].c) < colorKey(all[j].c) })
+	var boxes = []colorBox{all}
+	for len(boxes) < n {
+		// split the box with the widest channel range
+		var best, bestCh, bestRange = -1, 0, 0
+		for i, box := range boxes {
+			if len(box) < 2 {
+				continue
+			}
+			if ch, r := box.widest(); r > bestRange {
+				best, bestCh, bestRange = i, ch, r
+			}
+		}
+		if best < 0 {
+			break
+		}
+		var box = boxes[best]
+		sort.SliceStable(box, func(i, j int) bool { return channel(box[i].c, bestCh) < channel(box[j].c, bestCh) })
+		var total int
+		for _, cc := range box {
+			total += cc.count
+		}
+		// split at the weighted median, both halves keep at least one color
+		var split, sum = 1, box[0].count
+		for split < len(box)-1 && sum < total/2 {
+			sum += box[split].count
+			split++
+		}
+		boxes[best] = box[:split]
+		boxes = append(boxes, box[split:])
+	}
+	for _, box := range boxes {
+		p = append(p, box.average())
+	}
+	return p
+}
@@ -0,0 +1,376 @@
+package PictureFS
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"github.com/pkg/errors"
+	"image"
+	"image/color"
+	"io"
+)
+
+// pngRowReader decodes a non-interlaced png image row by row.
+// Only two rows of the image are held in memory
+type pngRowReader struct {
+	r             *bufio.Reader
+	width, height int
+	bitDepth      int
+	colorType     int
+	palette       color.Palette
+	trns          []byte
+	idat          *idatReader
+	zr            io.ReadCloser
+	bpp           int
+	cur, prev     []byte
+	y             int
+}
+
+const (
+	pngGray      = 0
+	pngRGB       = 2
+	pngPaletted  = 3
+	pngGrayAlpha = 4
+	pngRGBA      = 6
+)
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// idatReader concatenates the data of consecutive IDAT chunks
+type idatReader struct {
+	r         *bufio.Reader
+	remaining uint32
+	done      bool
+}
+
+func (ir *idatReader) Read(p []byte) (int, error) {
+	for ir.remaining == 0 {
+		if ir.done {
+			return 0, io.EOF
+		}
+		// crc of previous chunk
+		if _, err := io.ReadFull(ir.r, make([]byte, 4)); err != nil {
+			return 0, err
+		}
+		length, typ, err := readChunkHeader(ir.r)
+		if err != nil {
+			return 0, err
+		}
+		if typ != "IDAT" {
+			ir.done = true
+			return 0, io.EOF
+		}
+		ir.remaining = length
+	}
+	if uint32(len(p)) > ir.remaining {
+		p = p[:ir.remaining]
+	}
+	n, err := ir.r.Read(p)
+	ir.remaining -= uint32(n)
+	return n, err
+}
+
+func readChunkHeader(r io.Reader) (uint32, string, error) {
+	var buf = make([]byte, 8)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return 0, "", errors.Wrap(err, "cannot read png chunk header")
+	}
+	return binary.BigEndian.Uint32(buf[0:4]), string(buf[4:8]), nil
+}
+
+func newPNGRowReader(r io.Reader) (*pngRowReader, error) {
+	pr := &pngRowReader{
+		r: bufio.NewReader(r),
+	}
+	var sig = make([]byte, len(pngSignature))
+	if _, err := io.ReadFull(pr.r, sig); err != nil {
+		return nil, errors.Wrap(err, "cannot read png signature")
+	}
+	if !bytes.Equal(sig, pngSignature) {
+		return nil, errors.New("not a png image")
+	}
+	for {
+		length, typ, err := readChunkHeader(pr.r)
+		if err != nil {
+			return nil, err
+		}
+		if typ == "IDAT" {
+			if pr.width == 0 {
+				return nil, errors.New("missing png header")
+			}
+			pr.idat = &idatReader{r: pr.r, remaining: length}
+			break
+		}
+		var data = make([]byte, length+4)
+		if _, err := io.ReadFull(pr.r, data); err != nil {
+			return nil, errors.Wrapf(err, "cannot read png chunk %s", typ)
+		}
+		data = data[:length]
+		switch typ {
+		case "IHDR":
+			if len(data) != 13 {
+				return nil, errors.New("invalid png header")
+			}
+			pr.width = int(binary.BigEndian.Uint32(data[0:4]))
+			pr.height = int(binary.BigEndian.Uint32(data[4:8]))
+			pr.bitDepth = int(data[8])
+			pr.colorType = int(data[9])
+			if data[12] != 0 {
+				return nil, errors.New("interlaced png images are not supported")
+			}
+		case "PLTE":
+			pr.palette = color.Palette{}
+			for i := 0; i+2 < len(data); i += 3 {
+				pr.palette = append(pr.palette, color.NRGBA{R: data[i], G: data[i+1], B: data[i+2], A: 0xff})
+			}
+		case "tRNS":
+			pr.trns = data
+		}
+	}
+	var channels int
+	switch pr.colorType {
+	case pngGray, pngPaletted:
+		channels = 1
+	case pngGrayAlpha:
+		channels = 2
+	case pngRGB:
+		channels = 3
+	case pngRGBA:
+		channels = 4
+	default:
+		return nil, errors.New(fmt.Sprintf("invalid png color type %v", pr.colorType))
+	}
+	if pr.bitDepth < 8 && pr.colorType != pngGray && pr.colorType != pngPaletted || pr.bitDepth > 16 {
+		return nil, errors.New(fmt.Sprintf("invalid png bit depth %v for color type %v", pr.bitDepth, pr.colorType))
+	}
+	if pr.colorType == pngPaletted {
+		for i := 0; i < len(pr.trns) && i < len(pr.palette); i++ {
+			c := pr.palette[i].(color.NRGBA)
+			c.A = pr.trns[i]
+			pr.palette[i] = c
+		}
+	}
+	pr.bpp = (channels*pr.bitDepth + 7) / 8
+	var rowBytes = (pr.width*channels*pr.bitDepth + 7) / 8
+	pr.cur = make([]byte, rowBytes+1)
+	pr.prev = make([]byte, rowBytes+1)
+	zr, err := zlib.NewReader(pr.idat)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot read png data")
+	}
+	pr.zr = zr
+	return pr, nil
+}
+
+func (pr *pngRowReader) Bounds() image.Rectangle {
+	return image.Rect(0, 0, pr.width, pr.height)
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// unfilter reverses the png filter of the current row
+func (pr *pngRowReader) unfilter() error {
+	var cdat = pr.cur[1:]
+	var pdat = pr.prev[1:]
+	var bpp = pr.bpp
+	switch pr.cur[0] {
+	case 0:
+	case 1:
+		for i := bpp; i < len(cdat); i++ {
+			cdat[i] += cdat[i-bpp]
+		}
+	case 2:
+		for i, p := range pdat {
+			cdat[i] += p
+		}
+	case 3:
+		for i := 0; i < bpp; i++ {
+			cdat[i] += pdat[i] / 2
+		}
+		for i := bpp; i < len(cdat); i++ {
+			cdat[i] += uint8((int(cdat[i-bpp]) + int(pdat[i])) / 2)
+		}
+	case 4:
+		for i := range cdat {
+			var a, c int
+			if i >= bpp {
+				a = int(cdat[i-bpp])
+				c = int(pdat[i-bpp])
+			}
+			var b = int(pdat[i])
+			var p = a + b - c
+			var pa, pb, pc = abs(p - a), abs(p - b), abs(p - c)
+			if pa <= pb && pa <= pc {
+				cdat[i] += uint8(a)
+			} else if pb <= pc {
+				cdat[i] += uint8(b)
+			} else {
+				cdat[i] += uint8(c)
+			}
+		}
+	default:
+		return errors.New(fmt.Sprintf("invalid png filter type %v", pr.cur[0]))
+	}
+	return nil
+}
+
+// sample returns the n-th sample of the current row scaled to 8 bit
+func (pr *pngRowReader) sample(n int) uint8 {
+	var cdat = pr.cur[1:]
+	switch pr.bitDepth {
+	case 8:
+		return cdat[n]
+	case 16:
+		return cdat[2*n]
+	default:
+		var bit = n * pr.bitDepth
+		var mask = byte(1<<pr.bitDepth - 1)
+		var v = (cdat[bit/8] >> (8 - pr.bitDepth - bit%8)) & mask
+		if pr.colorType == pngPaletted {
+			return v
+		}
+		return uint8(int(v) * 255 / int(mask))
+	}
+}
+
+// raw returns the n-th sample of the current row without scaling
+func (pr *pngRowReader) raw(n int) uint16 {
+	var cdat = pr.cur[1:]
+	switch pr.bitDepth {
+	case 16:
+		return binary.BigEndian.Uint16(cdat[2*n:])
+	case 8:
+		return uint16(cdat[n])
+	default:
+		var bit = n * pr.bitDepth
+		return uint16((cdat[bit/8] >> (8 - pr.bitDepth - bit%8)) & byte(1<<pr.bitDepth-1))
+	}
+}
+
+// next reads and unfilters the next row
+func (pr *pngRowReader) next() error {
+	if pr.y >= pr.height {
+		return io.EOF
+	}
+	pr.prev, pr.cur = pr.cur, pr.prev
+	if _, err := io.ReadFull(pr.zr, pr.cur); err != nil {
+		return errors.Wrapf(err, "cannot read png row %v", pr.y)
+	}
+	if err := pr.unfilter(); err != nil {
+		return err
+	}
+	pr.y++
+	return nil
+}
+
+// paletteColor returns the palette entry of the n-th sample of the current row
+func (pr *pngRowReader) paletteColor(n int) (color.NRGBA, error) {
+	var i = int(pr.raw(n))
+	if i >= len(pr.palette) {
+		return color.NRGBA{}, errors.New(fmt.Sprintf("invalid palette index %v", i))
+	}
+	return pr.palette[i].(color.NRGBA), nil
+}
+
+// transparent checks whether the n-th pixel of the current row has the tRNS color
+func (pr *pngRowReader) transparent(x int) bool {
+	switch pr.colorType {
+	case pngGray:
+		return len(pr.trns) >= 2 && pr.raw(x) == binary.BigEndian.Uint16(pr.trns)
+	case pngRGB:
+		return len(pr.trns) >= 6 &&
+			pr.raw(3*x) == binary.BigEndian.Uint16(pr.trns[0:]) &&
+			pr.raw(3*x+1) == binary.BigEndian.Uint16(pr.trns[2:]) &&
+			pr.raw(3*x+2) == binary.BigEndian.Uint16(pr.trns[4:])
+	}
+	return false
+}
+
+// ReadRow decodes the next row into the first row of dst, which has to be pr.width pixels wide.
+// 16 bit samples are reduced to 8 bit
+func (pr *pngRowReader) ReadRow(dst *image.NRGBA) error {
+	if err := pr.next(); err != nil {
+		return err
+	}
+	for x := 0; x < pr.width; x++ {
+		var c = color.NRGBA{A: 0xff}
+		switch pr.colorType {
+		case pngGray:
+			c.R = pr.sample(x)
+			c.G, c.B = c.R, c.R
+		case pngGrayAlpha:
+			c.R = pr.sample(2 * x)
+			c.G, c.B = c.R, c.R
+			c.A = pr.sample(2*x + 1)
+		case pngRGB:
+			c.R, c.G, c.B = pr.sample(3*x), pr.sample(3*x+1), pr.sample(3*x+2)
+		case pngRGBA:
+			c.R, c.G, c.B, c.A = pr.sample(4*x), pr.sample(4*x+1), pr.sample(4*x+2), pr.sample(4*x+3)
+		case pngPaletted:
+			var err error
+			if c, err = pr.paletteColor(x); err != nil {
+				return err
+			}
+		}
+		if pr.transparent(x) {
+			c.A = 0
+		}
+		dst.SetNRGBA(dst.Rect.Min.X+x, dst.Rect.Min.Y, c)
+	}
+	return nil
+}
+
+// sample16 returns the n-th sample of the current row scaled to 16 bit
+func (pr *pngRowReader) sample16(n int) uint16 {
+	var v = pr.raw(n)
+	switch pr.bitDepth {
+	case 16:
+		return v
+	case 8:
+		return v * 0x101
+	default:
+		return uint16(int(v) * 0xffff / (1<<pr.bitDepth - 1))
+	}
+}
+
+// ReadRow64 decodes the next row with 16 bit per channel into the first row of dst,
+// which has to be pr.width pixels wide
+func (pr *pngRowReader) ReadRow64(dst *image.NRGBA64) error {
+	if err := pr.next(); err != nil {
+		return err
+	}
+	for x := 0; x < pr.width; x++ {
+		var c = color.NRGBA64{A: 0xffff}
+		switch pr.colorType {
+		case pngGray:
+			c.R = pr.sample16(x)
+			c.G, c.B = c.R, c.R
+		case pngGrayAlpha:
+			c.R = pr.sample16(2 * x)
+			c.G, c.B = c.R, c.R
+			c.A = pr.sample16(2*x + 1)
+		case pngRGB:
+			c.R, c.G, c.B = pr.sample16(3*x), pr.sample16(3*x+1), pr.sample16(3*x+2)
+		case pngRGBA:
+			c.R, c.G, c.B, c.A = pr.sample16(4*x), pr.sample16(4*x+1), pr.sample16(4*x+2), pr.sample16(4*x+3)
+		case pngPaletted:
+			p, err := pr.paletteColor(x)
+			if err != nil {
+				return err
+			}
+			c = color.NRGBA64{R: uint16(p.R) * 0x101, G: uint16(p.G) * 0x101, B: uint16(p.B) * 0x101, A: uint16(p.A) * 0x101}
+		}
+		if pr.transparent(x) {
+			c.A = 0
+		}
+		dst.SetNRGBA64(dst.Rect.Min.X+x, dst.Rect.Min.Y, c)
+	}
+	return nil
+}
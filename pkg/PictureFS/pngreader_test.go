@@ -0,0 +1,93 @@
+package PictureFS
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+func TestFSReaderDepth16(t *testing.T) {
+	atlas := image.NewNRGBA64(image.Rect(0, 0, 16, 8))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 16; x++ {
+			// low bytes which are lost with 8 bit
+			atlas.SetNRGBA64(x, y, color.NRGBA64{R: uint16(x*4096 + 1), G: uint16(y*8192 + 3), B: 0x1234, A: uint16(0xff00 + x)})
+		}
+	}
+	buf := &bytes.Buffer{}
+	if err := png.Encode(buf, atlas); err != nil {
+		t.Fatal(err)
+	}
+	layout := Layout{Images: []Rect{
+		{Path: "/a.png", X: 0, Y: 0, Width: 8, Height: 8},
+		{Path: "/b.png", X: 8, Y: 2, Width: 8, Height: 4},
+	}}
+	pfs, err := NewFSReader(bytes.NewReader(buf.Bytes()), layout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rect := range layout.Images {
+		data, err := ReadFile(pfs, rect.Path[1:])
+		if err != nil {
+			t.Fatalf("cannot read %s: %v", rect.Path, err)
+		}
+		img, err := png.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("cannot decode %s: %v", rect.Path, err)
+		}
+		deep, ok := img.(*image.NRGBA64)
+		if !ok {
+			t.Fatalf("%s: expected 16 bit image, got %T", rect.Path, img)
+		}
+		for y := 0; y < rect.Height; y++ {
+			for x := 0; x < rect.Width; x++ {
+				if c, expected := deep.NRGBA64At(x, y), atlas.NRGBA64At(rect.X+x, rect.Y+y); c != expected {
+					t.Fatalf("%s: pixel %v/%v is %v, expected %v", rect.Path, x, y, c, expected)
+				}
+			}
+		}
+	}
+}
+
+func TestPNGRowReader(t *testing.T) {
+	gray := image.NewGray(image.Rect(0, 0, 5, 2))
+	for i := range gray.Pix {
+		gray.Pix[i] = uint8(i * 25)
+	}
+	paletted := image.NewPaletted(image.Rect(0, 0, 5, 2), color.Palette{color.NRGBA{R: 255, A: 255}, color.NRGBA{B: 255, A: 128}})
+	paletted.Pix[3] = 1
+	for _, img := range []image.Image{gray, paletted, image.NewGray16(image.Rect(0, 0, 5, 2))} {
+		buf := &bytes.Buffer{}
+		if err := png.Encode(buf, img); err != nil {
+			t.Fatal(err)
+		}
+		rr, err := newPNGRowReader(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		row := image.NewNRGBA(image.Rect(0, 0, 5, 1))
+		row64 := image.NewNRGBA64(image.Rect(0, 0, 5, 1))
+		for y := 0; y < 2; y++ {
+			if rr.bitDepth == 16 {
+				err = rr.ReadRow64(row64)
+			} else {
+				err = rr.ReadRow(row)
+			}
+			if err != nil {
+				t.Fatalf("%T: %v", img, err)
+			}
+			for x := 0; x < 5; x++ {
+				var c color.Color = row.NRGBAAt(x, 0)
+				expected := color.NRGBAModel.Convert(img.At(x, y))
+				if rr.bitDepth == 16 {
+					c, expected = row64.NRGBA64At(x, 0), color.NRGBA64Model.Convert(img.At(x, y))
+				}
+				if c != expected {
+					t.Errorf("%T: pixel %v/%v is %v, expected %v", img, x, y, c, expected)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package PictureFS
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// largest scale factor NewFSFileScale looks for
+const maxScale = 16
+
+// ScaledPath returns the name of the file of scale, e.g. collage.png -> collage@2x.png and
+// collage.png.json -> collage@2x.png.json. Scale 1 returns path
+func ScaledPath(path string, scale int) string {
+	if scale == 1 {
+		return path
+	}
+	var dir, base = filepath.Split(path)
+	var stem, ext = base, ""
+	if i := strings.Index(base, "."); i > 0 {
+		stem, ext = base[:i], base[i:]
+	}
+	return dir + fmt.Sprintf("%s@%vx%s", stem, scale, ext)
+}
+
+// SelectScale returns the best of the available scales for scale: the smallest scale >= scale
+// (downscaled images look better than upscaled ones) or the largest available scale
+func SelectScale(available []int, scale int) int {
+	var result = 0
+	for _, s := range available {
+		switch {
+		case s >= scale && (result < scale || s < result):
+			result = s
+		case s < scale && result < scale && s > result:
+			result = s
+		}
+	}
+	return result
+}
+
+// NewFSFileScale creates the filesystem of the multi-scale atlas img and layout (as written by collage -scales),
+// which matches scale best (see SelectScale)
+func NewFSFileScale(img string, layout string, scale int) (*FS, error) {
+	var available = []int{}
+	for s := 1; s <= maxScale; s++ {
+		if _, err := os.Stat(ScaledPath(layout, s)); err == nil {
+			available = append(available, s)
+		}
+	}
+	if len(available) == 0 {
+		return nil, errors.New(fmt.Sprintf("no scaled layout of %s found", layout))
+	}
+	var s = SelectScale(available, scale)
+	return NewFSFile(ScaledPath(img, s), ScaledPath(layout, s))
+}
+
+// Scale returns the scale factor of the atlas
+func (pfs *FS) Scale() int {
+	if pfs.scale <= 0 {
+		return 1
+	}
+	return pfs.scale
+}
@@ -0,0 +1,257 @@
+package PictureFS
+
+import (
+	"bytes"
+	"container/list"
+	"fmt"
+	"github.com/disintegration/imaging"
+	"github.com/pkg/errors"
+	"io/fs"
+	"math"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// maximum width and height of a transformed image
+const variantMaxSize = 8192
+
+// default number of cached variants
+const variantCacheSize = 256
+
+// default maximum size of the cached variants
+const variantCacheBytes = 64 << 20
+
+var scalePattern = regexp.MustCompile(`^(.+)@([0-9]*\.?[0-9]+)x$`)
+
+// variant is a virtual file, which is rendered from an image of the filesystem:
+// /img/a.png@2x (scale), /img/a.png?w=64&h=32 (fit into size), /img/a.jpg (format of another
+// extension) and /img/a.png#gray (filters gray, invert, fliph, flipv, rotate90, rotate180, rotate270)
+type variant struct {
+	path          string
+	scale         float64
+	width, height int
+	filters       []string
+}
+
+// parseVariant splits the absolute path name into the path of the file and the transformations
+func parseVariant(name string) (*variant, error) {
+	var v = &variant{path: name}
+	if i := strings.LastIndex(v.path, "#"); i >= 0 {
+		for _, filter := range strings.Split(v.path[i+1:], ",") {
+			switch filter {
+			case "gray", "invert", "fliph", "flipv", "rotate90", "rotate180", "rotate270":
+				v.filters = append(v.filters, filter)
+			default:
+				return nil, errors.New(fmt.Sprintf("invalid filter %s", filter))
+			}
+		}
+		v.path = v.path[:i]
+	}
+	if i := strings.Index(v.path, "?"); i >= 0 {
+		query, err := url.ParseQuery(v.path[i+1:])
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid query %s", v.path[i+1:])
+		}
+		for key, values := range query {
+			size, err := strconv.Atoi(values[0])
+			if err != nil || size <= 0 || size > variantMaxSize {
+				return nil, errors.New(fmt.Sprintf("invalid size %s=%s", key, values[0]))
+			}
+			switch key {
+			case "w":
+				v.width = size
+			case "h":
+				v.height = size
+			default:
+				return nil, errors.New(fmt.Sprintf("invalid parameter %s", key))
+			}
+		}
+		v.path = v.path[:i]
+	}
+	if m := scalePattern.FindStringSubmatch(v.path); m != nil {
+		scale, err := strconv.ParseFloat(m[2], 64)
+		if err != nil || scale <= 0 {
+			return nil, errors.New(fmt.Sprintf("invalid scale %s", m[2]))
+		}
+		v.path, v.scale = m[1], scale
+	}
+	return v, nil
+}
+
+// size returns the size of the transformed image with the source size w x h
+func (v *variant) size(w, h int) (int, int) {
+	var fw, fh = float64(w), float64(h)
+	switch {
+	case v.width > 0 && v.height > 0:
+		var r = math.Min(float64(v.width)/fw, float64(v.height)/fh)
+		fw, fh = fw*r, fh*r
+	case v.width > 0:
+		fw, fh = float64(v.width), fh*float64(v.width)/fw
+	case v.height > 0:
+		fw, fh = fw*float64(v.height)/fh, float64(v.height)
+	}
+	if v.scale > 0 {
+		fw, fh = fw*v.scale, fh*v.scale
+	}
+	return int(math.Max(1, math.Round(fw))), int(math.Max(1, math.Round(fh)))
+}
+
+// variantCache keeps the most recently used encoded variants. It is shared by the filesystems of Sub
+type variantCache struct {
+	sync.Mutex
+	entries  map[string]*list.Element
+	lru      *list.List
+	size     int64
+	max      int
+	maxBytes int64
+}
+
+type variantEntry struct {
+	name string
+	data []byte
+}
+
+func newVariantCache() *variantCache {
+	return &variantCache{
+		entries:  map[string]*list.Element{},
+		lru:      list.New(),
+		max:      variantCacheSize,
+		maxBytes: variantCacheBytes,
+	}
+}
+
+func (vc *variantCache) get(name string) ([]byte, bool) {
+	vc.Lock()
+	defer vc.Unlock()
+	elem, ok := vc.entries[name]
+	if !ok {
+		return nil, false
+	}
+	vc.lru.MoveToFront(elem)
+	return elem.Value.(*variantEntry).data, true
+}
+
+func (vc *variantCache) put(name string, data []byte) {
+	vc.Lock()
+	defer vc.Unlock()
+	if vc.max <= 0 || int64(len(data)) > vc.maxBytes {
+		return
+	}
+	if elem, ok := vc.entries[name]; ok {
+		vc.remove(elem)
+	}
+	vc.entries[name] = vc.lru.PushFront(&variantEntry{name: name, data: data})
+	vc.size += int64(len(data))
+	vc.evict()
+}
+
+// evict removes the least recently used entries, until the cache fits into its limits
+func (vc *variantCache) evict() {
+	for vc.lru.Len() > 0 && (vc.lru.Len() > vc.max || vc.size > vc.maxBytes) {
+		vc.remove(vc.lru.Back())
+	}
+}
+
+func (vc *variantCache) remove(elem *list.Element) {
+	var entry = vc.lru.Remove(elem).(*variantEntry)
+	delete(vc.entries, entry.name)
+	vc.size -= int64(len(entry.data))
+}
+
+// SetVariantCacheSize sets the maximum number of cached transformed images (0: no cache)
+func (pfs *FS) SetVariantCacheSize(n int) {
+	pfs.variants.Lock()
+	defer pfs.variants.Unlock()
+	pfs.variants.max = n
+	pfs.variants.evict()
+}
+
+// SetVariantCacheBytes sets the maximum total size of the cached transformed images
+func (pfs *FS) SetVariantCacheBytes(n int64) {
+	pfs.variants.Lock()
+	defer pfs.variants.Unlock()
+	pfs.variants.maxBytes = n
+	pfs.variants.evict()
+}
+
+// variantSource returns the file, which is the source of the variant path. Paths with
+// another extension use the file with the same name
+func (pfs *FS) variantSource(path string) (string, bool) {
+	if pfs.hasFile(path) {
+		return path, true
+	}
+	var stem = strings.TrimSuffix(path, filepath.Ext(path))
+	var candidates = []string{}
+	for name := range pfs.data {
+		if strings.TrimSuffix(name, filepath.Ext(name)) == stem {
+			candidates = append(candidates, name)
+		}
+	}
+	if len(candidates) == 0 {
+		return "", false
+	}
+	sort.Strings(candidates)
+	return candidates[0], true
+}
+
+// openVariant renders the variant name of an image. Names, which are no valid variant
+// of an image, return fs.ErrNotExist
+func (pfs *FS) openVariant(name string) ([]byte, error) {
+	if data, ok := pfs.variants.get(name); ok {
+		return data, nil
+	}
+	v, err := parseVariant(name)
+	if err != nil {
+		return nil, fs.ErrNotExist
+	}
+	var ext = strings.ToLower(filepath.Ext(v.path))
+	switch ext {
+	case ".png", ".jpg", ".jpeg", ".gif", ".tif", ".tiff", ".bmp", ".webp":
+	default:
+		return nil, fs.ErrNotExist
+	}
+	source, ok := pfs.variantSource(v.path)
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+	img, err := pfs.Image(source)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot load %s", source)
+	}
+	var w, h = v.size(img.Bounds().Dx(), img.Bounds().Dy())
+	if w > variantMaxSize || h > variantMaxSize {
+		return nil, errors.New(fmt.Sprintf("variant %s too large: %vx%v", name, w, h))
+	}
+	if w != img.Bounds().Dx() || h != img.Bounds().Dy() {
+		img = imaging.Resize(img, w, h, imaging.Lanczos)
+	}
+	for _, filter := range v.filters {
+		switch filter {
+		case "gray":
+			img = imaging.Grayscale(img)
+		case "invert":
+			img = imaging.Invert(img)
+		case "fliph":
+			img = imaging.FlipH(img)
+		case "flipv":
+			img = imaging.FlipV(img)
+		case "rotate90":
+			img = imaging.Rotate90(img)
+		case "rotate180":
+			img = imaging.Rotate180(img)
+		case "rotate270":
+			img = imaging.Rotate270(img)
+		}
+	}
+	var data = &bytes.Buffer{}
+	if err := pfs.encodeImage(data, img, ext); err != nil {
+		return nil, errors.Wrapf(err, "cannot encode %s", name)
+	}
+	pfs.variants.put(name, data.Bytes())
+	return data.Bytes(), nil
+}
@@ -0,0 +1,106 @@
+package PictureFS
+
+import (
+	"bytes"
+	"github.com/pkg/errors"
+	_ "golang.org/x/image/webp"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif"
+	_ "image/jpeg"
+	"io/fs"
+	"testing"
+)
+
+func TestVariants(t *testing.T) {
+	atlas := image.NewNRGBA(image.Rect(0, 0, 10, 8))
+	draw.Draw(atlas, image.Rect(1, 1, 9, 7), image.NewUniform(color.NRGBA{R: 200, G: 40, B: 10, A: 255}), image.Point{}, draw.Src)
+	pfs, err := NewFS(atlas, Layout{Images: []Rect{{Path: "/img/a.png", X: 1, Y: 1, Width: 8, Height: 6}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name          string
+		format        string
+		width, height int
+		gray          bool
+	}{
+		{"img/a.png@2x", "png", 16, 12, false},
+		{"img/a.png@0.5x", "png", 4, 3, false},
+		{"img/a.png?w=4", "png", 4, 3, false},
+		{"img/a.png?w=32&h=12", "png", 16, 12, false},
+		{"img/a.jpg", "jpeg", 8, 6, false},
+		{"img/a.gif?h=3#gray", "gif", 4, 3, true},
+		{"img/a.png#gray,fliph", "png", 8, 6, true},
+		{"img/a.webp", "webp", 8, 6, false},
+		{"img/a.webp@0.5x#gray", "webp", 4, 3, true},
+	}
+	for _, test := range tests {
+		data, err := ReadFile(pfs, test.name)
+		if err != nil {
+			t.Fatalf("cannot read %s: %v", test.name, err)
+		}
+		img, format, err := image.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("cannot decode %s: %v", test.name, err)
+		}
+		if format != test.format {
+			t.Errorf("%s: expected format %s, got %s", test.name, test.format, format)
+		}
+		if img.Bounds().Dx() != test.width || img.Bounds().Dy() != test.height {
+			t.Errorf("%s: expected %vx%v, got %v", test.name, test.width, test.height, img.Bounds())
+		}
+		c := color.NRGBAModel.Convert(img.At(img.Bounds().Min.X+1, img.Bounds().Min.Y+1)).(color.NRGBA)
+		if gray := c.R == c.G && c.G == c.B; gray != test.gray {
+			t.Errorf("%s: unexpected color %v", test.name, c)
+		}
+	}
+	// invalid names do not exist
+	for _, name := range []string{"img/a.png#blur", "img/a.png?w=0", "img/a.png?q=1", "img/b.png@2x", "img/a.txt"} {
+		_, err := pfs.Open(name)
+		var pathErr *fs.PathError
+		if !errors.As(err, &pathErr) || pathErr.Op != "open" || pathErr.Path != name || !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("%s: expected not exist error, got %v", name, err)
+		}
+	}
+	if _, err := ReadFile(pfs, "img/a.png@100000x"); err == nil {
+		t.Error("expected error for too large variant")
+	}
+}
+
+func TestVariantCache(t *testing.T) {
+	vc := newVariantCache()
+	vc.max, vc.maxBytes = 3, 10
+	vc.put("a", make([]byte, 4))
+	vc.put("b", make([]byte, 4))
+	// a is used more recently than b
+	if _, ok := vc.get("a"); !ok {
+		t.Fatal("a not cached")
+	}
+	vc.put("c", make([]byte, 4))
+	if _, ok := vc.get("b"); ok {
+		t.Error("least recently used b not evicted")
+	}
+	for _, name := range []string{"a", "c"} {
+		if _, ok := vc.get(name); !ok {
+			t.Errorf("%s not cached", name)
+		}
+	}
+	if vc.size != 8 {
+		t.Errorf("size %v, expected 8", vc.size)
+	}
+	// larger than the whole cache
+	vc.put("d", make([]byte, 11))
+	if _, ok := vc.get("d"); ok || vc.size != 8 {
+		t.Error("entry larger than the cache cached")
+	}
+	vc.put("e", make([]byte, 1))
+	vc.put("f", make([]byte, 1))
+	if vc.lru.Len() != 3 || len(vc.entries) != 3 {
+		t.Errorf("%v entries, expected 3", vc.lru.Len())
+	}
+	if _, ok := vc.get("a"); ok {
+		t.Error("a not evicted")
+	}
+}
@@ -0,0 +1,290 @@
+package PictureFS
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/pkg/errors"
+	"image"
+	"image/draw"
+	"io"
+	"sort"
+)
+
+// maximum width and height of a webp image
+const webpMaxSize = 1 << 14
+
+// alphabet sizes of the green (with backward reference lengths), red, blue, alpha and distance codes
+var webpAlphabetSizes = [5]int{256 + 24, 256, 256, 256, 40}
+
+// order of the code lengths of the code length code
+var webpCodeLengthOrder = [19]int{17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+// webpBitWriter writes bits starting with the least significant bit
+type webpBitWriter struct {
+	buf   bytes.Buffer
+	bits  uint64
+	nbits uint
+}
+
+func (bw *webpBitWriter) write(v uint32, n uint) {
+	bw.bits |= uint64(v) << bw.nbits
+	bw.nbits += n
+	for bw.nbits >= 8 {
+		bw.buf.WriteByte(byte(bw.bits))
+		bw.bits >>= 8
+		bw.nbits -= 8
+	}
+}
+
+func (bw *webpBitWriter) flush() []byte {
+	if bw.nbits > 0 {
+		bw.buf.WriteByte(byte(bw.bits))
+		bw.bits, bw.nbits = 0, 0
+	}
+	return bw.buf.Bytes()
+}
+
+// webpCode is a canonical huffman code. The codes are bit reversed, because the
+// bit stream starts with the least significant bit
+type webpCode struct {
+	lengths []int
+	codes   []uint32
+	// codes with a single symbol do not need any bits
+	single bool
+}
+
+// huffmanLengths returns the code lengths of a huffman code for the symbol counts hist,
+// which do not exceed maxLength
+func huffmanLengths(hist []int, maxLength int) []int {
+	type node struct {
+		count       int
+		symbol      int
+		left, right int
+	}
+	var counts = make([]int, len(hist))
+	copy(counts, hist)
+	for {
+		var nodes = []node{}
+		for symbol, count := range counts {
+			if count > 0 {
+				nodes = append(nodes, node{count: count, symbol: symbol, left: -1, right: -1})
+			}
+		}
+		var lengths = make([]int, len(hist))
+		if len(nodes) == 1 {
+			lengths[nodes[0].symbol] = 1
+		}
+		if len(nodes) <= 1 {
+			return lengths
+		}
+		sort.SliceStable(nodes, func(i, j int) bool { return nodes[i].count < nodes[j].count })
+		// the leaves and the merged nodes are two queues with increasing counts
+		var leaves = len(nodes)
+		var nextLeaf, nextMerged = 0, leaves
+		var pop = func() int {
+			if nextLeaf < leaves && (nextMerged >= len(nodes) || nodes[nextLeaf].count <= nodes[nextMerged].count) {
+				nextLeaf++
+				return nextLeaf - 1
+			}
+			nextMerged++
+			return nextMerged - 1
+		}
+		for i := 1; i < leaves; i++ {
+			var a, b = pop(), pop()
+			nodes = append(nodes, node{count: nodes[a].count + nodes[b].count, symbol: -1, left: a, right: b})
+		}
+		var depths = make([]int, len(nodes))
+		var longest = 0
+		for i := len(nodes) - 1; i >= 0; i-- {
+			if nodes[i].left < 0 {
+				lengths[nodes[i].symbol] = depths[i]
+				if depths[i] > longest {
+					longest = depths[i]
+				}
+				continue
+			}
+			depths[nodes[i].left] = depths[i] + 1
+			depths[nodes[i].right] = depths[i] + 1
+		}
+		if longest <= maxLength {
+			return lengths
+		}
+		// flatten the distribution until the code is short enough
+		for i, count := range counts {
+			if count > 0 {
+				counts[i] = (count + 1) / 2
+			}
+		}
+	}
+}
+
+// newWebPCode creates the canonical code of the code lengths
+func newWebPCode(lengths []int) *webpCode {
+	var wc = &webpCode{lengths: lengths, codes: make([]uint32, len(lengths))}
+	var histogram = [16]uint32{}
+	var symbols = 0
+	for _, l := range lengths {
+		if l > 0 {
+			histogram[l]++
+			symbols++
+		}
+	}
+	wc.single = symbols == 1
+	var next = [16]uint32{}
+	var code uint32
+	for l := 1; l < len(next); l++ {
+		code = (code + histogram[l-1]) << 1
+		next[l] = code
+	}
+	for symbol, l := range lengths {
+		if l == 0 {
+			continue
+		}
+		var c = next[l]
+		next[l]++
+		var reversed uint32
+		for i := 0; i < l; i++ {
+			reversed = reversed<<1 | (c>>uint(i))&1
+		}
+		wc.codes[symbol] = reversed
+	}
+	return wc
+}
+
+func (wc *webpCode) write(bw *webpBitWriter, symbol int) {
+	if wc.single {
+		return
+	}
+	bw.write(wc.codes[symbol], uint(wc.lengths[symbol]))
+}
+
+// writeWebPCode writes the huffman code of the symbol counts hist
+func writeWebPCode(bw *webpBitWriter, hist []int) *webpCode {
+	var used = []int{}
+	for symbol, count := range hist {
+		if count > 0 {
+			used = append(used, symbol)
+		}
+	}
+	if len(used) == 0 || len(used) == 1 && used[0] < 256 {
+		// simple code with one symbol
+		var symbol = 0
+		if len(used) == 1 {
+			symbol = used[0]
+		}
+		bw.write(1, 1)
+		bw.write(0, 1)
+		if symbol < 2 {
+			bw.write(0, 1)
+			bw.write(uint32(symbol), 1)
+		} else {
+			bw.write(1, 1)
+			bw.write(uint32(symbol), 8)
+		}
+		var lengths = make([]int, len(hist))
+		lengths[symbol] = 1
+		return newWebPCode(lengths)
+	}
+	var lengths = huffmanLengths(hist, 15)
+	// the code lengths are written with the code length code
+	var lengthHist = make([]int, len(webpCodeLengthOrder))
+	for _, l := range lengths {
+		lengthHist[l]++
+	}
+	var lengthCode = newWebPCode(huffmanLengths(lengthHist, 7))
+	var n = 4
+	for i, l := range webpCodeLengthOrder {
+		if lengthCode.lengths[l] > 0 && i+1 > n {
+			n = i + 1
+		}
+	}
+	bw.write(0, 1)
+	bw.write(uint32(n-4), 4)
+	for _, l := range webpCodeLengthOrder[:n] {
+		bw.write(uint32(lengthCode.lengths[l]), 3)
+	}
+	// all symbols of the alphabet
+	bw.write(0, 1)
+	for _, l := range lengths {
+		lengthCode.write(bw, l)
+	}
+	return newWebPCode(lengths)
+}
+
+// EncodeWebP writes img as lossless webp image (VP8L) without color profile
+func EncodeWebP(w io.Writer, img image.Image) error {
+	var b = img.Bounds()
+	if b.Dx() < 1 || b.Dy() < 1 || b.Dx() > webpMaxSize || b.Dy() > webpMaxSize {
+		return errors.New(fmt.Sprintf("invalid webp image size %vx%v", b.Dx(), b.Dy()))
+	}
+	nrgba, ok := img.(*image.NRGBA)
+	if !ok {
+		nrgba = image.NewNRGBA(b)
+		draw.Draw(nrgba, b, img, b.Min, draw.Src)
+	}
+	// histograms of green, red, blue and alpha
+	var hists = [5][]int{}
+	for i, size := range webpAlphabetSizes {
+		hists[i] = make([]int, size)
+	}
+	var alpha = false
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		var row = nrgba.Pix[nrgba.PixOffset(b.Min.X, y):nrgba.PixOffset(b.Max.X, y)]
+		for i := 0; i < len(row); i += 4 {
+			hists[0][row[i+1]]++
+			hists[1][row[i]]++
+			hists[2][row[i+2]]++
+			hists[3][row[i+3]]++
+			alpha = alpha || row[i+3] != 0xff
+		}
+	}
+
+	var bw = &webpBitWriter{}
+	bw.write(0x2f, 8)
+	bw.write(uint32(b.Dx()-1), 14)
+	bw.write(uint32(b.Dy()-1), 14)
+	if alpha {
+		bw.write(1, 1)
+	} else {
+		bw.write(0, 1)
+	}
+	// version
+	bw.write(0, 3)
+	// no transforms, no color cache, no meta prefix codes
+	bw.write(0, 1)
+	bw.write(0, 1)
+	bw.write(0, 1)
+	var codes = [5]*webpCode{}
+	for i, hist := range hists {
+		codes[i] = writeWebPCode(bw, hist)
+	}
+	// every pixel is a literal
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		var row = nrgba.Pix[nrgba.PixOffset(b.Min.X, y):nrgba.PixOffset(b.Max.X, y)]
+		for i := 0; i < len(row); i += 4 {
+			codes[0].write(bw, int(row[i+1]))
+			codes[1].write(bw, int(row[i]))
+			codes[2].write(bw, int(row[i+2]))
+			codes[3].write(bw, int(row[i+3]))
+		}
+	}
+	var data = bw.flush()
+
+	var chunk = len(data) + len(data)%2
+	var header = make([]byte, 20)
+	copy(header[0:], "RIFF")
+	binary.LittleEndian.PutUint32(header[4:], uint32(4+8+chunk))
+	copy(header[8:], "WEBPVP8L")
+	binary.LittleEndian.PutUint32(header[16:], uint32(len(data)))
+	if _, err := w.Write(header); err != nil {
+		return errors.Wrap(err, "cannot write webp header")
+	}
+	if len(data)%2 == 1 {
+		data = append(data, 0)
+	}
+	if _, err := w.Write(data); err != nil {
+		return errors.Wrap(err, "cannot write webp data")
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package PictureFS
+
+import (
+	"bytes"
+	"golang.org/x/image/webp"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestEncodeWebP(t *testing.T) {
+	gradient := image.NewNRGBA(image.Rect(0, 0, 37, 23))
+	for y := 0; y < 23; y++ {
+		for x := 0; x < 37; x++ {
+			gradient.SetNRGBA(x, y, color.NRGBA{R: uint8(x * 7), G: uint8(y * 11), B: uint8(x * y), A: uint8(255 - x)})
+		}
+	}
+	uniform := image.NewNRGBA(image.Rect(0, 0, 5, 3))
+	for i := range uniform.Pix {
+		uniform.Pix[i] = 0xff
+	}
+	twoColors := image.NewNRGBA(image.Rect(0, 0, 9, 9))
+	for y := 0; y < 9; y++ {
+		for x := 0; x < 9; x++ {
+			twoColors.SetNRGBA(x, y, color.NRGBA{R: uint8(200 * ((x + y) % 2)), G: 40, B: 3, A: 255})
+		}
+	}
+	// noise needs long codes
+	noise := image.NewNRGBA(image.Rect(0, 0, 300, 200))
+	for i := range noise.Pix {
+		noise.Pix[i] = uint8((i * i * 2654435761) >> 13)
+	}
+	gray := image.NewGray(image.Rect(2, 3, 12, 8))
+	for i := range gray.Pix {
+		gray.Pix[i] = uint8(i * 5)
+	}
+	for name, img := range map[string]image.Image{
+		"gradient":  gradient,
+		"uniform":   uniform,
+		"twoColors": twoColors,
+		"noise":     noise,
+		"gray":      gray,
+		"single":    image.NewNRGBA(image.Rect(0, 0, 1, 1)),
+	} {
+		buf := &bytes.Buffer{}
+		if err := EncodeWebP(buf, img); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		decoded, err := webp.Decode(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatalf("%s: cannot decode: %v", name, err)
+		}
+		b := img.Bounds()
+		if decoded.Bounds().Size() != b.Size() {
+			t.Fatalf("%s: size %v, expected %v", name, decoded.Bounds().Size(), b.Size())
+		}
+		for y := 0; y < b.Dy(); y++ {
+			for x := 0; x < b.Dx(); x++ {
+				c := color.NRGBAModel.Convert(decoded.At(x, y))
+				expected := color.NRGBAModel.Convert(img.At(b.Min.X+x, b.Min.Y+y))
+				if c != expected {
+					t.Fatalf("%s: pixel %v/%v is %v, expected %v", name, x, y, c, expected)
+				}
+			}
+		}
+	}
+	if err := EncodeWebP(&bytes.Buffer{}, image.NewNRGBA(image.Rect(0, 0, 1<<14+1, 1))); err == nil {
+		t.Error("expected error for too wide image")
+	}
+}
+
+func TestHuffmanLengths(t *testing.T) {
+	// fibonacci counts create a deep tree
+	hist := make([]int, 30)
+	a, b := 1, 1
+	for i := range hist {
+		hist[i] = a
+		a, b = b, a+b
+	}
+	lengths := huffmanLengths(hist, 15)
+	kraft := 0.0
+	for _, l := range lengths {
+		if l < 1 || l > 15 {
+			t.Fatalf("invalid code length %v", l)
+		}
+		kraft += 1 / float64(int(1)<<uint(l))
+	}
+	if kraft != 1 {
+		t.Errorf("incomplete code: kraft sum %v", kraft)
+	}
+}
@@ -0,0 +1,242 @@
+package imagecollage
+
+import (
+	"fmt"
+	"github.com/je4/PictureFS/v2/pkg/PictureFS"
+	"github.com/pkg/errors"
+	"golang.org/x/image/draw"
+	"image"
+	"image/gif"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+type AnimationMode int
+
+const (
+	// AnimationNone packs only the first frame of animated images
+	AnimationNone AnimationMode = iota
+	// AnimationFrames packs every frame as individual rect <path>/frame-0001.png
+	AnimationFrames
+	// AnimationFilmstrip packs all frames side by side into one rect <path>/filmstrip.png
+	AnimationFilmstrip
+)
+
+// frameRef references the frame index of the animation source. index -1 is the filmstrip of all frames
+type frameRef struct {
+	source string
+	index  int
+}
+
+// SetAnimation defines how the frames of animated gif and png images are packed
+func (sc *SemibranCollage) SetAnimation(mode AnimationMode) {
+	sc.animation = mode
+}
+
+// coalesceGIF renders the frames of an animated gif with respect to the disposal methods
+func coalesceGIF(g *gif.GIF) *PictureFS.APNG {
+	var result = &PictureFS.APNG{
+		Frames: []*image.NRGBA{},
+		Delays: []int{},
+	}
+	// gif counts the repetitions after the first run, -1 plays once
+	switch {
+	case g.LoopCount == 0:
+		result.Loops = 0
+	case g.LoopCount < 0:
+		result.Loops = 1
+	default:
+		result.Loops = g.LoopCount + 1
+	}
+	var canvas = image.NewNRGBA(image.Rect(0, 0, g.Config.Width, g.Config.Height))
+	for i, frame := range g.Image {
+		var disposal byte
+		if i < len(g.Disposal) {
+			disposal = g.Disposal[i]
+		}
+		var previous *image.NRGBA
+		if disposal == gif.DisposalPrevious {
+			previous = image.NewNRGBA(canvas.Bounds())
+			copy(previous.Pix, canvas.Pix)
+		}
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+		var snapshot = image.NewNRGBA(canvas.Bounds())
+		copy(snapshot.Pix, canvas.Pix)
+		result.Frames = append(result.Frames, snapshot)
+		var delay int
+		if i < len(g.Delay) {
+			delay = g.Delay[i] * 10
+		}
+		result.Delays = append(result.Delays, delay)
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = previous
+		}
+	}
+	return result
+}
+
+// loadAnimation decodes all frames of a gif or png file. Other formats return nil
+func loadAnimation(fsys fs.FS, filePath string) (*PictureFS.APNG, error) {
+	var ext = strings.ToLower(filepath.Ext(filePath))
+	if ext != ".gif" && ext != ".png" {
+		return nil, nil
+	}
+	f, err := fsys.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if ext == ".gif" {
+		g, err := gif.DecodeAll(f)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot decode gif %s", filePath)
+		}
+		return coalesceGIF(g), nil
+	}
+	anim, err := PictureFS.DecodeAPNG(f)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot decode png %s", filePath)
+	}
+	return anim, nil
+}
+
+// addAnimation packs the frames of the animated image path
+func (sc *SemibranCollage) addAnimation(path string, anim *PictureFS.APNG) error {
+	if _, ok := sc.animations[path]; ok {
+		return errors.New(fmt.Sprintf("animation %s already added", path))
+	}
+	var bounds = anim.Frames[0].Bounds()
+	var result = PictureFS.Animation{
+		Path:   path,
+		Width:  bounds.Dx(),
+		Height: bounds.Dy(),
+		Loops:  anim.Loops,
+		Frames: []PictureFS.Frame{},
+	}
+	if sc.animation == AnimationFilmstrip {
+		var name = path + "/filmstrip.png"
+		if err := sc.AddRect(name, int64(bounds.Dx()*len(anim.Frames)), int64(bounds.Dy())); err != nil {
+			return err
+		}
+		sc.frames[name] = frameRef{source: path, index: -1}
+		for i := range anim.Frames {
+			result.Frames = append(result.Frames, PictureFS.Frame{Path: name, X: i * bounds.Dx(), Delay: anim.Delays[i]})
+		}
+		sc.animations[path] = result
+		return nil
+	}
+	for i, frame := range anim.Frames {
+		var name = fmt.Sprintf("%s/frame-%04d.png", path, i+1)
+		result.Frames = append(result.Frames, PictureFS.Frame{Path: name, Delay: anim.Delays[i]})
+		if sc.dedup != DedupNone {
+			if original, ok := sc.findDuplicate(name, frame, frame.Bounds(), Trim{}); ok {
+				if err := sc.AddAlias(name, original); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		if err := sc.AddRect(name, int64(bounds.Dx()), int64(bounds.Dy())); err != nil {
+			return err
+		}
+		sc.frames[name] = frameRef{source: path, index: i}
+	}
+	sc.animations[path] = result
+	return nil
+}
+
+// loadImage loads the source image of a packed rect (file, tiff page or animation frame)
+func (sc *SemibranCollage) loadImage(name string) (image.Image, error) {
+	if page, ok := sc.pages[name]; ok {
+		// the pages of a tiff are rendered in any order, the file is read only once
+		tf, ok := sc.tiffs[page.source]
+		if !ok {
+			var err error
+			if tf, err = ReadTIFF(sc.fsys, fsName(page.source)); err != nil {
+				return nil, err
+			}
+			sc.tiffs[page.source] = tf
+		}
+		img, err := tf.Page(page.index)
+		if err != nil {
+			return nil, err
+		}
+		return sc.convertColors(img, fsName(page.source))
+	}
+	ref, ok := sc.frames[name]
+	if !ok {
+		img, _, err := sc.decodeFile(fsName(name))
+		if err != nil {
+			return nil, err
+		}
+		return sc.convertColors(img, fsName(name))
+	}
+	// the frames of an animation are usually rendered one after the other
+	if sc.frameCache == nil || sc.frameCache.source != ref.source {
+		anim, err := loadAnimation(sc.fsys, fsName(ref.source))
+		if err != nil {
+			return nil, err
+		}
+		if anim == nil {
+			return nil, errors.New(fmt.Sprintf("%s is not an animation", ref.source))
+		}
+		var cache = &frameCache{source: ref.source, frames: []image.Image{}}
+		for _, frame := range anim.Frames {
+			converted, err := sc.convertColors(frame, fsName(ref.source))
+			if err != nil {
+				return nil, err
+			}
+			cache.frames = append(cache.frames, converted)
+		}
+		sc.frameCache = cache
+	}
+	var frames = sc.frameCache.frames
+	if ref.index >= 0 {
+		if ref.index >= len(frames) {
+			return nil, errors.New(fmt.Sprintf("frame %v of %s not found", ref.index+1, ref.source))
+		}
+		return frames[ref.index], nil
+	}
+	var size = frames[0].Bounds().Size()
+	var strip = image.NewNRGBA(image.Rect(0, 0, size.X*len(frames), size.Y))
+	for i, frame := range frames {
+		draw.Copy(strip, image.Point{X: i * size.X}, frame, frame.Bounds(), draw.Src, nil)
+	}
+	return strip, nil
+}
+
+// frameCache contains the frames of an animation converted to the working space
+type frameCache struct {
+	source string
+	frames []image.Image
+}
+
+// pngAlias returns the path of the png alias of a gif image. Only the extension is replaced
+func pngAlias(path string) string {
+	return strings.TrimSuffix(path, filepath.Ext(path)) + ".png"
+}
+
+// createAnimations returns the animations of the layout sorted by path. Animated gif images are
+// also available as animated png
+func (sc *SemibranCollage) createAnimations() []PictureFS.Animation {
+	var paths = []string{}
+	for path := range sc.animations {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	var result = []PictureFS.Animation{}
+	for _, path := range paths {
+		var anim = sc.animations[path]
+		result = append(result, anim)
+		if strings.ToLower(filepath.Ext(path)) == ".gif" {
+			anim.Path = pngAlias(path)
+			result = append(result, anim)
+		}
+	}
+	return result
+}
@@ -2,7 +2,10 @@ package imagecollage
 
 import (
 	"github.com/je4/PictureFS/v2/pkg/PictureFS"
+	"github.com/pkg/errors"
 	"image"
+	"image/png"
+	"io"
 )
 
 type Rect struct {
@@ -15,7 +18,19 @@ type Collage interface {
 	AddImageFile(path string) error
 	AddRect(name string, width, height int64) error
 	Pack() (Layout, error)
-	CreateImage(layout Layout, dirName string) (image.Image, error)
+	CreateImage(layout Layout) (image.Image, error)
+	ImageSize(layout Layout) image.Rectangle
 	CreateLayout(layout Layout) (*PictureFS.Layout, error)
 	CreateJSON(layout Layout) ([]byte, error)
 }
+
+// EncodePNG writes img with fixed encoder settings, so that identical images produce identical bytes
+func EncodePNG(w io.Writer, img image.Image) error {
+	enc := &png.Encoder{
+		CompressionLevel: png.DefaultCompression,
+	}
+	if err := enc.Encode(w, img); err != nil {
+		return errors.Wrap(err, "cannot encode png")
+	}
+	return nil
+}
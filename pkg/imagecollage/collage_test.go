@@ -0,0 +1,2138 @@
+package imagecollage
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"github.com/je4/PictureFS/v2/pkg/PictureFS"
+	"golang.org/x/image/bmp"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io/fs"
+	"math"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// creates count png files with random (but seeded) sizes, many of them with the same area
+func createTestImages(t *testing.T, dir string, count int) []string {
+	rnd := rand.New(rand.NewSource(42))
+	names := []string{}
+	for i := 0; i < count; i++ {
+		width := 4 * (rnd.Intn(6) + 1)
+		height := 4 * (rnd.Intn(6) + 1)
+		img := image.NewNRGBA(image.Rect(0, 0, width, height))
+		for x := 0; x < width; x++ {
+			img.Set(x, x%height, color.NRGBA{R: uint8(i * 10), G: 255, A: 255})
+		}
+		name := fmt.Sprintf("img_%03d.png", i)
+		f, err := os.Create(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("cannot create %s: %v", name, err)
+		}
+		if err := png.Encode(f, img); err != nil {
+			f.Close()
+			t.Fatalf("cannot encode %s: %v", name, err)
+		}
+		f.Close()
+		names = append(names, name)
+	}
+	return names
+}
+
+func buildCollage(t *testing.T, dir string, names []string) ([32]byte, [32]byte) {
+	collage := NewSemibranCollage(os.DirFS(dir), 1, 1, 2, 2, 2, 2)
+	for _, name := range names {
+		if err := collage.AddImageFile(name); err != nil {
+			t.Fatalf("cannot add %s: %v", name, err)
+		}
+	}
+	layout, err := collage.Pack()
+	if err != nil {
+		t.Fatalf("cannot pack: %v", err)
+	}
+	img, err := collage.CreateImage(layout)
+	if err != nil {
+		t.Fatalf("cannot create image: %v", err)
+	}
+	buf := bytes.NewBuffer(nil)
+	if err := EncodePNG(buf, img); err != nil {
+		t.Fatalf("cannot encode image: %v", err)
+	}
+	jsonBytes, err := collage.CreateJSON(layout)
+	if err != nil {
+		t.Fatalf("cannot create json: %v", err)
+	}
+	return sha256.Sum256(buf.Bytes()), sha256.Sum256(jsonBytes)
+}
+
+func TestReproducible(t *testing.T) {
+	dir := t.TempDir()
+	names := createTestImages(t, dir, 30)
+
+	imgHash, jsonHash := buildCollage(t, dir, names)
+
+	shuffled := make([]string, len(names))
+	copy(shuffled, names)
+	rand.New(rand.NewSource(7)).Shuffle(len(shuffled), func(i, j int) {
+		shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+	})
+	for _, n := range [][]string{names, shuffled} {
+		imgHash2, jsonHash2 := buildCollage(t, dir, n)
+		if imgHash != imgHash2 {
+			t.Errorf("image hash differs: %x != %x", imgHash, imgHash2)
+		}
+		if jsonHash != jsonHash2 {
+			t.Errorf("json hash differs: %x != %x", jsonHash, jsonHash2)
+		}
+	}
+}
+
+func TestCanvasConstraints(t *testing.T) {
+	dir := t.TempDir()
+	names := createTestImages(t, dir, 10)
+
+	collage := NewSemibranCollage(os.DirFS(dir), 1, 1, 3, 3, 3, 3)
+	collage.SetAlignment(4)
+	collage.SetCanvas(CanvasOptions{PowerOfTwo: true, Square: true})
+	for _, name := range names {
+		if err := collage.AddImageFile(name); err != nil {
+			t.Fatalf("cannot add %s: %v", name, err)
+		}
+	}
+	layout, err := collage.Pack()
+	if err != nil {
+		t.Fatalf("cannot pack: %v", err)
+	}
+	img, err := collage.CreateImage(layout)
+	if err != nil {
+		t.Fatalf("cannot create image: %v", err)
+	}
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	if w != h || w&(w-1) != 0 {
+		t.Errorf("canvas %vx%v is not a square power of two", w, h)
+	}
+	pLayout, err := collage.CreateLayout(layout)
+	if err != nil {
+		t.Fatalf("cannot create layout: %v", err)
+	}
+	for _, rect := range pLayout.Images {
+		if rect.X%4 != 0 || rect.Y%4 != 0 {
+			t.Errorf("%s at %v/%v not aligned", rect.Path, rect.X, rect.Y)
+		}
+	}
+
+	collage.SetCanvas(CanvasOptions{FixedWidth: 30, FixedHeight: 30})
+	if _, err := collage.Pack(); err == nil {
+		t.Errorf("expected error for fixed canvas 30x30")
+	}
+
+	// a single fixed dimension: the other one grows
+	collage.SetCanvas(CanvasOptions{FixedWidth: 40, Multiple: 16})
+	if layout, err = collage.Pack(); err != nil {
+		t.Fatalf("cannot pack with fixed width: %v", err)
+	}
+	if size := collage.ImageSize(layout); size.Dx() != 40 || size.Dy()%16 != 0 || size.Dy() <= 40 {
+		t.Errorf("canvas with fixed width 40 has size %v", size.Size())
+	}
+	for _, rect := range layout.Rects {
+		if rect.X+rect.Width > 40-6 {
+			t.Errorf("%s exceeds fixed width", rect.Name)
+		}
+	}
+	collage.SetCanvas(CanvasOptions{FixedHeight: 40, Square: true})
+	if _, err := collage.Pack(); err == nil {
+		t.Errorf("expected error for square canvas with fixed height 40")
+	}
+	collage.SetCanvas(CanvasOptions{FixedWidth: 10})
+	if _, err := collage.Pack(); err == nil {
+		t.Errorf("expected error for fixed width 10")
+	}
+}
+
+func TestExtrude(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+	content := image.Rect(3, 3, 5, 5)
+	colors := map[image.Point]color.NRGBA{
+		{X: 3, Y: 3}: {R: 255, A: 255},
+		{X: 4, Y: 3}: {G: 255, A: 255},
+		{X: 3, Y: 4}: {B: 255, A: 255},
+		{X: 4, Y: 4}: {R: 255, G: 255, A: 255},
+	}
+	for p, c := range colors {
+		img.SetNRGBA(p.X, p.Y, c)
+	}
+	Extrude(img, content, 2)
+	expected := map[image.Point]image.Point{
+		{X: 1, Y: 1}: {X: 3, Y: 3},
+		{X: 4, Y: 1}: {X: 4, Y: 3},
+		{X: 6, Y: 2}: {X: 4, Y: 3},
+		{X: 1, Y: 4}: {X: 3, Y: 4},
+		{X: 6, Y: 6}: {X: 4, Y: 4},
+	}
+	for p, src := range expected {
+		if img.NRGBAAt(p.X, p.Y) != colors[src] {
+			t.Errorf("pixel %v: expected %v, got %v", p, colors[src], img.NRGBAAt(p.X, p.Y))
+		}
+	}
+	if img.NRGBAAt(0, 0).A != 0 {
+		t.Errorf("pixel outside of extrusion changed")
+	}
+}
+
+func TestTrim(t *testing.T) {
+	dir := t.TempDir()
+	img := image.NewNRGBA(image.Rect(0, 0, 20, 16))
+	red := color.NRGBA{R: 255, A: 255}
+	for x := 5; x < 12; x++ {
+		img.SetNRGBA(x, 3, red)
+		img.SetNRGBA(x, 9, red)
+	}
+	img.SetNRGBA(0, 0, color.NRGBA{A: 10})
+	f, err := os.Create(filepath.Join(dir, "icon.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := png.Encode(f, img); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	collage := NewSemibranCollage(os.DirFS(dir), 0, 1, 0, 0, 0, 0)
+	collage.SetTrim(true, 16)
+	if err := collage.AddImageFile("icon.png"); err != nil {
+		t.Fatalf("cannot add image: %v", err)
+	}
+	layout, err := collage.Pack()
+	if err != nil {
+		t.Fatalf("cannot pack: %v", err)
+	}
+	atlas, err := collage.CreateImage(layout)
+	if err != nil {
+		t.Fatalf("cannot create image: %v", err)
+	}
+	pLayout, err := collage.CreateLayout(layout)
+	if err != nil {
+		t.Fatalf("cannot create layout: %v", err)
+	}
+	rect := pLayout.Images[0]
+	if rect.Width != 7 || rect.Height != 7 || rect.TrimX != 5 || rect.TrimY != 3 || rect.SourceWidth != 20 || rect.SourceHeight != 16 {
+		t.Fatalf("invalid trimmed rect: %+v", rect)
+	}
+
+	pfs, err := PictureFS.NewFS(atlas, *pLayout)
+	if err != nil {
+		t.Fatalf("cannot create PictureFS: %v", err)
+	}
+	data, err := PictureFS.ReadFile(pfs, "icon.png")
+	if err != nil {
+		t.Fatalf("cannot read icon.png: %v", err)
+	}
+	result, err := png.Decode(bytes.NewBuffer(data))
+	if err != nil {
+		t.Fatalf("cannot decode icon.png: %v", err)
+	}
+	if result.Bounds().Dx() != 20 || result.Bounds().Dy() != 16 {
+		t.Fatalf("invalid size of reconstructed image: %v", result.Bounds())
+	}
+	if c := color.NRGBAModel.Convert(result.At(11, 9)); c != red {
+		t.Errorf("pixel 11/9: expected %v, got %v", red, c)
+	}
+}
+
+func TestDedup(t *testing.T) {
+	dir := t.TempDir()
+	names := createTestImages(t, dir, 3)
+	// copy first image twice
+	data, err := os.ReadFile(filepath.Join(dir, names[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"copy1.png", "copy2.png"} {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0666); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+	}
+
+	collage := NewSemibranCollage(os.DirFS(dir), 0, 1, 0, 0, 0, 0)
+	collage.SetDedup(DedupExact, 0)
+	for _, name := range names {
+		if err := collage.AddImageFile(name); err != nil {
+			t.Fatalf("cannot add %s: %v", name, err)
+		}
+	}
+	layout, err := collage.Pack()
+	if err != nil {
+		t.Fatalf("cannot pack: %v", err)
+	}
+	if len(layout.Rects) != 3 {
+		t.Fatalf("expected 3 packed rects, got %v", len(layout.Rects))
+	}
+	pLayout, err := collage.CreateLayout(layout)
+	if err != nil {
+		t.Fatalf("cannot create layout: %v", err)
+	}
+	rects := map[string]PictureFS.Rect{}
+	for _, rect := range pLayout.Images {
+		rects[rect.Path] = rect
+	}
+	if len(rects) != 5 {
+		t.Fatalf("expected 5 images in layout, got %v", len(rects))
+	}
+	for _, name := range []string{"copy1.png", "copy2.png"} {
+		r := rects[name]
+		r.Path = names[0]
+		if r != rects[names[0]] {
+			t.Errorf("%s is not an alias of %s", name, names[0])
+		}
+	}
+}
+
+func TestEncodeImageBands(t *testing.T) {
+	dir := t.TempDir()
+	names := createTestImages(t, dir, 20)
+
+	collage := NewSemibranCollage(os.DirFS(dir), 1, 1, 2, 2, 2, 2)
+	collage.SetExtrude(1)
+	for _, name := range names {
+		if err := collage.AddImageFile(name); err != nil {
+			t.Fatalf("cannot add %s: %v", name, err)
+		}
+	}
+	layout, err := collage.Pack()
+	if err != nil {
+		t.Fatalf("cannot pack: %v", err)
+	}
+	img, err := collage.CreateImage(layout)
+	if err != nil {
+		t.Fatalf("cannot create image: %v", err)
+	}
+	buf := bytes.NewBuffer(nil)
+	if err := collage.EncodeImageBands(buf, layout, 7); err != nil {
+		t.Fatalf("cannot encode image bands: %v", err)
+	}
+	streamed, err := png.Decode(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("cannot decode streamed image: %v", err)
+	}
+	if streamed.Bounds() != img.Bounds() {
+		t.Fatalf("bounds differ: %v != %v", streamed.Bounds(), img.Bounds())
+	}
+	for y := img.Bounds().Min.Y; y < img.Bounds().Max.Y; y++ {
+		for x := img.Bounds().Min.X; x < img.Bounds().Max.X; x++ {
+			if color.NRGBAModel.Convert(streamed.At(x, y)) != img.At(x, y) {
+				t.Fatalf("pixel %v/%v differs", x, y)
+			}
+		}
+	}
+
+	pLayout, err := collage.CreateLayout(layout)
+	if err != nil {
+		t.Fatalf("cannot create layout: %v", err)
+	}
+	pfs, err := PictureFS.NewFS(img, *pLayout)
+	if err != nil {
+		t.Fatalf("cannot create PictureFS: %v", err)
+	}
+	pfsStream, err := PictureFS.NewFSReader(bytes.NewReader(buf.Bytes()), *pLayout)
+	if err != nil {
+		t.Fatalf("cannot create streamed PictureFS: %v", err)
+	}
+	for _, name := range names {
+		data, err := PictureFS.ReadFile(pfs, name)
+		if err != nil {
+			t.Fatalf("cannot read %s: %v", name, err)
+		}
+		data2, err := PictureFS.ReadFile(pfsStream, name)
+		if err != nil {
+			t.Fatalf("cannot read streamed %s: %v", name, err)
+		}
+		if !bytes.Equal(data, data2) {
+			t.Errorf("%s differs", name)
+		}
+	}
+}
+
+func TestUV(t *testing.T) {
+	dir := t.TempDir()
+	names := createTestImages(t, dir, 5)
+	for _, origin := range []string{PictureFS.UVTopLeft, PictureFS.UVBottomLeft} {
+		collage := NewSemibranCollage(os.DirFS(dir), 1, 1, 2, 3, 4, 5)
+		if err := collage.SetUV(origin, true); err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range names {
+			if err := collage.AddImageFile(name); err != nil {
+				t.Fatalf("cannot add %s: %v", name, err)
+			}
+		}
+		layout, err := collage.Pack()
+		if err != nil {
+			t.Fatalf("cannot pack: %v", err)
+		}
+		pLayout, err := collage.CreateLayout(layout)
+		if err != nil {
+			t.Fatalf("cannot create layout: %v", err)
+		}
+		size := collage.ImageSize(layout)
+		if pLayout.UV == nil || pLayout.UV.Width != size.Dx() || pLayout.UV.Height != size.Dy() || len(pLayout.UV.Rects) != len(pLayout.Images) {
+			t.Fatalf("invalid uv section: %+v", pLayout.UV)
+		}
+		w, h := float64(size.Dx()), float64(size.Dy())
+		for i, rect := range pLayout.Images {
+			uv := pLayout.UV.Rects[i]
+			x := uv.U0*w - 0.5
+			y := uv.V0*h - 0.5
+			if origin == PictureFS.UVBottomLeft {
+				y = h - uv.V1*h - 0.5
+			}
+			if uv.Path != rect.Path || math.Abs(x-float64(rect.X)) > 1e-9 || math.Abs(y-float64(rect.Y)) > 1e-9 ||
+				math.Abs((uv.U1-uv.U0)*w+1-float64(rect.Width)) > 1e-9 || math.Abs((uv.V1-uv.V0)*h+1-float64(rect.Height)) > 1e-9 {
+				t.Errorf("%s: uv %+v does not match rect %+v", origin, uv, rect)
+			}
+		}
+	}
+}
+
+func TestAnimation(t *testing.T) {
+	dir := t.TempDir()
+	colors := []color.NRGBA{{R: 255, A: 255}, {G: 255, A: 255}, {B: 255, A: 255}}
+	anim := &PictureFS.APNG{Loops: 2}
+	g := &gif.GIF{LoopCount: 0}
+	for i, c := range colors {
+		frame := image.NewNRGBA(image.Rect(0, 0, 12, 8))
+		draw.Draw(frame, image.Rect(i*4, 0, i*4+4, 8), image.NewUniform(c), image.Point{}, draw.Src)
+		anim.Frames = append(anim.Frames, frame)
+		anim.Delays = append(anim.Delays, 100*(i+1))
+		p := image.NewPaletted(frame.Bounds(), color.Palette{color.Transparent, colors[0], colors[1], colors[2]})
+		draw.Draw(p, p.Bounds(), frame, image.Point{}, draw.Src)
+		g.Image = append(g.Image, p)
+		g.Delay = append(g.Delay, 10*(i+1))
+		g.Disposal = append(g.Disposal, gif.DisposalBackground)
+	}
+	f, err := os.Create(filepath.Join(dir, "anim.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := PictureFS.EncodeAPNG(f, anim); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	f, err = os.Create(filepath.Join(dir, "spinner.gif"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := gif.EncodeAll(f, g); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	for _, mode := range []AnimationMode{AnimationFrames, AnimationFilmstrip} {
+		collage := NewSemibranCollage(os.DirFS(dir), 0, 1, 0, 0, 0, 0)
+		collage.SetAnimation(mode)
+		for _, name := range []string{"anim.png", "spinner.gif"} {
+			if err := collage.AddImageFile(name); err != nil {
+				t.Fatalf("cannot add %s: %v", name, err)
+			}
+		}
+		layout, err := collage.Pack()
+		if err != nil {
+			t.Fatalf("cannot pack: %v", err)
+		}
+		atlas, err := collage.CreateImage(layout)
+		if err != nil {
+			t.Fatalf("cannot create image: %v", err)
+		}
+		pLayout, err := collage.CreateLayout(layout)
+		if err != nil {
+			t.Fatalf("cannot create layout: %v", err)
+		}
+		if len(pLayout.Animations) != 3 {
+			t.Fatalf("expected 3 animations, got %+v", pLayout.Animations)
+		}
+		pfs, err := PictureFS.NewFS(atlas, *pLayout)
+		if err != nil {
+			t.Fatalf("cannot create PictureFS: %v", err)
+		}
+		for _, name := range []string{"anim.png", "spinner.png"} {
+			data, err := PictureFS.ReadFile(pfs, name)
+			if err != nil {
+				t.Fatalf("cannot read %s: %v", name, err)
+			}
+			result, err := PictureFS.DecodeAPNG(bytes.NewBuffer(data))
+			if err != nil {
+				t.Fatalf("cannot decode %s: %v", name, err)
+			}
+			if len(result.Frames) != len(colors) {
+				t.Fatalf("%s: expected %v frames, got %v", name, len(colors), len(result.Frames))
+			}
+			for i, frame := range result.Frames {
+				if result.Delays[i] != 100*(i+1) {
+					t.Errorf("%s frame %v: expected delay %v, got %v", name, i, 100*(i+1), result.Delays[i])
+				}
+				if c := frame.NRGBAAt(i*4+1, 1); c != colors[i] {
+					t.Errorf("%s frame %v: expected %v, got %v", name, i, colors[i], c)
+				}
+				if c := frame.NRGBAAt((i*4+5)%12, 1); c.A != 0 {
+					t.Errorf("%s frame %v: expected transparent pixel, got %v", name, i, c)
+				}
+			}
+		}
+		data, err := PictureFS.ReadFile(pfs, "spinner.gif")
+		if err != nil {
+			t.Fatalf("cannot read spinner.gif: %v", err)
+		}
+		result, err := gif.DecodeAll(bytes.NewBuffer(data))
+		if err != nil {
+			t.Fatalf("cannot decode spinner.gif: %v", err)
+		}
+		if len(result.Image) != len(colors) || result.LoopCount != 0 || result.Delay[2] != 30 {
+			t.Errorf("invalid gif animation: %v frames, loop count %v, delays %v", len(result.Image), result.LoopCount, result.Delay)
+		}
+	}
+}
+
+func TestColorMode(t *testing.T) {
+	dir := t.TempDir()
+	gray := image.NewGray(image.Rect(0, 0, 16, 16))
+	for i := range gray.Pix {
+		gray.Pix[i] = uint8(i / 4)
+	}
+	// 1024 colors
+	rgb := image.NewNRGBA(image.Rect(0, 0, 32, 32))
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 32; x++ {
+			rgb.SetNRGBA(x, y, color.NRGBA{R: uint8(x * 8), G: uint8(y * 8), B: uint8((x ^ y) * 8), A: 0xff})
+		}
+	}
+	for name, img := range map[string]image.Image{"gray.png": gray, "rgb.png": rgb} {
+		f, err := os.Create(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := png.Encode(f, img); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+	}
+
+	tests := []struct {
+		name      string
+		margin    int64
+		mode      ColorMode
+		maxColors int
+		expected  string
+	}{
+		{"gray.png", 0, ColorModeAuto, 0, "*image.Gray"},
+		{"gray.png", 1, ColorModeAuto, 0, "*image.Paletted"},
+		{"rgb.png", 0, ColorModeAuto, 0, "*image.NRGBA"},
+		{"rgb.png", 0, ColorModeGray, 0, "*image.Gray"},
+		{"rgb.png", 1, ColorModePaletted, 16, "*image.Paletted"},
+	}
+	for _, test := range tests {
+		collage := NewSemibranCollage(os.DirFS(dir), 0, test.margin, 0, 0, 0, 0)
+		collage.SetColorMode(test.mode, test.maxColors)
+		if err := collage.AddImageFile(test.name); err != nil {
+			t.Fatal(err)
+		}
+		layout, err := collage.Pack()
+		if err != nil {
+			t.Fatal(err)
+		}
+		atlas, err := collage.CreateImage(layout)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprintf("%T", atlas) != test.expected {
+			t.Errorf("%s mode %v: expected %s, got %T", test.name, test.mode, test.expected, atlas)
+			continue
+		}
+		if p, ok := atlas.(*image.Paletted); ok && test.maxColors > 0 && len(p.Palette) > test.maxColors {
+			t.Errorf("%s: %v colors instead of %v", test.name, len(p.Palette), test.maxColors)
+		}
+		pLayout, err := collage.CreateLayout(layout)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pfs, err := PictureFS.NewFS(atlas, *pLayout)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := PictureFS.ReadFile(pfs, test.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, err := png.Decode(bytes.NewBuffer(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if test.expected != "*image.NRGBA" && fmt.Sprintf("%T", result) != test.expected {
+			t.Errorf("%s: file of %s atlas decoded as %T", test.name, test.expected, result)
+		}
+		r := pLayout.Images[0]
+		for y := 0; y < r.Height; y++ {
+			for x := 0; x < r.Width; x++ {
+				a := color.NRGBAModel.Convert(result.At(x, y))
+				b := color.NRGBAModel.Convert(atlas.At(r.X+x, r.Y+y))
+				if a != b {
+					t.Fatalf("%s pixel %v/%v: %v != %v", test.name, x, y, a, b)
+				}
+			}
+		}
+	}
+}
+
+func TestColorSpace(t *testing.T) {
+	srgb := SRGBProfile()
+	expected := [3][3]float64{{0.4361, 0.3851, 0.1431}, {0.2225, 0.7169, 0.0606}, {0.0139, 0.0971, 0.7141}}
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			if math.Abs(srgb.matrix[i][j]-expected[i][j]) > 0.0005 {
+				t.Fatalf("invalid sRGB matrix %v", srgb.matrix)
+			}
+		}
+	}
+
+	dir := t.TempDir()
+	img := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	draw.Draw(img, image.Rect(0, 0, 2, 4), image.NewUniform(color.NRGBA{R: 200, G: 100, B: 50, A: 255}), image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(2, 0, 4, 4), image.NewUniform(color.NRGBA{R: 128, G: 128, B: 128, A: 255}), image.Point{}, draw.Src)
+	f, err := os.Create(filepath.Join(dir, "p3.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := PictureFS.NewICCWriter(f, "png", DisplayP3Profile().Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := png.Encode(w, img); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	collage := NewSemibranCollage(os.DirFS(dir), 0, 0, 0, 0, 0, 0)
+	collage.SetColorSpace(srgb)
+	if err := collage.AddImageFile("p3.png"); err != nil {
+		t.Fatal(err)
+	}
+	layout, err := collage.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	atlas, err := collage.CreateImage(layout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		x        int
+		expected color.NRGBA
+	}{{0, color.NRGBA{R: 215, G: 92, B: 31, A: 255}}, {3, color.NRGBA{R: 128, G: 128, B: 128, A: 255}}} {
+		c := color.NRGBAModel.Convert(atlas.At(test.x, 0)).(color.NRGBA)
+		for _, d := range []int{int(c.R) - int(test.expected.R), int(c.G) - int(test.expected.G), int(c.B) - int(test.expected.B)} {
+			if d < -2 || d > 2 {
+				t.Errorf("pixel %v: expected %v, got %v", test.x, test.expected, c)
+			}
+		}
+	}
+
+	pLayout, err := collage.CreateLayout(layout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(pLayout.ICCProfile, srgb.Data) {
+		t.Fatal("layout without sRGB profile")
+	}
+	pLayout.Images = append(pLayout.Images, PictureFS.Rect{Path: "p3.jpg", X: 0, Y: 0, Width: 4, Height: 4})
+	pfs, err := PictureFS.NewFS(atlas, *pLayout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"p3.png", "p3.jpg"} {
+		data, err := PictureFS.ReadFile(pfs, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		profile, err := PictureFS.ExtractICCProfile(bytes.NewBuffer(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(profile, srgb.Data) {
+			t.Errorf("%s: profile not attached", name)
+		}
+		if _, _, err := image.Decode(bytes.NewBuffer(data)); err != nil {
+			t.Errorf("%s: cannot decode: %v", name, err)
+		}
+	}
+}
+
+func TestDepth16(t *testing.T) {
+	dir := t.TempDir()
+	gray := image.NewGray16(image.Rect(0, 0, 8, 8))
+	for i := 0; i < len(gray.Pix); i += 2 {
+		gray.Pix[i], gray.Pix[i+1] = uint8(i), uint8(i*37+1)
+	}
+	rgba := image.NewNRGBA64(image.Rect(0, 0, 8, 8))
+	for i := range rgba.Pix {
+		rgba.Pix[i] = uint8(i*13 + 7)
+	}
+	for name, img := range map[string]image.Image{"gray.png": gray, "rgba.png": rgba} {
+		f, err := os.Create(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := png.Encode(f, img); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+	}
+	for _, test := range []struct {
+		name     string
+		margin   int64
+		src      image.Image
+		expected string
+	}{
+		{"gray.png", 0, gray, "*image.Gray16"},
+		{"rgba.png", 1, rgba, "*image.NRGBA64"},
+	} {
+		collage := NewSemibranCollage(os.DirFS(dir), 0, test.margin, 0, 0, 0, 0)
+		collage.SetColorMode(ColorModeAuto, 0)
+		collage.SetColorSpace(SRGBProfile())
+		if err := collage.SetDepth(16); err != nil {
+			t.Fatal(err)
+		}
+		if err := collage.AddImageFile(test.name); err != nil {
+			t.Fatal(err)
+		}
+		layout, err := collage.Pack()
+		if err != nil {
+			t.Fatal(err)
+		}
+		atlas, err := collage.CreateImage(layout)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprintf("%T", atlas) != test.expected {
+			t.Fatalf("%s: expected %s atlas, got %T", test.name, test.expected, atlas)
+		}
+		pLayout, err := collage.CreateLayout(layout)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pfs, err := PictureFS.NewFS(atlas, *pLayout)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := PictureFS.ReadFile(pfs, test.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, err := png.Decode(bytes.NewBuffer(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprintf("%T", result) != test.expected {
+			t.Errorf("%s: expected %s sub-image, got %T", test.name, test.expected, result)
+		}
+		for y := 0; y < 8; y++ {
+			for x := 0; x < 8; x++ {
+				a := color.NRGBA64Model.Convert(result.At(x, y))
+				b := color.NRGBA64Model.Convert(test.src.At(x, y))
+				if a != b {
+					t.Fatalf("%s pixel %v/%v: expected %v, got %v", test.name, x, y, b, a)
+				}
+			}
+		}
+	}
+}
+
+// writes an uncompressed little endian gray tiff with one directory per page
+func writeGrayTIFF(pages []*image.Gray) []byte {
+	var buf = []byte{'I', 'I', 42, 0, 0, 0, 0, 0}
+	var next = 4
+	for _, page := range pages {
+		var w, h = page.Rect.Dx(), page.Rect.Dy()
+		var data = len(buf)
+		buf = append(buf, page.Pix...)
+		if len(buf)%2 == 1 {
+			buf = append(buf, 0)
+		}
+		binary.LittleEndian.PutUint32(buf[next:], uint32(len(buf)))
+		var entries = [][3]uint32{
+			{256, 4, uint32(w)},     // ImageWidth
+			{257, 4, uint32(h)},     // ImageLength
+			{258, 3, 8},             // BitsPerSample
+			{259, 3, 1},             // Compression: none
+			{262, 3, 1},             // PhotometricInterpretation: BlackIsZero
+			{273, 4, uint32(data)},  // StripOffsets
+			{277, 3, 1},             // SamplesPerPixel
+			{278, 4, uint32(h)},     // RowsPerStrip
+			{279, 4, uint32(w * h)}, // StripByteCounts
+		}
+		buf = append(buf, byte(len(entries)), 0)
+		for _, e := range entries {
+			var entry = make([]byte, 12)
+			binary.LittleEndian.PutUint16(entry[0:], uint16(e[0]))
+			binary.LittleEndian.PutUint16(entry[2:], uint16(e[1]))
+			binary.LittleEndian.PutUint32(entry[4:], 1)
+			if e[1] == 3 {
+				binary.LittleEndian.PutUint16(entry[8:], uint16(e[2]))
+			} else {
+				binary.LittleEndian.PutUint32(entry[8:], e[2])
+			}
+			buf = append(buf, entry...)
+		}
+		next = len(buf)
+		buf = append(buf, 0, 0, 0, 0)
+	}
+	return buf
+}
+
+// countingFS counts the opened files
+type countingFS struct {
+	fs.FS
+	opened map[string]int
+}
+
+func (cfs *countingFS) Open(name string) (fs.File, error) {
+	cfs.opened[name]++
+	return cfs.FS.Open(name)
+}
+
+func TestFormats(t *testing.T) {
+	dir := t.TempDir()
+	pages := []*image.Gray{}
+	for i := 0; i < 2; i++ {
+		page := image.NewGray(image.Rect(0, 0, 6+i*2, 5))
+		for j := range page.Pix {
+			page.Pix[j] = uint8(j*7 + i*100)
+		}
+		pages = append(pages, page)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "scan.tif"), writeGrayTIFF(pages), 0644); err != nil {
+		t.Fatal(err)
+	}
+	icon := image.NewNRGBA(image.Rect(0, 0, 4, 3))
+	for j := range icon.Pix {
+		icon.Pix[j] = uint8(j * 13)
+		if j%4 == 3 {
+			icon.Pix[j] = 0xff
+		}
+	}
+	f, err := os.Create(filepath.Join(dir, "icon.bmp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bmp.Encode(f, icon); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	if count, err := TIFFPageCount(os.DirFS(dir), "scan.tif"); err != nil || count != 2 {
+		t.Fatalf("expected 2 pages, got %v (%v)", count, err)
+	}
+	fsys := &countingFS{FS: os.DirFS(dir), opened: map[string]int{}}
+	collage := NewSemibranCollage(fsys, 0, 1, 0, 0, 0, 0)
+	for _, name := range []string{"scan.tif", "icon.bmp"} {
+		if err := collage.AddImageFile(name); err != nil {
+			t.Fatalf("cannot add %s: %v", name, err)
+		}
+	}
+	layout, err := collage.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the pages are rendered in bands of one row, but the tiff is read only once
+	fsys.opened = map[string]int{}
+	if err := collage.EncodeImageBands(&bytes.Buffer{}, layout, 1); err != nil {
+		t.Fatal(err)
+	}
+	if fsys.opened["scan.tif"] != 1 {
+		t.Errorf("scan.tif opened %v times", fsys.opened["scan.tif"])
+	}
+	atlas, err := collage.CreateImage(layout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pLayout, err := collage.CreateLayout(layout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pfs, err := PictureFS.NewFS(atlas, *pLayout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]image.Image{
+		"scan.tif/page-0001.png": pages[0],
+		"scan.tif/page-0002.png": pages[1],
+		"icon.bmp":               icon,
+	}
+	for name, src := range expected {
+		data, err := PictureFS.ReadFile(pfs, name)
+		if err != nil {
+			t.Fatalf("cannot read %s: %v", name, err)
+		}
+		result, _, err := image.Decode(bytes.NewBuffer(data))
+		if err != nil {
+			t.Fatalf("cannot decode %s: %v", name, err)
+		}
+		if result.Bounds().Size() != src.Bounds().Size() {
+			t.Fatalf("%s: expected size %v, got %v", name, src.Bounds().Size(), result.Bounds().Size())
+		}
+		for y := 0; y < src.Bounds().Dy(); y++ {
+			for x := 0; x < src.Bounds().Dx(); x++ {
+				a := color.NRGBAModel.Convert(result.At(x, y))
+				b := color.NRGBAModel.Convert(src.At(x, y))
+				if a != b {
+					t.Fatalf("%s pixel %v/%v: expected %v, got %v", name, x, y, b, a)
+				}
+			}
+		}
+	}
+}
+
+func TestSVGSource(t *testing.T) {
+	dir := t.TempDir()
+	svg := `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 20 10">
+<rect x="0" y="0" width="10" height="10" fill="#ff0000"/>
+<rect x="10" y="0" width="10" height="10" fill="#0000ff"/>
+</svg>`
+	if err := os.WriteFile(filepath.Join(dir, "icon.svg"), []byte(svg), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		r             Rasterization
+		width, height int
+	}{
+		{Rasterization{}, 20, 10},
+		{Rasterization{DPI: 192}, 40, 20},
+		{Rasterization{Width: 16, Height: 16}, 16, 8},
+		{Rasterization{Height: 30}, 60, 30},
+	}
+	for _, test := range tests {
+		collage := NewSemibranCollage(os.DirFS(dir), 0, 1, 0, 0, 0, 0)
+		collage.SetRasterization(test.r)
+		if err := collage.AddImageFile("icon.svg"); err != nil {
+			t.Fatal(err)
+		}
+		layout, err := collage.Pack()
+		if err != nil {
+			t.Fatal(err)
+		}
+		atlas, err := collage.CreateImage(layout)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pLayout, err := collage.CreateLayout(layout)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rect := pLayout.Images[0]
+		if rect.Width != test.width || rect.Height != test.height {
+			t.Fatalf("%+v: expected %vx%v, got %vx%v", test.r, test.width, test.height, rect.Width, rect.Height)
+		}
+		if rect.Source == nil || rect.Source.Format != "svg" || RasterizationFromInfo(rect.Source) != test.r {
+			t.Fatalf("%+v: invalid source info %+v", test.r, rect.Source)
+		}
+		left := color.NRGBAModel.Convert(atlas.At(rect.X+1, rect.Y+rect.Height/2))
+		right := color.NRGBAModel.Convert(atlas.At(rect.X+rect.Width-2, rect.Y+rect.Height/2))
+		if left != (color.NRGBA{R: 255, A: 255}) || right != (color.NRGBA{B: 255, A: 255}) {
+			t.Errorf("%+v: expected red and blue, got %v and %v", test.r, left, right)
+		}
+	}
+}
+
+// writePDF returns a pdf with a 20x10 pt page, which is red on the left and blue on the right
+func writePDF() []byte {
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 20 10] /Contents 4 0 R >>",
+	}
+	content := "1 0 0 rg 0 0 10 10 re f 0 0 1 rg 10 0 10 10 re f"
+	objects = append(objects, fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(content), content))
+	buf := &bytes.Buffer{}
+	buf.WriteString("%PDF-1.4\n")
+	offsets := []int{}
+	for i, obj := range objects {
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(buf, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+	xref := buf.Len()
+	fmt.Fprintf(buf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return buf.Bytes()
+}
+
+func TestPDFSource(t *testing.T) {
+	fsys := fstest.MapFS{"page.pdf": &fstest.MapFile{Data: writePDF()}}
+	// pdf files need the PDFSource
+	collage := NewSemibranCollage(fsys, 0, 1, 0, 0, 0, 0)
+	if err := collage.AddImageFile("page.pdf"); err == nil {
+		t.Fatal("expected error for pdf without PDFSource")
+	}
+	if _, err := exec.LookPath("pdftoppm"); err != nil {
+		t.Skip("pdftoppm not found")
+	}
+	tests := []struct {
+		r             Rasterization
+		width, height int
+	}{
+		{Rasterization{DPI: 72}, 20, 10},
+		{Rasterization{DPI: 144}, 40, 20},
+		{Rasterization{Width: 16, Height: 16}, 16, 8},
+		{Rasterization{Height: 30}, 60, 30},
+	}
+	for _, test := range tests {
+		collage := NewSemibranCollage(fsys, 0, 1, 0, 0, 0, 0)
+		collage.AddSource(&PDFSource{})
+		collage.SetRasterization(test.r)
+		if err := collage.AddImageFile("page.pdf"); err != nil {
+			t.Fatal(err)
+		}
+		layout, err := collage.Pack()
+		if err != nil {
+			t.Fatal(err)
+		}
+		atlas, err := collage.CreateImage(layout)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pLayout, err := collage.CreateLayout(layout)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rect := pLayout.Images[0]
+		if rect.Width != test.width || rect.Height != test.height {
+			t.Fatalf("%+v: expected %vx%v, got %vx%v", test.r, test.width, test.height, rect.Width, rect.Height)
+		}
+		if rect.Source == nil || rect.Source.Format != "pdf" {
+			t.Fatalf("%+v: invalid source info %+v", test.r, rect.Source)
+		}
+		left := color.NRGBAModel.Convert(atlas.At(rect.X+1, rect.Y+rect.Height/2)).(color.NRGBA)
+		right := color.NRGBAModel.Convert(atlas.At(rect.X+rect.Width-2, rect.Y+rect.Height/2)).(color.NRGBA)
+		if left.R < 200 || left.B > 50 || right.B < 200 || right.R > 50 {
+			t.Errorf("%+v: expected red and blue, got %v and %v", test.r, left, right)
+		}
+	}
+}
+
+func TestMapFS(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 6, 4))
+	for i := range src.Pix {
+		src.Pix[i] = uint8(i * 11)
+		if i%4 == 3 {
+			src.Pix[i] = 0xff
+		}
+	}
+	buf := &bytes.Buffer{}
+	if err := png.Encode(buf, src); err != nil {
+		t.Fatal(err)
+	}
+	fsys := fstest.MapFS{
+		"icons/a.png": &fstest.MapFile{Data: buf.Bytes()},
+		"icons/b.svg": &fstest.MapFile{Data: []byte(`<svg xmlns="http://www.w3.org/2000/svg" width="8" height="8"><rect width="8" height="8" fill="#00ff00"/></svg>`)},
+	}
+	collage := NewSemibranCollage(fsys, 0, 1, 0, 0, 0, 0)
+	// layout paths may have a leading slash
+	for _, name := range []string{"/icons/a.png", "icons/b.svg"} {
+		if err := collage.AddImageFile(name); err != nil {
+			t.Fatalf("cannot add %s: %v", name, err)
+		}
+	}
+	if err := collage.AddImageFile("icons/missing.png"); err == nil {
+		t.Fatal("expected error for missing file")
+	}
+	layout, err := collage.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	atlas, err := collage.CreateImage(layout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pLayout, err := collage.CreateLayout(layout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rect := range pLayout.Images {
+		var expected image.Image = image.NewUniform(color.NRGBA{G: 255, A: 255})
+		if rect.Path == "/icons/a.png" {
+			expected = src
+		}
+		for y := 0; y < rect.Height; y++ {
+			for x := 0; x < rect.Width; x++ {
+				a := color.NRGBAModel.Convert(atlas.At(rect.X+x, rect.Y+y))
+				b := color.NRGBAModel.Convert(expected.At(x, y))
+				if a != b {
+					t.Fatalf("%s pixel %v/%v: expected %v, got %v", rect.Path, x, y, b, a)
+				}
+			}
+		}
+	}
+}
+
+func TestFSImage(t *testing.T) {
+	dir := t.TempDir()
+	names := createTestImages(t, dir, 6)
+	collage := NewSemibranCollage(os.DirFS(dir), 0, 1, 0, 0, 0, 0)
+	for _, name := range names {
+		if err := collage.AddImageFile(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	layout, err := collage.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := collage.CreateImage(layout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	atlas, ok := img.(*image.NRGBA)
+	if !ok {
+		t.Fatalf("expected NRGBA atlas, got %T", img)
+	}
+	pLayout, err := collage.CreateLayout(layout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pfs, err := PictureFS.NewFS(atlas, *pLayout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := &bytes.Buffer{}
+	if err := EncodePNG(buf, atlas); err != nil {
+		t.Fatal(err)
+	}
+	streamFS, err := PictureFS.NewFSReader(buf, *pLayout)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	walked := []string{}
+	if err := pfs.WalkImages(func(name string, rect PictureFS.Rect) error {
+		walked = append(walked, name)
+		bounds := image.Rect(rect.X, rect.Y, rect.X+rect.Width, rect.Y+rect.Height)
+		if pfs.Bounds(name) != bounds {
+			t.Errorf("%s: expected bounds %v, got %v", name, bounds, pfs.Bounds(name))
+		}
+		view, err := pfs.Image(name)
+		if err != nil {
+			t.Fatalf("cannot get image %s: %v", name, err)
+		}
+		if view.Bounds() != bounds {
+			t.Errorf("%s: expected view bounds %v, got %v", name, bounds, view.Bounds())
+		}
+		// the view shares the pixels of the atlas
+		if &view.(*image.NRGBA).Pix[0] != &atlas.Pix[atlas.PixOffset(rect.X, rect.Y)] {
+			t.Errorf("%s: view is a copy", name)
+		}
+		decoded, err := streamFS.Image(name)
+		if err != nil {
+			t.Fatalf("cannot decode image %s: %v", name, err)
+		}
+		for y := 0; y < rect.Height; y++ {
+			for x := 0; x < rect.Width; x++ {
+				a := color.NRGBAModel.Convert(decoded.At(x, y))
+				b := color.NRGBAModel.Convert(view.At(rect.X+x, rect.Y+y))
+				if a != b {
+					t.Fatalf("%s pixel %v/%v: expected %v, got %v", name, x, y, b, a)
+				}
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(walked) != len(names) {
+		t.Fatalf("expected %v images, got %v", len(names), walked)
+	}
+	for i := 1; i < len(walked); i++ {
+		if walked[i-1] >= walked[i] {
+			t.Errorf("images not sorted: %v", walked)
+		}
+	}
+	if _, err := pfs.Image("missing.png"); err == nil {
+		t.Error("expected error for missing image")
+	}
+	if !pfs.Bounds("missing.png").Empty() {
+		t.Error("expected empty bounds for missing image")
+	}
+}
+
+func TestVariants(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 8, 6))
+	draw.Draw(src, src.Rect, image.NewUniform(color.NRGBA{R: 200, G: 40, B: 10, A: 255}), image.Point{}, draw.Src)
+	buf := &bytes.Buffer{}
+	if err := png.Encode(buf, src); err != nil {
+		t.Fatal(err)
+	}
+	collage := NewSemibranCollage(fstest.MapFS{"img/a.png": &fstest.MapFile{Data: buf.Bytes()}}, 0, 1, 0, 0, 0, 0)
+	if err := collage.AddImageFile("img/a.png"); err != nil {
+		t.Fatal(err)
+	}
+	layout, err := collage.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	atlas, err := collage.CreateImage(layout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pLayout, err := collage.CreateLayout(layout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pfs, err := PictureFS.NewFS(atlas, *pLayout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name          string
+		format        string
+		width, height int
+		gray          bool
+	}{
+		{"img/a.png@2x", "png", 16, 12, false},
+		{"img/a.png@0.5x", "png", 4, 3, false},
+		{"img/a.png?w=4", "png", 4, 3, false},
+		{"img/a.png?w=32&h=12", "png", 16, 12, false},
+		{"img/a.jpg", "jpeg", 8, 6, false},
+		{"img/a.gif?h=3#gray", "gif", 4, 3, true},
+		{"img/a.png#gray,fliph", "png", 8, 6, true},
+		{"img/a.webp", "webp", 8, 6, false},
+		{"img/a.webp@0.5x#gray", "webp", 4, 3, true},
+	}
+	for _, test := range tests {
+		data, err := PictureFS.ReadFile(pfs, test.name)
+		if err != nil {
+			t.Fatalf("cannot read %s: %v", test.name, err)
+		}
+		img, format, err := image.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("cannot decode %s: %v", test.name, err)
+		}
+		if format != test.format {
+			t.Errorf("%s: expected format %s, got %s", test.name, test.format, format)
+		}
+		if img.Bounds().Dx() != test.width || img.Bounds().Dy() != test.height {
+			t.Errorf("%s: expected %vx%v, got %v", test.name, test.width, test.height, img.Bounds())
+		}
+		c := color.NRGBAModel.Convert(img.At(img.Bounds().Min.X+1, img.Bounds().Min.Y+1)).(color.NRGBA)
+		if gray := c.R == c.G && c.G == c.B; gray != test.gray {
+			t.Errorf("%s: unexpected color %v", test.name, c)
+		}
+	}
+	for _, name := range []string{"img/a.png#blur", "img/a.png?w=0", "img/a.png?q=1", "img/b.png@2x", "img/a.png@100000x"} {
+		if _, err := PictureFS.ReadFile(pfs, name); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestScales(t *testing.T) {
+	colors := map[string]color.NRGBA{
+		"a.png": {R: 255, A: 255},
+		"b.png": {G: 255, A: 255},
+		"c.png": {B: 255, A: 255},
+	}
+	sizes := map[string]image.Point{"a.png": {X: 6, Y: 6}, "b.png": {X: 12, Y: 3}, "c.png": {X: 18, Y: 12}}
+	fsys := fstest.MapFS{}
+	for name, size := range sizes {
+		img := image.NewNRGBA(image.Rectangle{Max: size})
+		draw.Draw(img, img.Rect, image.NewUniform(colors[name]), image.Point{}, draw.Src)
+		buf := &bytes.Buffer{}
+		if err := png.Encode(buf, img); err != nil {
+			t.Fatal(err)
+		}
+		fsys[name] = &fstest.MapFile{Data: buf.Bytes()}
+	}
+	collage := NewSemibranCollage(fsys, 1, 1, 5, 5, 5, 5)
+	if err := collage.SetScales([]int64{3, 1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	for name := range sizes {
+		if err := collage.AddImageFile(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := collage.AddRect("odd.png", 7, 6); err == nil {
+		t.Error("expected error for size not divisible by scale")
+	}
+	layout, err := collage.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	full, err := collage.CreateLayout(layout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if full.Scale != 3 || len(full.Scales) != 3 {
+		t.Errorf("unexpected scales %v of %v", full.Scales, full.Scale)
+	}
+	for _, rect := range full.Images {
+		if rect.X%6 != 0 || rect.Y%6 != 0 {
+			t.Errorf("%s: position %v/%v not aligned to 6", rect.Path, rect.X, rect.Y)
+		}
+	}
+	for _, scale := range []int64{1, 2, 3} {
+		img, err := collage.CreateScaledImage(layout, scale)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pLayout, err := collage.CreateScaledLayout(layout, scale)
+		if err != nil {
+			t.Fatal(err)
+		}
+		size := collage.ImageSize(layout)
+		if img.Bounds().Dx()*3 != size.Dx()*int(scale) || img.Bounds().Dy()*3 != size.Dy()*int(scale) {
+			t.Errorf("scale %v: unexpected size %v of %v", scale, img.Bounds(), size)
+		}
+		for i, rect := range pLayout.Images {
+			var expected = full.Images[i]
+			if rect.X*3 != expected.X*int(scale) || rect.Y*3 != expected.Y*int(scale) ||
+				rect.Width*3 != expected.Width*int(scale) || rect.Height*3 != expected.Height*int(scale) {
+				t.Errorf("scale %v: rect %v does not match %v", scale, rect, expected)
+			}
+		}
+		pfs, err := PictureFS.NewFS(img, *pLayout)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pfs.Scale() != int(scale) {
+			t.Errorf("expected filesystem scale %v, got %v", scale, pfs.Scale())
+		}
+		for name, col := range colors {
+			sub, err := pfs.Image(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b := sub.Bounds()
+			if b.Dx() != sizes[name].X*int(scale)/3 {
+				t.Errorf("scale %v: unexpected bounds %v of %s", scale, b, name)
+			}
+			if c := color.NRGBAModel.Convert(sub.At(b.Min.X+b.Dx()/2, b.Min.Y+b.Dy()/2)); c != col {
+				t.Errorf("scale %v: unexpected color %v of %s", scale, c, name)
+			}
+		}
+	}
+	if PictureFS.ScaledPath("dir/collage.png.json", 2) != "dir/collage@2x.png.json" {
+		t.Errorf("unexpected scaled path %s", PictureFS.ScaledPath("dir/collage.png.json", 2))
+	}
+	if s := PictureFS.SelectScale([]int{1, 3}, 2); s != 3 {
+		t.Errorf("expected scale 3, got %v", s)
+	}
+	if s := PictureFS.SelectScale([]int{1, 2}, 3); s != 2 {
+		t.Errorf("expected scale 2, got %v", s)
+	}
+}
+
+func TestPlacement(t *testing.T) {
+	collage := NewSemibranCollage(nil, 0, 0, 0, 0, 0, 0)
+	for _, name := range []string{"/big.png", "/logo.png", "/icons/a.png", "/icons/b.png", "/icons/c.png", "/x/d.png", "/x/e.png"} {
+		size := int64(10)
+		if name == "/big.png" {
+			size = 40
+		}
+		if err := collage.AddRect(name, size, size); err != nil {
+			t.Fatal(err)
+		}
+	}
+	collage.SetPin("/logo.png", 0, 0)
+	collage.SetGroupFunc(DirectoryGroup)
+	collage.SetPriority("/x/e.png", 1)
+	layout, err := collage.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rects := map[string]Rect{}
+	for _, rect := range layout.Rects {
+		rects[rect.Name] = rect
+		for _, other := range layout.Rects {
+			if rect.Name != other.Name && intersects(rect, other) {
+				t.Errorf("%s overlaps %s", rect.Name, other.Name)
+			}
+		}
+	}
+	if len(rects) != 7 {
+		t.Fatalf("expected 7 rects, got %v", len(rects))
+	}
+	if logo := rects["/logo.png"]; logo.X != 0 || logo.Y != 0 {
+		t.Errorf("pinned rect at %v/%v", logo.X, logo.Y)
+	}
+	// the group is placed before the larger image
+	if d, big := rects["/x/d.png"], rects["/big.png"]; d.X+d.Y > big.X+big.Y {
+		t.Errorf("group with priority placed after larger image: %v %v", d, big)
+	}
+	for _, group := range [][]string{{"/icons/a.png", "/icons/b.png", "/icons/c.png"}, {"/x/d.png", "/x/e.png"}} {
+		// the bounding box of a contiguous group contains no other rects
+		var box = rects[group[0]]
+		for _, name := range group[1:] {
+			r := rects[name]
+			x0, y0 := box.X, box.Y
+			if r.X < x0 {
+				x0 = r.X
+			}
+			if r.Y < y0 {
+				y0 = r.Y
+			}
+			box.Width = max(box.X+box.Width, r.X+r.Width) - x0
+			box.Height = max(box.Y+box.Height, r.Y+r.Height) - y0
+			box.X, box.Y = x0, y0
+		}
+		for name, rect := range rects {
+			if DirectoryGroup(name) != DirectoryGroup(group[0]) && intersects(box, rect) {
+				t.Errorf("%s lies within group %s", name, DirectoryGroup(group[0]))
+			}
+		}
+	}
+
+	collage.SetPin("/big.png", 5, 5)
+	if _, err := collage.Pack(); err == nil {
+		t.Error("expected error for overlapping pins")
+	}
+
+	rects2 := []*STBRPRect{NewSTBRPRect(50, 50), NewSTBRPRect(10, 10)}
+	rects2[1].SetPriority(1)
+	small := rects2[1]
+	if STBRPPackRects(newSTBRPTestContext(100, 100), rects2) != 1 {
+		t.Fatal("cannot pack rects")
+	}
+	if small.x != 0 || small.y != 0 {
+		t.Errorf("rect with priority packed at %v/%v", small.x, small.y)
+	}
+}
+
+func newSTBRPTestContext(width, height int) *STBRPContext {
+	context := &STBRPContext{extra: make([]STBRPNode, 2)}
+	nodes := []*STBRPNode{}
+	for i := 0; i < width; i++ {
+		nodes = append(nodes, &STBRPNode{})
+	}
+	STBRPInitTarget(context, width, height, nodes)
+	return context
+}
+
+func TestSTBRPPackRects(t *testing.T) {
+	sizes := [][2]int{{30, 20}, {10, 60}, {50, 50}, {20, 20}, {40, 10}, {25, 35}}
+	rects := []*STBRPRect{}
+	for _, size := range sizes {
+		rects = append(rects, NewSTBRPRect(size[0], size[1]))
+	}
+	if STBRPPackRects(newSTBRPTestContext(100, 100), rects) != 1 {
+		t.Fatal("cannot pack rects")
+	}
+	for i, rect := range rects {
+		// the rects are returned in their original order
+		if int(rect.w) != sizes[i][0] || int(rect.h) != sizes[i][1] {
+			t.Fatalf("rect %v has size %vx%v, expected %vx%v", i, rect.w, rect.h, sizes[i][0], sizes[i][1])
+		}
+		if rect.was_packed != 1 {
+			t.Errorf("rect %v not packed", i)
+		}
+		if rect.x < 0 || rect.y < 0 || int(rect.x+rect.w) > 100 || int(rect.y+rect.h) > 100 {
+			t.Errorf("rect %v at %v/%v outside of 100x100", i, rect.x, rect.y)
+		}
+		for j, other := range rects[:i] {
+			if rect.x < other.x+other.w && other.x < rect.x+rect.w && rect.y < other.y+other.h && other.y < rect.y+rect.h {
+				t.Errorf("rect %v overlaps rect %v", i, j)
+			}
+		}
+	}
+	// the tallest rect is placed first
+	if rects[1].x != 0 || rects[1].y != 0 {
+		t.Errorf("tallest rect packed at %v/%v", rects[1].x, rects[1].y)
+	}
+
+	tooBig := []*STBRPRect{NewSTBRPRect(60, 60), NewSTBRPRect(60, 60)}
+	if STBRPPackRects(newSTBRPTestContext(100, 100), tooBig) != 0 {
+		t.Error("expected packing to fail")
+	}
+	if tooBig[0].was_packed+tooBig[1].was_packed != 1 {
+		t.Error("expected exactly one packed rect")
+	}
+}
+
+func TestGalleryCollages(t *testing.T) {
+	fsys := fstest.MapFS{}
+	sizes := []image.Point{{X: 40, Y: 30}, {X: 30, Y: 40}, {X: 80, Y: 20}, {X: 20, Y: 20}, {X: 60, Y: 30}}
+	for i, size := range sizes {
+		img := image.NewNRGBA(image.Rectangle{Max: size})
+		draw.Draw(img, img.Rect, image.NewUniform(color.NRGBA{R: uint8(50 * i), G: 100, B: 200, A: 255}), image.Point{}, draw.Src)
+		buf := &bytes.Buffer{}
+		if err := png.Encode(buf, img); err != nil {
+			t.Fatal(err)
+		}
+		fsys[fmt.Sprintf("img%d.png", i)] = &fstest.MapFile{Data: buf.Bytes()}
+	}
+	collages := map[string]Collage{
+		"grid":      NewGridCollage(fsys, 32, 32, 2, 4, 10),
+		"justified": NewJustifiedCollage(fsys, 200, 30, 4, 10),
+		"masonry":   NewMasonryCollage(fsys, 2, 50, 4, 10),
+	}
+	for mode, collage := range collages {
+		for i := range sizes {
+			if err := collage.AddImageFile(fmt.Sprintf("/img%d.png", i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		layout, err := collage.Pack()
+		if err != nil {
+			t.Fatalf("%s: %v", mode, err)
+		}
+		if len(layout.Rects) != len(sizes) {
+			t.Fatalf("%s: expected %v rects, got %v", mode, len(sizes), len(layout.Rects))
+		}
+		// the layout does not include the margin
+		bounds := image.Rect(0, 0, int(layout.Width), int(layout.Height))
+		if size := collage.ImageSize(layout); size != image.Rect(0, 0, int(layout.Width)+20, int(layout.Height)+20) {
+			t.Errorf("%s: unexpected image size %v", mode, size)
+		}
+		for _, rect := range layout.Rects {
+			r := image.Rect(int(rect.X), int(rect.Y), int(rect.X+rect.Width), int(rect.Y+rect.Height))
+			if !r.In(bounds) {
+				t.Errorf("%s: %s %v outside of %v", mode, rect.Name, r, bounds)
+			}
+			for _, other := range layout.Rects {
+				if rect.Name != other.Name && intersects(rect, other) {
+					t.Errorf("%s: %s overlaps %s", mode, rect.Name, other.Name)
+				}
+			}
+		}
+		switch mode {
+		case "grid":
+			if layout.Width != 2*32+4 || layout.Height != 3*32+2*4 {
+				t.Errorf("grid: unexpected size %vx%v", layout.Width, layout.Height)
+			}
+		case "justified":
+			// all rows but the last end at the width
+			rows := map[int64]int64{}
+			for _, rect := range layout.Rects {
+				rows[rect.Y] = max(rows[rect.Y], rect.X+rect.Width)
+			}
+			var last int64
+			for y := range rows {
+				last = max(last, y)
+			}
+			for y, right := range rows {
+				if y != last && right != 200 {
+					t.Errorf("justified: row %v ends at %v", y, right)
+				}
+			}
+		case "masonry":
+			for _, rect := range layout.Rects {
+				if rect.Width != 50 {
+					t.Errorf("masonry: unexpected width %v of %s", rect.Width, rect.Name)
+				}
+			}
+		}
+		img, err := collage.CreateImage(layout)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pLayout, err := collage.CreateLayout(layout)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pfs, err := PictureFS.NewFS(img, *pLayout)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, rect := range pLayout.Images {
+			if rect.X != int(layout.Rects[i].X)+10 || rect.Y != int(layout.Rects[i].Y)+10 {
+				t.Errorf("%s: %s at %v/%v, expected the layout position plus margin", mode, rect.Path, rect.X, rect.Y)
+			}
+			sub, err := pfs.Image(rect.Path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b := sub.Bounds()
+			if c := color.NRGBAModel.Convert(sub.At(b.Min.X+b.Dx()/2, b.Min.Y+b.Dy()/2)).(color.NRGBA); c.A != 255 || c.B != 200 {
+				t.Errorf("%s: unexpected color %v of %s", mode, c, rect.Path)
+			}
+		}
+	}
+}
+
+// TestMasonryColumns packs a masonry collage without columns, the default of -mode masonry
+func TestMasonryColumns(t *testing.T) {
+	fsys := fstest.MapFS{}
+	for i := 0; i < 10; i++ {
+		img := image.NewNRGBA(image.Rect(0, 0, 20, 20))
+		buf := &bytes.Buffer{}
+		if err := png.Encode(buf, img); err != nil {
+			t.Fatal(err)
+		}
+		fsys[fmt.Sprintf("img%d.png", i)] = &fstest.MapFile{Data: buf.Bytes()}
+	}
+	for images, columns := range map[int]int64{0: 0, 1: 1, 4: 2, 9: 3, 10: 3} {
+		mc := NewMasonryCollage(fsys, 0, 50, 4, 10)
+		for i := 0; i < images; i++ {
+			if err := mc.AddImageFile(fmt.Sprintf("img%d.png", i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		layout, err := mc.Pack()
+		if err != nil {
+			t.Fatalf("%v images: %v", images, err)
+		}
+		if len(layout.Rects) != images {
+			t.Fatalf("%v images: got %v rects", images, len(layout.Rects))
+		}
+		if width := columns*50 + max(0, columns-1)*4; layout.Width != width {
+			t.Errorf("%v images: width %v, expected %v columns", images, layout.Width, columns)
+		}
+	}
+}
+
+func TestGalleryColorSpace(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+	draw.Draw(img, img.Rect, image.NewUniform(color.NRGBA{R: 255, G: 40, B: 10, A: 255}), image.Point{}, draw.Src)
+	buf := &bytes.Buffer{}
+	if err := png.Encode(buf, img); err != nil {
+		t.Fatal(err)
+	}
+	fsys := fstest.MapFS{"a.png": &fstest.MapFile{Data: buf.Bytes()}}
+	profile, err := ParseColorSpace("p3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gc := NewGridCollage(fsys, 8, 8, 1, 0, 2)
+	gc.SetColorSpace(profile)
+	if err := gc.AddImageFile("a.png"); err != nil {
+		t.Fatal(err)
+	}
+	layout, err := gc.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	atlas, err := gc.CreateImage(layout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pLayout, err := gc.CreateLayout(layout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(pLayout.ICCProfile, profile.Data) {
+		t.Error("profile not embedded into the layout")
+	}
+	expected := color.NRGBAModel.Convert(ConvertColors(img, SRGBProfile(), profile).At(4, 4))
+	if c := color.NRGBAModel.Convert(atlas.At(2+4, 2+4)); c != expected {
+		t.Errorf("pixel %v, expected %v in the working space", c, expected)
+	}
+}
+
+// exifJPEG returns a jpeg image of size w x h with an exif DateTimeOriginal
+func exifJPEG(t *testing.T, w, h int, dateTime string) []byte {
+	buf := &bytes.Buffer{}
+	if err := jpeg.Encode(buf, image.NewGray(image.Rect(0, 0, w, h)), nil); err != nil {
+		t.Fatal(err)
+	}
+	// little endian tiff: ifd0 with exif ifd pointer, exif ifd with DateTimeOriginal
+	tiff := &bytes.Buffer{}
+	le := binary.LittleEndian
+	tiff.WriteString("II*\x00")
+	binary.Write(tiff, le, uint32(8))
+	binary.Write(tiff, le, uint16(1))
+	binary.Write(tiff, le, []uint16{0x8769, 4})
+	binary.Write(tiff, le, []uint32{1, 26})
+	binary.Write(tiff, le, uint32(0))
+	binary.Write(tiff, le, uint16(1))
+	binary.Write(tiff, le, []uint16{0x9003, 2})
+	binary.Write(tiff, le, []uint32{20, 44})
+	binary.Write(tiff, le, uint32(0))
+	tiff.WriteString(dateTime + "\x00")
+	app1 := append([]byte("Exif\x00\x00"), tiff.Bytes()...)
+	result := []byte{0xff, 0xd8, 0xff, 0xe1, byte((len(app1) + 2) >> 8), byte(len(app1) + 2)}
+	result = append(result, app1...)
+	return append(result, buf.Bytes()[2:]...)
+}
+
+func TestOrder(t *testing.T) {
+	for _, test := range [][2]string{{"img2.png", "img10.png"}, {"a9b", "a10a"}, {"a", "a1"}, {"x01", "x2"}} {
+		if !naturalLess(test[0], test[1]) || naturalLess(test[1], test[0]) {
+			t.Errorf("expected %s < %s", test[0], test[1])
+		}
+	}
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	fsys := fstest.MapFS{
+		"img10.jpg": &fstest.MapFile{Data: exifJPEG(t, 8, 8, "2020:01:02 03:04:05"), ModTime: now.Add(-time.Hour)},
+		"img2.jpg":  &fstest.MapFile{Data: exifJPEG(t, 16, 8, "2021:01:02 03:04:05"), ModTime: now.Add(-2 * time.Hour)},
+		"img1.jpg":  &fstest.MapFile{Data: exifJPEG(t, 8, 16, "2019:01:02 03:04:05"), ModTime: now},
+	}
+	if ct, ok := CaptureTime(fsys, "img10.jpg"); !ok || !ct.Equal(time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)) {
+		t.Errorf("unexpected capture time %v", ct)
+	}
+	tests := []struct {
+		order    Order
+		expected []string
+	}{
+		{Order{Mode: OrderName}, []string{"/img1.jpg", "/img10.jpg", "/img2.jpg"}},
+		{Order{Mode: OrderNatural}, []string{"/img1.jpg", "/img2.jpg", "/img10.jpg"}},
+		{Order{Mode: OrderNatural, Reverse: true}, []string{"/img10.jpg", "/img2.jpg", "/img1.jpg"}},
+		{Order{Mode: OrderModTime}, []string{"/img2.jpg", "/img10.jpg", "/img1.jpg"}},
+		{Order{Mode: OrderCapture}, []string{"/img1.jpg", "/img10.jpg", "/img2.jpg"}},
+		{Order{Mode: OrderSize}, []string{"/img1.jpg", "/img2.jpg", "/img10.jpg"}},
+		{Order{Mode: OrderList, List: []string{"img10.jpg", "/img1.jpg"}}, []string{"/img10.jpg", "/img1.jpg", "/img2.jpg"}},
+	}
+	for _, test := range tests {
+		grid := NewGridCollage(fsys, 16, 16, 3, 0, 0)
+		grid.SetOrder(test.order)
+		packer := NewSemibranCollage(fsys, 0, 1, 0, 0, 0, 0)
+		packer.SetOrder(test.order)
+		for _, collage := range []Collage{grid, packer} {
+			for name := range fsys {
+				if err := collage.AddImageFile("/" + name); err != nil {
+					t.Fatal(err)
+				}
+			}
+			layout, err := collage.Pack()
+			if err != nil {
+				t.Fatal(err)
+			}
+			pLayout, err := collage.CreateLayout(layout)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, rect := range pLayout.Images {
+				if rect.Path != test.expected[i] {
+					t.Errorf("order %v: expected %v, got %s at %v", test.order, test.expected, rect.Path, i)
+				}
+			}
+		}
+		// the grid is filled in order
+		layout, _ := grid.Pack()
+		if layout.Rects[0].X >= layout.Rects[1].X || layout.Rects[1].X >= layout.Rects[2].X {
+			t.Errorf("order %v: grid not filled in order: %v", test.order, layout.Rects)
+		}
+	}
+}
+
+func TestManifest(t *testing.T) {
+	pngFile := func(w, h int, c color.NRGBA, modTime time.Time) *fstest.MapFile {
+		img := image.NewNRGBA(image.Rect(0, 0, w, h))
+		draw.Draw(img, img.Rect, image.NewUniform(c), image.Point{}, draw.Src)
+		buf := &bytes.Buffer{}
+		if err := png.Encode(buf, img); err != nil {
+			t.Fatal(err)
+		}
+		return &fstest.MapFile{Data: buf.Bytes(), ModTime: modTime}
+	}
+	then := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	fsys := fstest.MapFS{
+		"a.png": pngFile(20, 10, color.NRGBA{R: 255, A: 255}, then),
+		"b.png": pngFile(10, 30, color.NRGBA{G: 255, A: 255}, then),
+		"c.png": pngFile(15, 15, color.NRGBA{B: 255, A: 255}, then),
+		"d.svg": &fstest.MapFile{Data: []byte(`<svg xmlns="http://www.w3.org/2000/svg" width="8" height="8"/>`), ModTime: then},
+	}
+	settings := ManifestSettings{Border: 1, Space: 1, Margin: 2, Canvas: CanvasOptions{Multiple: 4}}
+
+	// build runs an incremental build with the manifest of the previous run
+	build := func(prev *Manifest, seedImage image.Image) (*Manifest, Layout, image.Image) {
+		collage := NewSemibranCollage(fsys, 1, 1, 2, 2, 2, 2)
+		collage.SetCanvas(settings.Canvas)
+		manifest := NewManifest(settings)
+		for _, path := range []string{"/a.png", "/b.png", "/c.png", "/d.svg"} {
+			var prevEntry *ManifestEntry
+			if prev != nil {
+				prevEntry = prev.Entry(path)
+			}
+			var entry *ManifestEntry
+			var err error
+			if collage.NeedsImageFile(path) {
+				if entry, err = NewManifestFileEntry(fsys, path, prevEntry); err == nil {
+					err = collage.AddImageFile(path)
+				}
+			} else if entry, err = NewManifestEntry(fsys, path, prevEntry, settings); err == nil {
+				err = collage.AddRect(entry.Path, entry.Width, entry.Height)
+			}
+			if err != nil {
+				t.Fatalf("cannot add %s: %v", path, err)
+			}
+			manifest.Add(*entry)
+		}
+		if prev != nil {
+			seed := Layout{Rects: []Rect{}}
+			for _, entry := range manifest.Images {
+				if p := prev.Entry(entry.Path); p != nil && p.Hash == entry.Hash && p.Rect.Name != "" {
+					seed.Rects = append(seed.Rects, p.Rect)
+				}
+			}
+			collage.SetSeed(seed, seedImage)
+		}
+		layout, err := collage.Pack()
+		if err != nil {
+			t.Fatalf("cannot pack: %v", err)
+		}
+		img, err := collage.CreateImage(layout)
+		if err != nil {
+			t.Fatalf("cannot create image: %v", err)
+		}
+		manifest.SetLayout(layout)
+		return manifest, layout, img
+	}
+
+	first, layout1, img1 := build(nil, nil)
+	if !first.Entry("/d.svg").ModTime.Equal(then) || first.Entry("/d.svg").Width != 0 {
+		t.Errorf("unexpected entry of rasterized image: %+v", first.Entry("/d.svg"))
+	}
+	filename := filepath.Join(t.TempDir(), "manifest.json")
+	if err := first.Save(filename); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadManifest(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.SameSettings(settings) {
+		t.Error("loaded manifest has different settings")
+	}
+	changed := settings
+	changed.Canvas.Square = true
+	if loaded.SameSettings(changed) {
+		t.Error("canvas options not part of the settings")
+	}
+	changed = settings
+	changed.Pins = "/a.png=0,0"
+	if loaded.SameSettings(changed) {
+		t.Error("pins not part of the settings")
+	}
+	if loaded.Layout().Width != layout1.Width || len(loaded.Layout().Rects) != len(first.Images) {
+		t.Errorf("loaded layout %vx%v with %v rects", loaded.Layout().Width, loaded.Layout().Height, len(loaded.Layout().Rects))
+	}
+
+	// unchanged files are not read again: size and modification time are the same
+	second, _, _ := build(loaded, img1)
+	if !second.Equal(loaded) {
+		t.Error("unchanged sources not equal")
+	}
+
+	// c.png is replaced by an image of another size
+	fsys["c.png"] = pngFile(25, 5, color.NRGBA{R: 255, G: 255, A: 255}, then.Add(time.Hour))
+	third, layout3, img3 := build(loaded, img1)
+	if third.Equal(loaded) {
+		t.Error("changed source not detected")
+	}
+	if third.Entry("/a.png").Hash != loaded.Entry("/a.png").Hash || third.Entry("/c.png").Hash == loaded.Entry("/c.png").Hash {
+		t.Error("unexpected hashes of incremental build")
+	}
+	rects := map[string]Rect{}
+	for _, rect := range layout3.Rects {
+		rects[rect.Name] = rect
+	}
+	for _, rect := range layout1.Rects {
+		if rect.Name == "/c.png" {
+			if rects[rect.Name].Width == rect.Width {
+				t.Error("changed image keeps its size")
+			}
+			continue
+		}
+		if rects[rect.Name] != rect {
+			t.Errorf("unchanged image %s moved from %v to %v", rect.Name, rect, rects[rect.Name])
+		}
+	}
+	// the pixels of a.png are copied from the previous image
+	a := rects["/a.png"]
+	x, y := int(a.X+a.Width/2+2), int(a.Y+a.Height/2+2)
+	if r, g, b, _ := img3.At(x, y).RGBA(); r != 0xffff || g != 0 || b != 0 {
+		t.Errorf("pixel of seeded image changed: %v", img3.At(x, y))
+	}
+
+	// a touched file with the same content is hashed again, but has the same hash
+	fsys["a.png"].ModTime = then.Add(time.Minute)
+	fourth, _, _ := build(third, img3)
+	if fourth.Entry("/a.png").Hash != third.Entry("/a.png").Hash {
+		t.Error("hash of touched file changed")
+	}
+	if fourth.Equal(third) {
+		t.Error("modification time not part of the comparison")
+	}
+}
+
+// TestManifestDedup checks that the manifest detects the same duplicates as AddImageFile: images with the
+// same pixels, but different files
+func TestManifestDedup(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 12, 8))
+	draw.Draw(img, image.Rect(2, 2, 10, 6), image.NewUniform(color.NRGBA{R: 200, G: 30, A: 255}), image.Point{}, draw.Src)
+	fsys := fstest.MapFS{}
+	for name, level := range map[string]png.CompressionLevel{"a.png": png.DefaultCompression, "b.png": png.NoCompression} {
+		buf := &bytes.Buffer{}
+		if err := (&png.Encoder{CompressionLevel: level}).Encode(buf, img); err != nil {
+			t.Fatal(err)
+		}
+		fsys[name] = &fstest.MapFile{Data: buf.Bytes()}
+	}
+	for _, trim := range []bool{false, true} {
+		settings := ManifestSettings{Dedup: "exact", Trim: trim}
+		a, err := NewManifestEntry(fsys, "/a.png", nil, settings)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := NewManifestEntry(fsys, "/b.png", nil, settings)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a.Hash == b.Hash || a.ContentHash == "" || a.ContentHash != b.ContentHash {
+			t.Errorf("trim %v: unexpected hashes %s/%s and %s/%s", trim, a.Hash, a.ContentHash, b.Hash, b.ContentHash)
+		}
+		// the key of AddImageFile
+		collage := NewSemibranCollage(fsys, 0, 0, 0, 0, 0, 0)
+		collage.SetDedup(DedupExact, 0)
+		collage.SetTrim(trim, 0)
+		if err := collage.AddImageFile("/a.png"); err != nil {
+			t.Fatal(err)
+		}
+		if collage.hashes[a.ContentHash] != "/a.png" {
+			t.Errorf("trim %v: content hash %s not used by AddImageFile", trim, a.ContentHash)
+		}
+		// unchanged files keep the content hash
+		if c, err := NewManifestEntry(fsys, "/a.png", a, settings); err != nil || c.ContentHash != a.ContentHash {
+			t.Errorf("trim %v: content hash not taken from the previous entry", trim)
+		}
+	}
+	if entry, err := NewManifestEntry(fsys, "/a.png", nil, ManifestSettings{}); err != nil || entry.ContentHash != "" {
+		t.Error("unexpected content hash without dedup")
+	}
+}
+
+func TestTiles(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 600, 300))
+	for y := 0; y < 300; y++ {
+		for x := 0; x < 600; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{R: uint8(x), G: uint8(y), A: 255})
+		}
+	}
+	// tileSize returns the size of a png tile
+	tileSize := func(filename string) image.Point {
+		f, err := os.Open(filename)
+		if err != nil {
+			t.Fatalf("missing tile: %v", err)
+		}
+		defer f.Close()
+		cfg, err := png.DecodeConfig(f)
+		if err != nil {
+			t.Fatalf("cannot decode %s: %v", filename, err)
+		}
+		return image.Point{X: cfg.Width, Y: cfg.Height}
+	}
+
+	dir := t.TempDir()
+	if err := WriteDeepZoom(img, dir, "dz", 256, 1, "png"); err != nil {
+		t.Fatal(err)
+	}
+	levels, err := os.ReadDir(filepath.Join(dir, "dz_files"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 600 -> 300 -> ... -> 1: levels 0..10
+	if len(levels) != 11 {
+		t.Errorf("%v deep zoom levels, expected 11", len(levels))
+	}
+	for name, size := range map[string]image.Point{
+		"10/0_0.png": {X: 257, Y: 257},
+		"10/1_0.png": {X: 258, Y: 257},
+		"10/2_1.png": {X: 89, Y: 45},
+		"9/1_0.png":  {X: 45, Y: 150},
+		"0/0_0.png":  {X: 1, Y: 1},
+	} {
+		if s := tileSize(filepath.Join(dir, "dz_files", filepath.FromSlash(name))); s != size {
+			t.Errorf("deep zoom tile %s has size %v, expected %v", name, s, size)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "dz_files", "10", "3_0.png")); err == nil {
+		t.Error("unexpected deep zoom tile 10/3_0.png")
+	}
+	dzi, err := os.ReadFile(filepath.Join(dir, "dz.dzi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(dzi, []byte(`TileSize="256" Overlap="1" Format="png"`)) || !bytes.Contains(dzi, []byte(`<Size Width="600" Height="300">`)) {
+		t.Errorf("unexpected dzi %s", dzi)
+	}
+
+	dir = t.TempDir()
+	if err := WriteIIIFTiles(img, dir, "http://example.com/iiif/collage/", 256, "png"); err != nil {
+		t.Fatal(err)
+	}
+	infoBytes, err := os.ReadFile(filepath.Join(dir, "info.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := PictureFS.IIIFImageInfo{}
+	if err := json.Unmarshal(infoBytes, &info); err != nil {
+		t.Fatal(err)
+	}
+	if info.ID != "http://example.com/iiif/collage" || info.Width != 600 || info.Height != 300 || info.Profile != "level0" || info.Context != PictureFS.IIIF_IMAGE_CONTEXT {
+		t.Errorf("unexpected info.json %s", infoBytes)
+	}
+	if len(info.Tiles) != 1 || info.Tiles[0].Width != 256 || info.Tiles[0].Height != 256 || fmt.Sprint(info.Tiles[0].ScaleFactors) != "[1 2 4]" {
+		t.Errorf("unexpected tiles %+v", info.Tiles)
+	}
+	if len(info.Sizes) != 1 || info.Sizes[0] != (PictureFS.IIIFSize{Width: 150, Height: 75}) {
+		t.Errorf("unexpected sizes %+v", info.Sizes)
+	}
+	for name, size := range map[string]image.Point{
+		"0,0,256,256/256,256/0/default.png": {X: 256, Y: 256},
+		"512,256,88,44/88,44/0/default.png": {X: 88, Y: 44},
+		"512,0,88,300/44,150/0/default.png": {X: 44, Y: 150},
+		"0,0,600,300/150,75/0/default.png":  {X: 150, Y: 75},
+		"full/150,75/0/default.png":         {X: 150, Y: 75},
+	} {
+		if s := tileSize(filepath.Join(dir, filepath.FromSlash(name))); s != size {
+			t.Errorf("iiif tile %s has size %v, expected %v", name, s, size)
+		}
+	}
+	if err := WriteIIIFTiles(img, dir, "collage", 0, "png"); err == nil {
+		t.Error("expected error for tile size 0")
+	}
+	if err := WriteDeepZoom(img, t.TempDir(), "dz", 256, 0, "gif"); err == nil {
+		t.Error("expected error for tile format gif")
+	}
+
+	annotations := CreateRegionAnnotations(&PictureFS.Layout{Images: []PictureFS.Rect{
+		{Path: "/a.png", X: 1, Y: 2, Width: 3, Height: 4},
+		{Path: "/b/c.png", X: 10, Y: 20, Width: 30, Height: 40},
+	}}, "http://example.com/annotations/", "http://example.com/canvas")
+	if annotations.ID != "http://example.com/annotations/" || annotations.Type != "AnnotationPage" || len(annotations.Items) != 2 {
+		t.Fatalf("unexpected annotation page %+v", annotations)
+	}
+	item := annotations.Items[1]
+	if item.ID != "http://example.com/annotations/1" || item.Target != "http://example.com/canvas#xywh=10,20,30,40" || item.Motivation != "tagging" {
+		t.Errorf("unexpected annotation %+v", item)
+	}
+	if body, ok := item.Body.(IIIFTextualBody); !ok || body.Value != "/b/c.png" {
+		t.Errorf("unexpected annotation body %+v", item.Body)
+	}
+}
+
+func TestIIIFManifest(t *testing.T) {
+	layout := &PictureFS.Layout{Images: []PictureFS.Rect{
+		{Path: "/a.png", X: 1, Y: 2, Width: 3, Height: 4},
+		{Path: "/dir/b c.png", X: 10, Y: 20, Width: 30, Height: 40, TrimX: 2, TrimY: 3, SourceWidth: 35, SourceHeight: 45},
+	}}
+	manifest := CreateIIIFManifest(layout, "http://example.com/", "collage", "http://example.com/iiif/collage/", 100, 50, false)
+	if manifest.ID != "http://example.com/manifest.json" || manifest.Context != IIIF_PRESENTATION_CONTEXT || len(manifest.Items) != 1 {
+		t.Fatalf("unexpected manifest %+v", manifest)
+	}
+	canvas := manifest.Items[0]
+	if canvas.ID != "http://example.com/canvas/0" || canvas.Width != 100 || canvas.Height != 50 {
+		t.Errorf("unexpected canvas %+v", canvas)
+	}
+	body := canvas.Items[0].Items[0].Body.(IIIFImage)
+	if body.ID != "http://example.com/iiif/collage/full/max/0/default.jpg" || body.Service[0].ID != "http://example.com/iiif/collage" {
+		t.Errorf("unexpected painting body %+v", body)
+	}
+	if len(canvas.Annotations) != 1 || len(canvas.Annotations[0].Items) != 2 || canvas.Annotations[0].Context != "" {
+		t.Fatalf("unexpected annotations %+v", canvas.Annotations)
+	}
+	if target := canvas.Annotations[0].Items[1].Target; target != "http://example.com/canvas/0#xywh=10,20,30,40" {
+		t.Errorf("unexpected annotation target %s", target)
+	}
+
+	manifest = CreateIIIFManifest(layout, "http://example.com", "collage", "http://example.com/iiif/collage", 100, 50, true)
+	if len(manifest.Items) != 2 {
+		t.Fatalf("%v canvases, expected 2", len(manifest.Items))
+	}
+	canvas = manifest.Items[1]
+	if canvas.ID != "http://example.com/canvas/1" || canvas.Width != 35 || canvas.Height != 45 || canvas.Label["none"][0] != "b c.png" {
+		t.Errorf("unexpected canvas %+v", canvas)
+	}
+	if len(canvas.Metadata) != 2 || canvas.Metadata[1].Value["none"][0] != "/dir" {
+		t.Errorf("unexpected metadata %+v", canvas.Metadata)
+	}
+	body = canvas.Items[0].Items[0].Body.(IIIFImage)
+	if body.Service[0].ID != "http://example.com/iiif/collage/dir%2Fb%20c.png" || body.Width != 35 {
+		t.Errorf("unexpected painting body %+v", body)
+	}
+	if len(manifest.Items[0].Metadata) != 1 {
+		t.Errorf("unexpected metadata of image in root folder %+v", manifest.Items[0].Metadata)
+	}
+}
+
+func TestPNGAlias(t *testing.T) {
+	for path, alias := range map[string]string{
+		"/a.gif":         "/a.png",
+		"/A.GIF":         "/A.png",
+		"/a.gif.d/x.gif": "/a.gif.d/x.png",
+		"/dir.gif/b.gif": "/dir.gif/b.png",
+	} {
+		if result := pngAlias(path); result != alias {
+			t.Errorf("pngAlias(%s) = %s, expected %s", path, result, alias)
+		}
+	}
+}
+
+func TestAnimationColorSpace(t *testing.T) {
+	c := color.NRGBA{R: 200, G: 100, B: 50, A: 255}
+	frame := image.NewPaletted(image.Rect(0, 0, 4, 4), color.Palette{c, color.NRGBA{G: 255, A: 255}})
+	frame2 := image.NewPaletted(image.Rect(0, 0, 4, 4), frame.Palette)
+	frame2.SetColorIndex(3, 3, 1)
+	gifBuf := &bytes.Buffer{}
+	if err := gif.EncodeAll(gifBuf, &gif.GIF{Image: []*image.Paletted{frame, frame2}, Delay: []int{10, 10}}); err != nil {
+		t.Fatal(err)
+	}
+	static := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	draw.Draw(static, static.Rect, image.NewUniform(c), image.Point{}, draw.Src)
+	pngBuf := &bytes.Buffer{}
+	if err := png.Encode(pngBuf, static); err != nil {
+		t.Fatal(err)
+	}
+	fsys := fstest.MapFS{
+		"anim.gif":   &fstest.MapFile{Data: gifBuf.Bytes()},
+		"static.png": &fstest.MapFile{Data: pngBuf.Bytes()},
+	}
+	for _, mode := range []AnimationMode{AnimationFrames, AnimationFilmstrip} {
+		collage := NewSemibranCollage(fsys, 0, 1, 0, 0, 0, 0)
+		collage.SetAnimation(mode)
+		collage.SetColorSpace(DisplayP3Profile())
+		for _, name := range []string{"anim.gif", "static.png"} {
+			if err := collage.AddImageFile(name); err != nil {
+				t.Fatal(err)
+			}
+		}
+		layout, err := collage.Pack()
+		if err != nil {
+			t.Fatal(err)
+		}
+		atlas, err := collage.CreateImage(layout)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pLayout, err := collage.CreateLayout(layout)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rects := map[string]PictureFS.Rect{}
+		for _, rect := range pLayout.Images {
+			rects[rect.Path] = rect
+		}
+		converted := atlas.At(rects["static.png"].X, rects["static.png"].Y)
+		if color.NRGBAModel.Convert(converted) == c {
+			t.Fatal("static image not converted")
+		}
+		for _, anim := range pLayout.Animations {
+			for _, f := range anim.Frames {
+				rect := rects[f.Path]
+				if p := atlas.At(rect.X+f.X, rect.Y+f.Y); p != converted {
+					t.Errorf("mode %v: frame %s at %v/%v: %v, expected %v", mode, f.Path, f.X, f.Y, p, converted)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package imagecollage
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"golang.org/x/image/draw"
+	"image"
+)
+
+// SetDepth defines the bits per channel of the collage image (8 or 16).
+// 16 bit collages are created as NRGBA64 or Gray16 and keep the depth of 16 bit sources
+func (sc *SemibranCollage) SetDepth(bits int) error {
+	switch bits {
+	case 8:
+		sc.deep = false
+	case 16:
+		sc.deep = true
+	default:
+		return errors.New(fmt.Sprintf("invalid depth %v", bits))
+	}
+	return nil
+}
+
+// isGray64 returns true, if all pixels of img are opaque gray
+func isGray64(img *image.NRGBA64) bool {
+	for y := img.Rect.Min.Y; y < img.Rect.Max.Y; y++ {
+		var row = img.Pix[img.PixOffset(img.Rect.Min.X, y):img.PixOffset(img.Rect.Max.X, y)]
+		for i := 0; i < len(row); i += 8 {
+			if row[i] != row[i+2] || row[i] != row[i+4] || row[i+1] != row[i+3] || row[i+1] != row[i+5] ||
+				row[i+6] != 0xff || row[i+7] != 0xff {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// ConvertImage64 converts the 16 bit image img to the image type of mode. Gray images keep 16 bit,
+// paletted images are reduced to 8 bit
+func ConvertImage64(img *image.NRGBA64, mode ColorMode, maxColors int) image.Image {
+	switch mode {
+	case ColorModeNRGBA:
+		return img
+	case ColorModePaletted:
+		var nrgba = image.NewNRGBA(img.Rect)
+		draw.Draw(nrgba, nrgba.Rect, img, img.Rect.Min, draw.Src)
+		return ConvertImage(nrgba, mode, maxColors)
+	}
+	if mode == ColorModeGray || isGray64(img) {
+		var result = image.NewGray16(img.Rect)
+		draw.Draw(result, result.Rect, img, img.Rect.Min, draw.Src)
+		return result
+	}
+	// a palette would lose the depth
+	return img
+}
@@ -0,0 +1,139 @@
+package imagecollage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/fs"
+	"time"
+)
+
+// exif tags of the capture time
+const (
+	exifTagDateTime         = 0x0132
+	exifTagExifIFD          = 0x8769
+	exifTagDateTimeOriginal = 0x9003
+)
+
+// CaptureTime returns the EXIF DateTimeOriginal (or DateTime) of a jpeg or tiff file of fsys
+func CaptureTime(fsys fs.FS, path string) (time.Time, bool) {
+	f, err := fsys.Open(path)
+	if err != nil {
+		return time.Time{}, false
+	}
+	defer f.Close()
+	var r = bufio.NewReader(f)
+	magic, err := r.Peek(4)
+	if err != nil {
+		return time.Time{}, false
+	}
+	switch {
+	case magic[0] == 0xff && magic[1] == 0xd8:
+		data, ok := jpegExif(r)
+		if !ok {
+			return time.Time{}, false
+		}
+		return exifDateTime(data)
+	case string(magic) == "II*\x00" || string(magic) == "MM\x00*":
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return time.Time{}, false
+		}
+		return exifDateTime(data)
+	}
+	return time.Time{}, false
+}
+
+// jpegExif returns the tiff structure of the exif segment of a jpeg image
+func jpegExif(r *bufio.Reader) ([]byte, bool) {
+	if _, err := r.Discard(2); err != nil {
+		return nil, false
+	}
+	for {
+		var header [4]byte
+		if _, err := io.ReadFull(r, header[:]); err != nil || header[0] != 0xff {
+			return nil, false
+		}
+		var marker = header[1]
+		var length = int(binary.BigEndian.Uint16(header[2:])) - 2
+		// start of scan: no more metadata
+		if marker == 0xda || length < 0 {
+			return nil, false
+		}
+		if marker != 0xe1 {
+			if _, err := r.Discard(length); err != nil {
+				return nil, false
+			}
+			continue
+		}
+		var data = make([]byte, length)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, false
+		}
+		if bytes.HasPrefix(data, []byte("Exif\x00\x00")) {
+			return data[6:], true
+		}
+	}
+}
+
+// exifDateTime reads the capture time of a tiff structure
+func exifDateTime(data []byte) (time.Time, bool) {
+	if len(data) < 8 {
+		return time.Time{}, false
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	if data[0] == 'M' {
+		order = binary.BigEndian
+	}
+	// ifd returns the entries of the ifd at offset by tag (type, count, value)
+	var ifd = func(offset uint32) map[uint16][]byte {
+		var result = map[uint16][]byte{}
+		if int64(offset)+2 > int64(len(data)) {
+			return result
+		}
+		var n = int(order.Uint16(data[offset:]))
+		for i := 0; i < n; i++ {
+			var entry = int(offset) + 2 + 12*i
+			if entry+12 > len(data) {
+				break
+			}
+			result[order.Uint16(data[entry:])] = data[entry+2 : entry+12]
+		}
+		return result
+	}
+	// ascii returns the string value of an entry
+	var ascii = func(entry []byte) (string, bool) {
+		if entry == nil || order.Uint16(entry) != 2 {
+			return "", false
+		}
+		var count = order.Uint32(entry[2:])
+		var value = entry[6:10]
+		if count > 4 {
+			var offset = order.Uint32(entry[6:])
+			if int64(offset)+int64(count) > int64(len(data)) {
+				return "", false
+			}
+			value = data[offset : offset+count]
+		} else {
+			value = value[:count]
+		}
+		return string(bytes.TrimRight(value, "\x00 ")), true
+	}
+	var ifd0 = ifd(order.Uint32(data[4:]))
+	var values = []string{}
+	if entry, ok := ifd0[exifTagExifIFD]; ok && order.Uint16(entry) == 4 {
+		if str, ok := ascii(ifd(order.Uint32(entry[6:]))[exifTagDateTimeOriginal]); ok {
+			values = append(values, str)
+		}
+	}
+	if str, ok := ascii(ifd0[exifTagDateTime]); ok {
+		values = append(values, str)
+	}
+	for _, value := range values {
+		if t, err := time.Parse("2006:01:02 15:04:05", value); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,174 @@
+package imagecollage
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/pkg/errors"
+	_ "golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"strings"
+)
+
+// isTIFF returns true, if path has a tiff extension
+func isTIFF(path string) bool {
+	var ext = strings.ToLower(filepath.Ext(path))
+	return ext == ".tif" || ext == ".tiff"
+}
+
+// tiffPages returns the offsets of all image file directories of a tiff file
+func tiffPages(data []byte) ([]uint32, error) {
+	if len(data) < 8 {
+		return nil, errors.New("invalid tiff header")
+	}
+	var order binary.ByteOrder
+	switch string(data[0:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil, errors.New("invalid tiff byte order")
+	}
+	if order.Uint16(data[2:4]) != 42 {
+		return nil, errors.New("not a tiff file")
+	}
+	var pages = []uint32{}
+	var seen = map[uint32]bool{}
+	for offset := order.Uint32(data[4:8]); offset != 0; {
+		if seen[offset] {
+			return nil, errors.New("tiff directory loop")
+		}
+		seen[offset] = true
+		if int64(offset)+2 > int64(len(data)) {
+			return nil, errors.New(fmt.Sprintf("invalid tiff directory offset %v", offset))
+		}
+		pages = append(pages, offset)
+		var next = int64(offset) + 2 + 12*int64(order.Uint16(data[offset:]))
+		if next+4 > int64(len(data)) {
+			return nil, errors.New("invalid tiff directory")
+		}
+		offset = order.Uint32(data[next:])
+	}
+	return pages, nil
+}
+
+// tiffPageReader presents a tiff file with a header, which points to the directory of a page,
+// without copying the file data
+type tiffPageReader struct {
+	data   []byte
+	header [8]byte
+}
+
+func (tr *tiffPageReader) ReadAt(p []byte, off int64) (int, error) {
+	if off >= int64(len(tr.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, tr.data[off:])
+	if off < int64(len(tr.header)) {
+		copy(p, tr.header[off:])
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// decodeTIFFPage decodes the page with the directory at offset
+func decodeTIFFPage(data []byte, offset uint32) (image.Image, error) {
+	// let the header point to the directory of the page
+	var tr = &tiffPageReader{data: data}
+	copy(tr.header[:], data)
+	if string(data[0:2]) == "II" {
+		binary.LittleEndian.PutUint32(tr.header[4:8], offset)
+	} else {
+		binary.BigEndian.PutUint32(tr.header[4:8], offset)
+	}
+	return tiff.Decode(io.NewSectionReader(tr, 0, int64(len(data))))
+}
+
+// TIFFFile contains the data and the directory offsets of a multi-page tiff file,
+// so that its pages can be decoded one by one without reading the file again
+type TIFFFile struct {
+	path    string
+	data    []byte
+	offsets []uint32
+}
+
+// ReadTIFF reads a tiff file and the offsets of its pages
+func ReadTIFF(fsys fs.FS, filePath string) (*TIFFFile, error) {
+	data, err := fs.ReadFile(fsys, filePath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot read %s", filePath)
+	}
+	offsets, err := tiffPages(data)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot read tiff %s", filePath)
+	}
+	return &TIFFFile{path: filePath, data: data, offsets: offsets}, nil
+}
+
+// PageCount returns the number of pages
+func (tf *TIFFFile) PageCount() int {
+	return len(tf.offsets)
+}
+
+// Page decodes page index (0 based)
+func (tf *TIFFFile) Page(index int) (image.Image, error) {
+	if index < 0 || index >= len(tf.offsets) {
+		return nil, errors.New(fmt.Sprintf("page %v of %s not found", index+1, tf.path))
+	}
+	img, err := decodeTIFFPage(tf.data, tf.offsets[index])
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot decode page %v of %s", index+1, tf.path)
+	}
+	return img, nil
+}
+
+// LoadTIFFPages decodes all pages of a tiff file
+func LoadTIFFPages(fsys fs.FS, filePath string) ([]image.Image, error) {
+	tf, err := ReadTIFF(fsys, filePath)
+	if err != nil {
+		return nil, err
+	}
+	var pages = []image.Image{}
+	for i := range tf.offsets {
+		page, err := tf.Page(i)
+		if err != nil {
+			return nil, err
+		}
+		pages = append(pages, page)
+	}
+	return pages, nil
+}
+
+// LoadTIFFPage decodes page index of a tiff file. The file is read for every call,
+// use ReadTIFF to decode several pages
+func LoadTIFFPage(fsys fs.FS, filePath string, index int) (image.Image, error) {
+	tf, err := ReadTIFF(fsys, filePath)
+	if err != nil {
+		return nil, err
+	}
+	return tf.Page(index)
+}
+
+// TIFFPageCount returns the number of pages of a tiff file
+func TIFFPageCount(fsys fs.FS, filePath string) (int, error) {
+	tf, err := ReadTIFF(fsys, filePath)
+	if err != nil {
+		return 0, err
+	}
+	return tf.PageCount(), nil
+}
+
+// PagePath returns the layout path of page index (0 based) of a multi-page image
+func PagePath(path string, index int) string {
+	return fmt.Sprintf("%s/page-%04d.png", path, index+1)
+}
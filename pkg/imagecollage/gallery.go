@@ -0,0 +1,191 @@
+package imagecollage
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/je4/PictureFS/v2/pkg/PictureFS"
+	"github.com/pkg/errors"
+	"golang.org/x/image/draw"
+	"image"
+	"io/fs"
+	"math"
+	"path/filepath"
+	"sort"
+)
+
+// galleryCollage contains the images and the rendering of the collages for human viewers
+// (grid, justified rows and masonry). The images are scaled to the size of their rect. Like the
+// packed collages, the rects and the size of Layout do not include the margin
+type galleryCollage struct {
+	fsys fs.FS
+	// space between images and empty margin around the collage
+	space, margin int64
+	// natural sizes of the images
+	images        []Rect
+	names         map[string]bool
+	sources       []Source
+	rasterization Rasterization
+	order         Order
+	colorSpace    *ICCProfile
+}
+
+func newGalleryCollage(fsys fs.FS, space, margin int64) galleryCollage {
+	return galleryCollage{
+		fsys:    fsys,
+		space:   space,
+		margin:  margin,
+		images:  []Rect{},
+		names:   map[string]bool{},
+		sources: DefaultSources(),
+	}
+}
+
+// AddSource adds a source for input files, which cannot be decoded with image.Decode.
+// Sources added later take precedence
+func (gc *galleryCollage) AddSource(source Source) {
+	gc.sources = append([]Source{source}, gc.sources...)
+}
+
+// SetColorSpace enables color management. Source images are converted from their embedded icc
+// profile (sRGB if there is none) to the working space profile, which is embedded into the atlas.
+// nil disables color management
+func (gc *galleryCollage) SetColorSpace(profile *ICCProfile) {
+	gc.colorSpace = profile
+}
+
+// ColorSpace returns the working space profile or nil
+func (gc *galleryCollage) ColorSpace() *ICCProfile {
+	return gc.colorSpace
+}
+
+// SetRasterization defines the size of rasterized vector sources
+func (gc *galleryCollage) SetRasterization(r Rasterization) {
+	gc.rasterization = r
+}
+
+func (gc *galleryCollage) AddRect(name string, width, height int64) error {
+	if gc.names[name] {
+		return errors.New(fmt.Sprintf("rectangle with name %s already added", name))
+	}
+	if width <= 0 || height <= 0 {
+		return errors.New(fmt.Sprintf("rectangle %s has no size", name))
+	}
+	gc.names[name] = true
+	gc.images = append(gc.images, Rect{Name: name, Width: width, Height: height})
+	return nil
+}
+
+func (gc *galleryCollage) AddImageFile(path string) error {
+	path = filepath.ToSlash(filepath.Clean(path))
+	var fullpath = fsName(path)
+	for _, source := range gc.sources {
+		if source.Match(fullpath) {
+			img, err := source.Load(gc.fsys, fullpath, gc.rasterization)
+			if err != nil {
+				return errors.Wrapf(err, "cannot open image %s", fullpath)
+			}
+			return gc.AddRect(path, int64(img.Bounds().Dx()), int64(img.Bounds().Dy()))
+		}
+	}
+	f, err := gc.fsys.Open(fullpath)
+	if err != nil {
+		return errors.Wrapf(err, "cannot open image %s", fullpath)
+	}
+	defer f.Close()
+	config, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return errors.Wrapf(err, "cannot decode image %s", fullpath)
+	}
+	return gc.AddRect(path, int64(config.Width), int64(config.Height))
+}
+
+// sortedImages returns the images in the order of placement
+func (gc *galleryCollage) sortedImages() []Rect {
+	var result = make([]Rect, len(gc.images))
+	copy(result, gc.images)
+	sort.SliceStable(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	gc.order.sortRects(gc.fsys, result, func(name string) string { return name })
+	return result
+}
+
+// loadImage decodes or rasterizes the image name and converts it to the working space
+func (gc *galleryCollage) loadImage(name string) (image.Image, error) {
+	var fullpath = fsName(name)
+	for _, source := range gc.sources {
+		if source.Match(fullpath) {
+			img, err := source.Load(gc.fsys, fullpath, gc.rasterization)
+			if err != nil {
+				return nil, err
+			}
+			return ConvertFileColors(gc.fsys, img, fullpath, gc.colorSpace)
+		}
+	}
+	img, err := getImageFromFilePath(gc.fsys, fullpath)
+	if err != nil {
+		return nil, err
+	}
+	return ConvertFileColors(gc.fsys, img, fullpath, gc.colorSpace)
+}
+
+// fitRect returns the rect of size w x h scaled to fit into width x height and centered within it
+func fitRect(w, h, x, y, width, height int64) Rect {
+	var scale = math.Min(float64(width)/float64(w), float64(height)/float64(h))
+	var sw = int64(math.Max(1, math.Round(float64(w)*scale)))
+	var sh = int64(math.Max(1, math.Round(float64(h)*scale)))
+	return Rect{X: x + (width-sw)/2, Y: y + (height-sh)/2, Width: sw, Height: sh}
+}
+
+// ImageSize returns the size of the collage image of layout including the margin
+func (gc *galleryCollage) ImageSize(layout Layout) image.Rectangle {
+	return image.Rect(0, 0, int(layout.Width+2*gc.margin), int(layout.Height+2*gc.margin))
+}
+
+func (gc *galleryCollage) CreateImage(layout Layout) (image.Image, error) {
+	collImg := image.NewNRGBA(gc.ImageSize(layout))
+	for _, rect := range layout.Rects {
+		src, err := gc.loadImage(rect.Name)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot open image %s", rect.Name)
+		}
+		var dst = image.Rect(int(rect.X), int(rect.Y), int(rect.X+rect.Width), int(rect.Y+rect.Height)).
+			Add(image.Point{X: int(gc.margin), Y: int(gc.margin)})
+		if dst.Size() == src.Bounds().Size() {
+			PictureFS.CopyImage(collImg, dst.Min, src, src.Bounds())
+			continue
+		}
+		draw.CatmullRom.Scale(collImg, dst, src, src.Bounds(), draw.Src, nil)
+	}
+	return collImg, nil
+}
+
+func (gc *galleryCollage) CreateLayout(layout Layout) (*PictureFS.Layout, error) {
+	var result = &PictureFS.Layout{
+		Version: PictureFS.VERSION,
+		Images:  []PictureFS.Rect{},
+	}
+	for _, rect := range layout.Rects {
+		result.Images = append(result.Images, PictureFS.Rect{
+			Path:   rect.Name,
+			X:      int(rect.X + gc.margin),
+			Y:      int(rect.Y + gc.margin),
+			Width:  int(rect.Width),
+			Height: int(rect.Height),
+		})
+	}
+	if gc.colorSpace != nil {
+		result.ICCProfile = gc.colorSpace.Data
+	}
+	return result, nil
+}
+
+func (gc *galleryCollage) CreateJSON(layout Layout) ([]byte, error) {
+	result, err := gc.CreateLayout(layout)
+	if err != nil {
+		return nil, err
+	}
+	jsonBytes, err := json.Marshal(result)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot marshal json of %v", result)
+	}
+	return jsonBytes, nil
+}
@@ -0,0 +1,56 @@
+package imagecollage
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"io/fs"
+	"math"
+)
+
+// GridCollage places the images in cells of the same size. The images are scaled to fit into
+// their cell and centered
+type GridCollage struct {
+	galleryCollage
+	cellWidth, cellHeight int64
+	columns               int64
+}
+
+// NewGridCollage creates a grid of cellWidth x cellHeight cells with columns columns
+// (0: as many columns as rows) of the images in fsys
+func NewGridCollage(fsys fs.FS, cellWidth, cellHeight, columns, space, margin int64) *GridCollage {
+	return &GridCollage{
+		galleryCollage: newGalleryCollage(fsys, space, margin),
+		cellWidth:      cellWidth,
+		cellHeight:     cellHeight,
+		columns:        columns,
+	}
+}
+
+func (gc *GridCollage) Pack() (Layout, error) {
+	if gc.cellWidth <= 0 || gc.cellHeight <= 0 {
+		return Layout{}, errors.New(fmt.Sprintf("invalid cell size %vx%v", gc.cellWidth, gc.cellHeight))
+	}
+	var images = gc.sortedImages()
+	var layout = Layout{Rects: []Rect{}}
+	if len(images) == 0 {
+		return layout, nil
+	}
+	var columns = gc.columns
+	if columns <= 0 {
+		columns = int64(math.Ceil(math.Sqrt(float64(len(images)))))
+	}
+	var rows = (int64(len(images)) + columns - 1) / columns
+	if int64(len(images)) < columns {
+		columns = int64(len(images))
+	}
+	for i, img := range images {
+		var x = int64(i) % columns * (gc.cellWidth + gc.space)
+		var y = int64(i) / columns * (gc.cellHeight + gc.space)
+		var rect = fitRect(img.Width, img.Height, x, y, gc.cellWidth, gc.cellHeight)
+		rect.Name = img.Name
+		layout.Rects = append(layout.Rects, rect)
+	}
+	layout.Width = columns*gc.cellWidth + (columns-1)*gc.space
+	layout.Height = rows*gc.cellHeight + (rows-1)*gc.space
+	return layout, nil
+}
@@ -0,0 +1,74 @@
+package imagecollage
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"golang.org/x/image/draw"
+	"image"
+	"image/color"
+	"math/bits"
+)
+
+type DedupMode int
+
+const (
+	// DedupNone packs every image
+	DedupNone DedupMode = iota
+	// DedupExact packs images with identical pixels only once
+	DedupExact
+	// DedupPerceptual packs images of same size with similar perceptual hash only once
+	DedupPerceptual
+)
+
+// ContentHash returns a hash of size and pixel content of region r of img
+func ContentHash(img image.Image, r image.Rectangle) string {
+	var sha = sha256.New()
+	var buf = make([]byte, 8)
+	binary.BigEndian.PutUint32(buf[0:], uint32(r.Dx()))
+	binary.BigEndian.PutUint32(buf[4:], uint32(r.Dy()))
+	sha.Write(buf)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			c := color.NRGBA64Model.Convert(img.At(x, y)).(color.NRGBA64)
+			binary.BigEndian.PutUint16(buf[0:], c.R)
+			binary.BigEndian.PutUint16(buf[2:], c.G)
+			binary.BigEndian.PutUint16(buf[4:], c.B)
+			binary.BigEndian.PutUint16(buf[6:], c.A)
+			sha.Write(buf)
+		}
+	}
+	return hex.EncodeToString(sha.Sum(nil))
+}
+
+// trimKey identifies the trim of an image. Duplicates need the same trim
+func trimKey(trim Trim) string {
+	return fmt.Sprintf("%v/%v/%v/%v", trim.X, trim.Y, trim.SourceWidth, trim.SourceHeight)
+}
+
+// dedupHash returns the key of exact duplicates: the trim and the content hash of region r of img
+func dedupHash(img image.Image, r image.Rectangle, trim Trim) string {
+	return trimKey(trim) + "/" + ContentHash(img, r)
+}
+
+// PerceptualHash returns the difference hash (dHash) of region r of img
+func PerceptualHash(img image.Image, r image.Rectangle) uint64 {
+	var small = image.NewGray(image.Rect(0, 0, 9, 8))
+	draw.ApproxBiLinear.Scale(small, small.Bounds(), img, r, draw.Src, nil)
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			hash <<= 1
+			if small.GrayAt(x, y).Y < small.GrayAt(x+1, y).Y {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// HammingDistance returns the number of different bits of a and b
+func HammingDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
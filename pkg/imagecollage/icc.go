@@ -0,0 +1,546 @@
+package imagecollage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/je4/PictureFS/v2/pkg/PictureFS"
+	"github.com/pkg/errors"
+	"golang.org/x/image/draw"
+	"image"
+	"image/color"
+	"io/fs"
+	"math"
+	"sync"
+)
+
+// ICCProfile is a matrix/trc based rgb icc profile (like sRGB, Display P3 or Adobe RGB)
+type ICCProfile struct {
+	// Data is the binary profile
+	Data []byte
+	// Name is the profile description
+	Name string
+	// matrix converts linear rgb to XYZ (D50)
+	matrix [3][3]float64
+	trc    [3]iccCurve
+}
+
+// iccCurve is a tone reproduction curve of type curv or para
+type iccCurve struct {
+	table  []float64
+	gamma  float64
+	fn     int
+	params []float64
+}
+
+// eval converts the encoded value x (0..1) to linear light
+func (c iccCurve) eval(x float64) float64 {
+	if c.table != nil {
+		if len(c.table) == 1 {
+			return c.table[0]
+		}
+		var pos = x * float64(len(c.table)-1)
+		var i = int(pos)
+		if i >= len(c.table)-1 {
+			return c.table[len(c.table)-1]
+		}
+		return c.table[i] + (c.table[i+1]-c.table[i])*(pos-float64(i))
+	}
+	if c.params == nil {
+		return math.Pow(x, c.gamma)
+	}
+	var p = c.params
+	var pow = func(v float64) float64 {
+		if v <= 0 {
+			return 0
+		}
+		return math.Pow(v, p[0])
+	}
+	switch c.fn {
+	case 1:
+		if x >= -p[2]/p[1] {
+			return pow(p[1]*x + p[2])
+		}
+		return 0
+	case 2:
+		if x >= -p[2]/p[1] {
+			return pow(p[1]*x+p[2]) + p[3]
+		}
+		return p[3]
+	case 3:
+		if x >= p[4] {
+			return pow(p[1]*x + p[2])
+		}
+		return p[3] * x
+	case 4:
+		if x >= p[4] {
+			return pow(p[1]*x+p[2]) + p[5]
+		}
+		return p[3]*x + p[6]
+	default:
+		return pow(x)
+	}
+}
+
+func s15Fixed16(b []byte) float64 {
+	return float64(int32(binary.BigEndian.Uint32(b))) / 65536
+}
+
+// ParseICCProfile reads a matrix/trc rgb profile. Lut based profiles are not supported
+func ParseICCProfile(data []byte) (*ICCProfile, error) {
+	if len(data) < 132 || string(data[36:40]) != "acsp" {
+		return nil, errors.New("invalid icc profile")
+	}
+	if string(data[16:20]) != "RGB " || string(data[20:24]) != "XYZ " {
+		return nil, errors.New(fmt.Sprintf("unsupported icc profile color space %s/%s", data[16:20], data[20:24]))
+	}
+	var tags = map[string][]byte{}
+	var count = int(binary.BigEndian.Uint32(data[128:132]))
+	if count < 0 || 132+12*count > len(data) {
+		return nil, errors.New("invalid icc tag table")
+	}
+	for i := 0; i < count; i++ {
+		var entry = data[132+12*i:]
+		var offset, size = int(binary.BigEndian.Uint32(entry[4:8])), int(binary.BigEndian.Uint32(entry[8:12]))
+		if offset < 0 || size < 0 || offset+size > len(data) {
+			return nil, errors.New(fmt.Sprintf("invalid icc tag %s", entry[0:4]))
+		}
+		tags[string(entry[0:4])] = data[offset : offset+size]
+	}
+	var profile = &ICCProfile{Data: data}
+	if desc, ok := tags["desc"]; ok && len(desc) > 12 && string(desc[0:4]) == "desc" {
+		var n = int(binary.BigEndian.Uint32(desc[8:12]))
+		if n > 0 && 12+n <= len(desc) {
+			profile.Name = string(bytes.TrimRight(desc[12:12+n], "\x00"))
+		}
+	}
+	for i, name := range []string{"rXYZ", "gXYZ", "bXYZ"} {
+		var tag = tags[name]
+		if len(tag) < 20 || string(tag[0:4]) != "XYZ " {
+			return nil, errors.New(fmt.Sprintf("icc profile without %s tag", name))
+		}
+		for j := 0; j < 3; j++ {
+			profile.matrix[j][i] = s15Fixed16(tag[8+4*j:])
+		}
+	}
+	for i, name := range []string{"rTRC", "gTRC", "bTRC"} {
+		var tag = tags[name]
+		if len(tag) < 12 {
+			return nil, errors.New(fmt.Sprintf("icc profile without %s tag", name))
+		}
+		switch string(tag[0:4]) {
+		case "curv":
+			var n = int(binary.BigEndian.Uint32(tag[8:12]))
+			if len(tag) < 12+2*n {
+				return nil, errors.New(fmt.Sprintf("invalid icc curve %s", name))
+			}
+			switch n {
+			case 0:
+				profile.trc[i] = iccCurve{gamma: 1}
+			case 1:
+				profile.trc[i] = iccCurve{gamma: float64(binary.BigEndian.Uint16(tag[12:14])) / 256}
+			default:
+				var table = make([]float64, n)
+				for j := range table {
+					table[j] = float64(binary.BigEndian.Uint16(tag[12+2*j:])) / 65535
+				}
+				profile.trc[i] = iccCurve{table: table}
+			}
+		case "para":
+			var fn = int(binary.BigEndian.Uint16(tag[8:10]))
+			var n = []int{1, 3, 4, 5, 7}
+			if fn >= len(n) || len(tag) < 12+4*n[fn] {
+				return nil, errors.New(fmt.Sprintf("invalid icc parametric curve %s", name))
+			}
+			var params = make([]float64, n[fn])
+			for j := range params {
+				params[j] = s15Fixed16(tag[12+4*j:])
+			}
+			profile.trc[i] = iccCurve{fn: fn, params: params}
+		default:
+			return nil, errors.New(fmt.Sprintf("unsupported icc curve type %s", tag[0:4]))
+		}
+	}
+	return profile, nil
+}
+
+// rgbToXYZD50 returns the matrix of the primaries and the D65 white point adapted to D50 (bradford)
+func rgbToXYZD50(rx, ry, gx, gy, bx, by float64) [3][3]float64 {
+	var xyz = func(x, y float64) [3]float64 { return [3]float64{x / y, 1, (1 - x - y) / y} }
+	var r, g, b, w = xyz(rx, ry), xyz(gx, gy), xyz(bx, by), xyz(0.3127, 0.3290)
+	var p = [3][3]float64{{r[0], g[0], b[0]}, {r[1], g[1], b[1]}, {r[2], g[2], b[2]}}
+	var s = mulVec(invert(p), w)
+	var m [3][3]float64
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			m[i][j] = p[i][j] * s[j]
+		}
+	}
+	var bradford = [3][3]float64{
+		{0.8951, 0.2664, -0.1614},
+		{-0.7502, 1.7135, 0.0367},
+		{0.0389, -0.0685, 1.0296},
+	}
+	var d50 = [3]float64{0.9642, 1, 0.8249}
+	var src, dst = mulVec(bradford, w), mulVec(bradford, d50)
+	var scale = [3][3]float64{{dst[0] / src[0]}, {0, dst[1] / src[1]}, {0, 0, dst[2] / src[2]}}
+	return mul(mul(invert(bradford), mul(scale, bradford)), m)
+}
+
+func mul(a, b [3][3]float64) [3][3]float64 {
+	var result [3][3]float64
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			for k := 0; k < 3; k++ {
+				result[i][j] += a[i][k] * b[k][j]
+			}
+		}
+	}
+	return result
+}
+
+func mulVec(a [3][3]float64, v [3]float64) [3]float64 {
+	var result [3]float64
+	for i := 0; i < 3; i++ {
+		for k := 0; k < 3; k++ {
+			result[i] += a[i][k] * v[k]
+		}
+	}
+	return result
+}
+
+func invert(m [3][3]float64) [3][3]float64 {
+	var det = m[0][0]*(m[1][1]*m[2][2]-m[1][2]*m[2][1]) -
+		m[0][1]*(m[1][0]*m[2][2]-m[1][2]*m[2][0]) +
+		m[0][2]*(m[1][0]*m[2][1]-m[1][1]*m[2][0])
+	var result [3][3]float64
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			var a, b = (j + 1) % 3, (j + 2) % 3
+			var c, d = (i + 1) % 3, (i + 2) % 3
+			result[i][j] = (m[a][c]*m[b][d] - m[a][d]*m[b][c]) / det
+		}
+	}
+	return result
+}
+
+// createICCProfile writes a version 2 display profile with the primaries and tone curve
+func createICCProfile(name string, matrix [3][3]float64, trc []byte) []byte {
+	var fixed = func(v float64) []byte {
+		var b = make([]byte, 4)
+		binary.BigEndian.PutUint32(b, uint32(int32(math.Round(v*65536))))
+		return b
+	}
+	var xyzTag = func(x, y, z float64) []byte {
+		var b = append([]byte("XYZ \x00\x00\x00\x00"), fixed(x)...)
+		b = append(b, fixed(y)...)
+		return append(b, fixed(z)...)
+	}
+	var desc = []byte("desc\x00\x00\x00\x00")
+	desc = appendUint32(desc, uint32(len(name)+1))
+	desc = append(desc, name...)
+	// null, unicode language and count, scriptcode code, count and 67 bytes
+	desc = append(desc, make([]byte, 1+4+4+2+1+67)...)
+	var tags = []struct {
+		sig  string
+		data []byte
+	}{
+		{"desc", desc},
+		{"cprt", append([]byte("text\x00\x00\x00\x00"), "No copyright, use freely\x00"...)},
+		{"wtpt", xyzTag(0.9642, 1, 0.8249)},
+		{"rXYZ", xyzTag(matrix[0][0], matrix[1][0], matrix[2][0])},
+		{"gXYZ", xyzTag(matrix[0][1], matrix[1][1], matrix[2][1])},
+		{"bXYZ", xyzTag(matrix[0][2], matrix[1][2], matrix[2][2])},
+		{"rTRC", trc},
+		{"gTRC", trc},
+		{"bTRC", trc},
+	}
+	var header = make([]byte, 128)
+	binary.BigEndian.PutUint32(header[8:], 0x02100000)
+	copy(header[12:], "mntrRGB XYZ ")
+	// creation date 2022-01-01
+	binary.BigEndian.PutUint16(header[24:], 2022)
+	binary.BigEndian.PutUint16(header[26:], 1)
+	binary.BigEndian.PutUint16(header[28:], 1)
+	copy(header[36:], "acsp")
+	copy(header[68:], xyzTag(0.9642, 1, 0.8249)[8:])
+
+	var table = appendUint32(nil, uint32(len(tags)))
+	var data = []byte{}
+	var offsets = map[string]int{}
+	var offset = 128 + 4 + 12*len(tags)
+	for _, tag := range tags {
+		// tags with same data share the offset
+		var pos, ok = offsets[string(tag.data)]
+		if !ok {
+			pos = offset + len(data)
+			offsets[string(tag.data)] = pos
+			data = append(data, tag.data...)
+			for len(data)%4 != 0 {
+				data = append(data, 0)
+			}
+		}
+		table = append(table, tag.sig...)
+		table = appendUint32(table, uint32(pos))
+		table = appendUint32(table, uint32(len(tag.data)))
+	}
+	var profile = append(append(header, table...), data...)
+	binary.BigEndian.PutUint32(profile[0:], uint32(len(profile)))
+	return profile
+}
+
+// srgbCurve is the tone curve of sRGB and Display P3 as table
+func srgbCurve() []byte {
+	var curv = appendUint32([]byte("curv\x00\x00\x00\x00"), 1024)
+	for i := 0; i < 1024; i++ {
+		var x = float64(i) / 1023
+		var y = x / 12.92
+		if x > 0.04045 {
+			y = math.Pow((x+0.055)/1.055, 2.4)
+		}
+		curv = appendUint16(curv, uint16(math.Round(y*65535)))
+	}
+	return curv
+}
+
+func gammaCurve(gamma float64) []byte {
+	var curv = appendUint32([]byte("curv\x00\x00\x00\x00"), 1)
+	return appendUint16(curv, uint16(math.Round(gamma*256)))
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+func mustParse(data []byte) *ICCProfile {
+	profile, err := ParseICCProfile(data)
+	if err != nil {
+		panic(err)
+	}
+	return profile
+}
+
+var (
+	srgbOnce      sync.Once
+	srgbProfile   *ICCProfile
+	p3Once        sync.Once
+	p3Profile     *ICCProfile
+	adobeOnce     sync.Once
+	adobeProfile  *ICCProfile
+	transformLock sync.Mutex
+	transforms    = map[string]*colorTransform{}
+)
+
+// SRGBProfile returns the sRGB profile
+func SRGBProfile() *ICCProfile {
+	srgbOnce.Do(func() {
+		srgbProfile = mustParse(createICCProfile("sRGB", rgbToXYZD50(0.64, 0.33, 0.30, 0.60, 0.15, 0.06), srgbCurve()))
+	})
+	return srgbProfile
+}
+
+// DisplayP3Profile returns the Display P3 profile
+func DisplayP3Profile() *ICCProfile {
+	p3Once.Do(func() {
+		p3Profile = mustParse(createICCProfile("Display P3", rgbToXYZD50(0.680, 0.320, 0.265, 0.690, 0.150, 0.060), srgbCurve()))
+	})
+	return p3Profile
+}
+
+// AdobeRGBProfile returns the Adobe RGB (1998) compatible profile
+func AdobeRGBProfile() *ICCProfile {
+	adobeOnce.Do(func() {
+		adobeProfile = mustParse(createICCProfile("Adobe RGB (1998)", rgbToXYZD50(0.64, 0.33, 0.21, 0.71, 0.15, 0.06), gammaCurve(563.0/256)))
+	})
+	return adobeProfile
+}
+
+// ParseColorSpace returns the working space profile with name srgb, p3 or adobergb. none returns nil
+func ParseColorSpace(name string) (*ICCProfile, error) {
+	switch name {
+	case "none", "":
+		return nil, nil
+	case "srgb":
+		return SRGBProfile(), nil
+	case "p3":
+		return DisplayP3Profile(), nil
+	case "adobergb":
+		return AdobeRGBProfile(), nil
+	default:
+		return nil, errors.New(fmt.Sprintf("invalid color space %s", name))
+	}
+}
+
+// number of steps of the inverse tone curves
+const linearSteps = 1 << 16
+
+// colorTransform converts 8 bit values between two profiles
+type colorTransform struct {
+	identity bool
+	src      *ICCProfile
+	toLinear [3][256]float64
+	// toLinear16 is created for the first 16 bit image
+	toLinear16 [3][]float64
+	once16     sync.Once
+	matrix     [3][3]float64
+	// fromLinear maps linear light in linearSteps steps to the encoded 16 bit value
+	fromLinear [3][linearSteps]uint16
+}
+
+func newColorTransform(src, dst *ICCProfile) *colorTransform {
+	var t = &colorTransform{
+		src:    src,
+		matrix: mul(invert(dst.matrix), src.matrix),
+	}
+	var identity = true
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			var expected float64
+			if i == j {
+				expected = 1
+			}
+			if math.Abs(t.matrix[i][j]-expected) > 1e-3 {
+				identity = false
+			}
+		}
+	}
+	for ch := 0; ch < 3; ch++ {
+		for v := 0; v < 256; v++ {
+			t.toLinear[ch][v] = src.trc[ch].eval(float64(v) / 255)
+			if math.Abs(t.toLinear[ch][v]-dst.trc[ch].eval(float64(v)/255)) > 1e-3 {
+				identity = false
+			}
+		}
+		for i := 0; i < linearSteps; i++ {
+			// invert the monotonic curve by bisection
+			var y = float64(i) / (linearSteps - 1)
+			var lo, hi = 0.0, 1.0
+			for n := 0; n < 24; n++ {
+				var mid = (lo + hi) / 2
+				if dst.trc[ch].eval(mid) < y {
+					lo = mid
+				} else {
+					hi = mid
+				}
+			}
+			t.fromLinear[ch][i] = uint16(math.Round((lo + hi) / 2 * 0xffff))
+		}
+	}
+	t.identity = identity
+	return t
+}
+
+// transform returns the cached transformation from src to dst
+func transform(src, dst *ICCProfile) *colorTransform {
+	var key = string(src.Data) + "\x00" + string(dst.Data)
+	transformLock.Lock()
+	defer transformLock.Unlock()
+	if t, ok := transforms[key]; ok {
+		return t
+	}
+	var t = newColorTransform(src, dst)
+	transforms[key] = t
+	return t
+}
+
+// ConvertColors converts img from profile src to profile dst. Identical color spaces return img
+func ConvertColors(img image.Image, src, dst *ICCProfile) image.Image {
+	var t = transform(src, dst)
+	if t.identity {
+		return img
+	}
+	var b = img.Bounds()
+	switch img.ColorModel() {
+	case color.NRGBA64Model, color.RGBA64Model, color.Gray16Model:
+		return t.convert16(img)
+	}
+	var result = image.NewNRGBA(b)
+	draw.Copy(result, b.Min, img, b, draw.Src, nil)
+	for i := 0; i < len(result.Pix); i += 4 {
+		var lin = [3]float64{
+			t.toLinear[0][result.Pix[i]],
+			t.toLinear[1][result.Pix[i+1]],
+			t.toLinear[2][result.Pix[i+2]],
+		}
+		var out = mulVec(t.matrix, lin)
+		for ch := 0; ch < 3; ch++ {
+			var v = math.Max(0, math.Min(1, out[ch]))
+			result.Pix[i+ch] = uint8((uint32(t.fromLinear[ch][int(v*(linearSteps-1)+0.5)]) + 128) / 257)
+		}
+	}
+	return result
+}
+
+// convert16 converts 16 bit images without losing the depth
+func (t *colorTransform) convert16(img image.Image) image.Image {
+	t.once16.Do(func() {
+		for ch := 0; ch < 3; ch++ {
+			t.toLinear16[ch] = make([]float64, 0x10000)
+			for v := range t.toLinear16[ch] {
+				t.toLinear16[ch][v] = t.src.trc[ch].eval(float64(v) / 0xffff)
+			}
+		}
+	})
+	var b = img.Bounds()
+	var result = image.NewNRGBA64(b)
+	draw.Copy(result, b.Min, img, b, draw.Src, nil)
+	for i := 0; i < len(result.Pix); i += 8 {
+		var lin [3]float64
+		for ch := 0; ch < 3; ch++ {
+			lin[ch] = t.toLinear16[ch][int(result.Pix[i+2*ch])<<8|int(result.Pix[i+2*ch+1])]
+		}
+		var out = mulVec(t.matrix, lin)
+		for ch := 0; ch < 3; ch++ {
+			var v = t.fromLinear[ch][int(math.Max(0, math.Min(1, out[ch]))*(linearSteps-1)+0.5)]
+			result.Pix[i+2*ch], result.Pix[i+2*ch+1] = uint8(v>>8), uint8(v)
+		}
+	}
+	return result
+}
+
+// SetColorSpace enables color management. Source images are converted from their embedded icc
+// profile (sRGB if there is none) to the working space profile, which is embedded into the atlas.
+// nil disables color management
+func (sc *SemibranCollage) SetColorSpace(profile *ICCProfile) {
+	sc.colorSpace = profile
+}
+
+// ColorSpace returns the working space profile or nil
+func (sc *SemibranCollage) ColorSpace() *ICCProfile {
+	return sc.colorSpace
+}
+
+// convertColors converts the image of file filePath of the collage fs into the working space
+func (sc *SemibranCollage) convertColors(img image.Image, filePath string) (image.Image, error) {
+	return ConvertFileColors(sc.fsys, img, filePath, sc.colorSpace)
+}
+
+// ConvertFileColors converts the image of file filePath of fsys from its embedded profile into
+// the working space profile (nil: no conversion)
+func ConvertFileColors(fsys fs.FS, img image.Image, filePath string, profile *ICCProfile) (image.Image, error) {
+	if profile == nil {
+		return img, nil
+	}
+	switch img.(type) {
+	case *image.Gray, *image.Gray16:
+		return img, nil
+	}
+	f, err := fsys.Open(filePath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot open %s", filePath)
+	}
+	defer f.Close()
+	var src = SRGBProfile()
+	data, err := PictureFS.ExtractICCProfile(f)
+	if err == nil && data != nil {
+		// unsupported profiles are treated as sRGB
+		if profile, err := ParseICCProfile(data); err == nil {
+			src = profile
+		}
+	}
+	return ConvertColors(img, src, profile), nil
+}
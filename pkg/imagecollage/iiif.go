@@ -0,0 +1,191 @@
+package imagecollage
+
+import (
+	"fmt"
+	"github.com/je4/PictureFS/v2/pkg/PictureFS"
+	"net/url"
+	"path"
+	"strings"
+)
+
+const IIIF_PRESENTATION_CONTEXT = "http://iiif.io/api/presentation/3/context.json"
+
+type IIIFTextualBody struct {
+	Type     string `json:"type"`
+	Value    string `json:"value"`
+	Format   string `json:"format,omitempty"`
+	Language string `json:"language,omitempty"`
+}
+
+type IIIFAnnotation struct {
+	ID         string      `json:"id"`
+	Type       string      `json:"type"`
+	Motivation string      `json:"motivation"`
+	Body       interface{} `json:"body"`
+	Target     string      `json:"target"`
+}
+
+type IIIFAnnotationPage struct {
+	Context string           `json:"@context,omitempty"`
+	ID      string           `json:"id"`
+	Type    string           `json:"type"`
+	Items   []IIIFAnnotation `json:"items"`
+}
+
+// CreateRegionAnnotations creates an annotation for every image of layout, which links the region
+// of target (canvas or image uri) to the path of the image
+func CreateRegionAnnotations(layout *PictureFS.Layout, id, target string) *IIIFAnnotationPage {
+	var page = &IIIFAnnotationPage{
+		Context: IIIF_PRESENTATION_CONTEXT,
+		ID:      id,
+		Type:    "AnnotationPage",
+		Items:   []IIIFAnnotation{},
+	}
+	for i, rect := range layout.Images {
+		page.Items = append(page.Items, IIIFAnnotation{
+			ID:         fmt.Sprintf("%s/%d", strings.TrimRight(id, "/"), i),
+			Type:       "Annotation",
+			Motivation: "tagging",
+			Body: IIIFTextualBody{
+				Type:   "TextualBody",
+				Value:  rect.Path,
+				Format: "text/plain",
+			},
+			Target: fmt.Sprintf("%s#xywh=%d,%d,%d,%d", target, rect.X, rect.Y, rect.Width, rect.Height),
+		})
+	}
+	return page
+}
+
+// IIIFLabel is a language map of IIIF Presentation 3
+type IIIFLabel map[string][]string
+
+type IIIFMetadata struct {
+	Label IIIFLabel `json:"label"`
+	Value IIIFLabel `json:"value"`
+}
+
+type IIIFService struct {
+	ID      string `json:"id"`
+	Type    string `json:"type"`
+	Profile string `json:"profile"`
+}
+
+type IIIFImage struct {
+	ID      string        `json:"id"`
+	Type    string        `json:"type"`
+	Format  string        `json:"format"`
+	Width   int           `json:"width"`
+	Height  int           `json:"height"`
+	Service []IIIFService `json:"service,omitempty"`
+}
+
+type IIIFCanvas struct {
+	ID          string               `json:"id"`
+	Type        string               `json:"type"`
+	Label       IIIFLabel            `json:"label,omitempty"`
+	Metadata    []IIIFMetadata       `json:"metadata,omitempty"`
+	Width       int                  `json:"width"`
+	Height      int                  `json:"height"`
+	Items       []IIIFAnnotationPage `json:"items"`
+	Annotations []IIIFAnnotationPage `json:"annotations,omitempty"`
+}
+
+// IIIFManifest is a IIIF Presentation 3 manifest
+type IIIFManifest struct {
+	Context string       `json:"@context"`
+	ID      string       `json:"id"`
+	Type    string       `json:"type"`
+	Label   IIIFLabel    `json:"label"`
+	Items   []IIIFCanvas `json:"items"`
+}
+
+func label(value string) IIIFLabel {
+	return IIIFLabel{"none": []string{value}}
+}
+
+// paintingPage creates the annotation page, which paints the image of service on the canvas
+func paintingPage(canvasID, service string, width, height int) IIIFAnnotationPage {
+	return IIIFAnnotationPage{
+		ID:   canvasID + "/page",
+		Type: "AnnotationPage",
+		Items: []IIIFAnnotation{
+			{
+				ID:         canvasID + "/page/image",
+				Type:       "Annotation",
+				Motivation: "painting",
+				Body: IIIFImage{
+					ID:     service + "/full/max/0/default.jpg",
+					Type:   "Image",
+					Format: "image/jpeg",
+					Width:  width,
+					Height: height,
+					Service: []IIIFService{
+						{ID: service, Type: "ImageService3", Profile: "level2"},
+					},
+				},
+				Target: canvasID,
+			},
+		},
+	}
+}
+
+// IIIFImageID returns the id of an image of the layout within the image service of the collage
+func IIIFImageID(imageService, imagePath string) string {
+	return strings.TrimRight(imageService, "/") + "/" + url.PathEscape(strings.TrimPrefix(imagePath, "/"))
+}
+
+// CreateIIIFManifest creates a IIIF Presentation 3 manifest for the collage image with the given size.
+// imageService is the base uri of the image service of the collage (e.g. PictureFS.IIIFHandler).
+// If perImage is set, every image of layout gets its own canvas, otherwise the collage is one canvas
+// with region annotations
+func CreateIIIFManifest(layout *PictureFS.Layout, id, title, imageService string, width, height int, perImage bool) *IIIFManifest {
+	id = strings.TrimRight(id, "/")
+	imageService = strings.TrimRight(imageService, "/")
+	var manifest = &IIIFManifest{
+		Context: IIIF_PRESENTATION_CONTEXT,
+		ID:      id + "/manifest.json",
+		Type:    "Manifest",
+		Label:   label(title),
+		Items:   []IIIFCanvas{},
+	}
+	if !perImage {
+		var canvasID = id + "/canvas/0"
+		var annotations = CreateRegionAnnotations(layout, canvasID+"/annotations", canvasID)
+		annotations.Context = ""
+		manifest.Items = append(manifest.Items, IIIFCanvas{
+			ID:          canvasID,
+			Type:        "Canvas",
+			Label:       label(title),
+			Width:       width,
+			Height:      height,
+			Items:       []IIIFAnnotationPage{paintingPage(canvasID, imageService, width, height)},
+			Annotations: []IIIFAnnotationPage{*annotations},
+		})
+		return manifest
+	}
+	for i, rect := range layout.Images {
+		var canvasID = fmt.Sprintf("%s/canvas/%d", id, i)
+		var w, h = rect.Width, rect.Height
+		if rect.Trimmed() {
+			w, h = rect.SourceWidth, rect.SourceHeight
+		}
+		var p = "/" + strings.TrimPrefix(rect.Path, "/")
+		var metadata = []IIIFMetadata{
+			{Label: label("path"), Value: label(p)},
+		}
+		if dir := path.Dir(p); dir != "/" {
+			metadata = append(metadata, IIIFMetadata{Label: label("folder"), Value: label(dir)})
+		}
+		manifest.Items = append(manifest.Items, IIIFCanvas{
+			ID:       canvasID,
+			Type:     "Canvas",
+			Label:    label(path.Base(p)),
+			Metadata: metadata,
+			Width:    w,
+			Height:   h,
+			Items:    []IIIFAnnotationPage{paintingPage(canvasID, IIIFImageID(imageService, rect.Path), w, h)},
+		})
+	}
+	return manifest
+}
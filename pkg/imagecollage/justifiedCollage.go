@@ -0,0 +1,77 @@
+package imagecollage
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"io/fs"
+	"math"
+)
+
+// JustifiedCollage places the images in rows of the same width like photo galleries.
+// Every row is scaled so that it fills the width, the last row keeps the target row height
+type JustifiedCollage struct {
+	galleryCollage
+	width, rowHeight int64
+}
+
+// NewJustifiedCollage creates rows of about rowHeight pixels height and width pixels width
+// (without margin) of the images in fsys
+func NewJustifiedCollage(fsys fs.FS, width, rowHeight, space, margin int64) *JustifiedCollage {
+	return &JustifiedCollage{
+		galleryCollage: newGalleryCollage(fsys, space, margin),
+		width:          width,
+		rowHeight:      rowHeight,
+	}
+}
+
+// placeRow adds the images of a row with height at y to layout. The widths are rounded,
+// so that the row ends exactly at width, if fill is true
+func (jc *JustifiedCollage) placeRow(layout *Layout, row []Rect, y, height int64, fill bool) {
+	var aspect = 0.0
+	for _, img := range row {
+		aspect += float64(img.Width) / float64(img.Height)
+	}
+	var available = float64(jc.width - int64(len(row)-1)*jc.space)
+	var sum = 0.0
+	var x int64
+	for i, img := range row {
+		sum += float64(img.Width) / float64(img.Height)
+		var right = int64(math.Round(float64(height) * sum))
+		if fill {
+			right = int64(math.Round(available * sum / aspect))
+		}
+		var w = max(1, right+int64(i)*jc.space-x)
+		layout.Rects = append(layout.Rects, Rect{Name: img.Name, X: x, Y: y, Width: w, Height: height})
+		x += w + jc.space
+	}
+}
+
+func (jc *JustifiedCollage) Pack() (Layout, error) {
+	if jc.width <= 0 || jc.rowHeight <= 0 {
+		return Layout{}, errors.New(fmt.Sprintf("invalid width %v or row height %v", jc.width, jc.rowHeight))
+	}
+	var layout = Layout{Rects: []Rect{}}
+	var y int64
+	var row = []Rect{}
+	var aspect = 0.0
+	for _, img := range jc.sortedImages() {
+		row = append(row, img)
+		aspect += float64(img.Width) / float64(img.Height)
+		var available = float64(jc.width - int64(len(row)-1)*jc.space)
+		if aspect*float64(jc.rowHeight) < available {
+			continue
+		}
+		// the row is full: scale it to the width
+		var height = int64(math.Max(1, math.Round(available/aspect)))
+		jc.placeRow(&layout, row, y, height, true)
+		y += height + jc.space
+		row, aspect = []Rect{}, 0
+	}
+	if len(row) > 0 {
+		jc.placeRow(&layout, row, y, jc.rowHeight, false)
+		y += jc.rowHeight + jc.space
+	}
+	layout.Width = jc.width
+	layout.Height = max(0, y-jc.space)
+	return layout, nil
+}
@@ -0,0 +1,290 @@
+package imagecollage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"image"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const MANIFEST_VERSION = "0.2"
+
+// ManifestEntry records a source image and its placement in the collage
+type ManifestEntry struct {
+	Path    string
+	Size    int64
+	ModTime time.Time
+	Hash    string
+	// hash of the pixel content for exact duplicate detection (see ManifestSettings.Dedup)
+	ContentHash   string `json:",omitempty"`
+	Width, Height int64
+	Trim          *Trim `json:",omitempty"`
+	Rect          Rect
+}
+
+// ManifestSettings are the collage parameters which influence the collage image and its layout.
+// Lists (pins, priorities, order list) are recorded as sorted, newline separated strings
+type ManifestSettings struct {
+	Border, Space, Margin int64
+	Align                 int64
+	Extrude, Padding      int64
+	Trim                  bool
+	TrimThreshold         uint8
+	ColorSpace            string `json:",omitempty"`
+	Depth                 int    `json:",omitempty"`
+	Canvas                CanvasOptions
+	ColorMode             string `json:",omitempty"`
+	Colors                int    `json:",omitempty"`
+	Dedup                 string `json:",omitempty"`
+	DedupDistance         int    `json:",omitempty"`
+	Pins                  string `json:",omitempty"`
+	Priorities            string `json:",omitempty"`
+	GroupDirs             bool   `json:",omitempty"`
+	Order                 string `json:",omitempty"`
+	OrderList             string `json:",omitempty"`
+	Reverse               bool   `json:",omitempty"`
+	UV                    string `json:",omitempty"`
+	UVHalfTexel           bool   `json:",omitempty"`
+	Rasterization         Rasterization
+}
+
+// Manifest is the persistent cache used for incremental collage builds
+type Manifest struct {
+	Version                   string
+	Settings                  ManifestSettings
+	LayoutWidth, LayoutHeight int64
+	Images                    []ManifestEntry
+	index                     map[string]int
+}
+
+func NewManifest(settings ManifestSettings) *Manifest {
+	return &Manifest{
+		Version:  MANIFEST_VERSION,
+		Settings: settings,
+		Images:   []ManifestEntry{},
+	}
+}
+
+func LoadManifest(filename string) (*Manifest, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot read manifest %s", filename)
+	}
+	m := &Manifest{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, errors.Wrapf(err, "cannot decode manifest %s", filename)
+	}
+	return m, nil
+}
+
+func (m *Manifest) Save(filename string) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return errors.Wrapf(err, "cannot marshal manifest %s", filename)
+	}
+	if err := os.WriteFile(filename, data, 0666); err != nil {
+		return errors.Wrapf(err, "cannot write manifest %s", filename)
+	}
+	return nil
+}
+
+// Add appends entry to the manifest
+func (m *Manifest) Add(entry ManifestEntry) {
+	m.Images = append(m.Images, entry)
+	if m.index != nil {
+		m.index[entry.Path] = len(m.Images) - 1
+	}
+}
+
+// Entry returns the entry with the given path or nil
+func (m *Manifest) Entry(path string) *ManifestEntry {
+	if len(m.index) != len(m.Images) {
+		m.index = make(map[string]int, len(m.Images))
+		for i, entry := range m.Images {
+			m.index[entry.Path] = i
+		}
+	}
+	i, ok := m.index[path]
+	if !ok {
+		return nil
+	}
+	return &m.Images[i]
+}
+
+// SameSettings checks whether the manifest was built with the given collage parameters
+func (m *Manifest) SameSettings(settings ManifestSettings) bool {
+	return m.Version == MANIFEST_VERSION && m.Settings == settings
+}
+
+// Equal checks whether both manifests describe the same source images
+func (m *Manifest) Equal(other *Manifest) bool {
+	if len(m.Images) != len(other.Images) {
+		return false
+	}
+	for _, entry := range m.Images {
+		o := other.Entry(entry.Path)
+		// the modification time is part of the order of the images
+		if o == nil || o.Hash != entry.Hash || o.Width != entry.Width || o.Height != entry.Height || !o.ModTime.Equal(entry.ModTime) {
+			return false
+		}
+	}
+	return true
+}
+
+// Layout returns the packed layout recorded in the manifest
+func (m *Manifest) Layout() Layout {
+	layout := Layout{
+		Width:  m.LayoutWidth,
+		Height: m.LayoutHeight,
+		Rects:  []Rect{},
+	}
+	for _, entry := range m.Images {
+		layout.Rects = append(layout.Rects, entry.Rect)
+	}
+	return layout
+}
+
+// SetLayout stores the placement of all images of layout
+func (m *Manifest) SetLayout(layout Layout) {
+	m.LayoutWidth = layout.Width
+	m.LayoutHeight = layout.Height
+	for _, rect := range layout.Rects {
+		if entry := m.Entry(rect.Name); entry != nil {
+			entry.Rect = rect
+		}
+	}
+}
+
+// NewManifestEntry creates the entry for the image path of fsys.
+// If the file size and modification time match prev, hash and dimensions are taken from prev
+// and the file is not read. If settings.Trim is set, the image is decoded to find the trim bounds.
+// With exact duplicate detection, the image is decoded for the content hash
+func NewManifestEntry(fsys fs.FS, path string, prev *ManifestEntry, settings ManifestSettings) (*ManifestEntry, error) {
+	path = filepath.ToSlash(filepath.Clean(path))
+	fullpath := fsName(path)
+	info, err := fs.Stat(fsys, fullpath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot stat %s", fullpath)
+	}
+	entry := &ManifestEntry{
+		Path:    path,
+		Size:    info.Size(),
+		ModTime: info.ModTime().UTC(),
+	}
+	if prev != nil && prev.Size == entry.Size && prev.ModTime.Equal(entry.ModTime) {
+		entry.Hash = prev.Hash
+		entry.Width = prev.Width
+		entry.Height = prev.Height
+		entry.Trim = prev.Trim
+		entry.ContentHash = prev.ContentHash
+		return entry, nil
+	}
+	f, err := fsys.Open(fullpath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot open %s", fullpath)
+	}
+	defer f.Close()
+	if dedup := settings.Dedup == "exact"; settings.Trim || dedup {
+		img, _, err := image.Decode(f)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot decode image %s", fullpath)
+		}
+		var bounds = img.Bounds()
+		var trim = Trim{}
+		if settings.Trim {
+			bounds = TrimBounds(img, settings.TrimThreshold)
+			trim = Trim{
+				X:            int64(bounds.Min.X),
+				Y:            int64(bounds.Min.Y),
+				SourceWidth:  int64(img.Bounds().Dx()),
+				SourceHeight: int64(img.Bounds().Dy()),
+			}
+			entry.Trim = &trim
+			bounds = bounds.Add(img.Bounds().Min)
+		}
+		entry.Width = int64(bounds.Dx())
+		entry.Height = int64(bounds.Dy())
+		// the same key as the duplicate detection of AddImageFile
+		if dedup {
+			entry.ContentHash = dedupHash(img, bounds, trim)
+		}
+	} else {
+		cfg, _, err := image.DecodeConfig(f)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot decode image %s", fullpath)
+		}
+		entry.Width = int64(cfg.Width)
+		entry.Height = int64(cfg.Height)
+	}
+	// fs.File does not have to implement io.Seeker
+	f.Close()
+	if entry.Hash, err = hashFile(fsys, fullpath); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// NewManifestFileEntry creates the entry for a file, which is added with AddImageFile (see NeedsImageFile).
+// Only size, modification time and hash are recorded, the file is not decoded
+func NewManifestFileEntry(fsys fs.FS, path string, prev *ManifestEntry) (*ManifestEntry, error) {
+	path = filepath.ToSlash(filepath.Clean(path))
+	fullpath := fsName(path)
+	info, err := fs.Stat(fsys, fullpath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot stat %s", fullpath)
+	}
+	entry := &ManifestEntry{
+		Path:    path,
+		Size:    info.Size(),
+		ModTime: info.ModTime().UTC(),
+	}
+	if prev != nil && prev.Size == entry.Size && prev.ModTime.Equal(entry.ModTime) {
+		entry.Hash = prev.Hash
+		return entry, nil
+	}
+	if entry.Hash, err = hashFile(fsys, fullpath); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// hashFile returns the hex encoded sha256 checksum of the file path of fsys
+func hashFile(fsys fs.FS, path string) (string, error) {
+	f, err := fsys.Open(path)
+	if err != nil {
+		return "", errors.Wrapf(err, "cannot open %s", path)
+	}
+	defer f.Close()
+	sha := sha256.New()
+	if _, err := io.Copy(sha, f); err != nil {
+		return "", errors.Wrapf(err, "cannot read %s", path)
+	}
+	return hex.EncodeToString(sha.Sum(nil)), nil
+}
+
+// NeedsImageFile returns true, if the image path cannot be added by its size (AddRect, AddTrimmedRect)
+// but only with AddImageFile: rasterized images, multi-page tiff images and all images with perceptual
+// duplicate detection
+func (sc *SemibranCollage) NeedsImageFile(path string) bool {
+	if sc.dedup == DedupPerceptual {
+		return true
+	}
+	fullpath := fsName(filepath.ToSlash(filepath.Clean(path)))
+	for _, source := range sc.sources {
+		if source.Match(fullpath) {
+			return true
+		}
+	}
+	if isTIFF(fullpath) {
+		if pages, err := TIFFPageCount(sc.fsys, fullpath); err == nil && pages > 1 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,74 @@
+package imagecollage
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"io/fs"
+	"math"
+)
+
+// MasonryCollage places the images in columns of the same width. Every image is scaled to the
+// column width and added to the shortest column
+type MasonryCollage struct {
+	galleryCollage
+	columns, columnWidth int64
+}
+
+// NewMasonryCollage creates columns columns (0: as many as needed for a roughly square collage)
+// of columnWidth pixels of the images in fsys
+func NewMasonryCollage(fsys fs.FS, columns, columnWidth, space, margin int64) *MasonryCollage {
+	return &MasonryCollage{
+		galleryCollage: newGalleryCollage(fsys, space, margin),
+		columns:        columns,
+		columnWidth:    columnWidth,
+	}
+}
+
+func (mc *MasonryCollage) Pack() (Layout, error) {
+	if mc.columnWidth <= 0 {
+		return Layout{}, errors.New(fmt.Sprintf("invalid column width %v", mc.columnWidth))
+	}
+	var images = mc.sortedImages()
+	var layout = Layout{Rects: []Rect{}}
+	if len(images) == 0 {
+		return layout, nil
+	}
+	var columns = mc.columns
+	if columns <= 0 {
+		// the total height of the scaled images in one column is the width of sqrt(sum) columns
+		var sum float64
+		for _, img := range images {
+			sum += float64(img.Height) / float64(img.Width)
+		}
+		columns = int64(math.Max(1, math.Round(math.Sqrt(sum))))
+		if int64(len(images)) < columns {
+			columns = int64(len(images))
+		}
+	}
+	// bottom of every column
+	var bottoms = make([]int64, columns)
+	for _, img := range images {
+		var column = 0
+		for i := range bottoms {
+			if bottoms[i] < bottoms[column] {
+				column = i
+			}
+		}
+		var height = int64(math.Max(1, math.Round(float64(img.Height)*float64(mc.columnWidth)/float64(img.Width))))
+		layout.Rects = append(layout.Rects, Rect{
+			Name:   img.Name,
+			X:      int64(column) * (mc.columnWidth + mc.space),
+			Y:      bottoms[column],
+			Width:  mc.columnWidth,
+			Height: height,
+		})
+		bottoms[column] += height + mc.space
+	}
+	var height int64
+	for _, bottom := range bottoms {
+		height = max(height, bottom-mc.space)
+	}
+	layout.Width = columns*mc.columnWidth + (columns-1)*mc.space
+	layout.Height = height
+	return layout, nil
+}
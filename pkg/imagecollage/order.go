@@ -0,0 +1,194 @@
+package imagecollage
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/pkg/errors"
+	"io/fs"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+type OrderMode int
+
+const (
+	// OrderName sorts by path (byte-wise)
+	OrderName OrderMode = iota
+	// OrderNatural sorts by path with numbers compared by value (img2 < img10)
+	OrderNatural
+	// OrderModTime sorts by modification time of the files
+	OrderModTime
+	// OrderCapture sorts by EXIF DateTimeOriginal, images without EXIF data use the modification time
+	OrderCapture
+	// OrderSize sorts by area descending
+	OrderSize
+	// OrderList sorts by the position in Order.List
+	OrderList
+)
+
+// Order defines the order of the images of a collage. It is the placement order of the gallery collages
+// and the order of the images in the layout. Ties are sorted naturally
+type Order struct {
+	Mode OrderMode
+	// List contains the paths of OrderList, images not in the list follow in natural order
+	List    []string
+	Reverse bool
+}
+
+// ParseOrderMode returns the order mode of name|natural|mtime|exif|size|list
+func ParseOrderMode(mode string) (OrderMode, error) {
+	switch mode {
+	case "name":
+		return OrderName, nil
+	case "natural":
+		return OrderNatural, nil
+	case "mtime":
+		return OrderModTime, nil
+	case "exif":
+		return OrderCapture, nil
+	case "size":
+		return OrderSize, nil
+	case "list":
+		return OrderList, nil
+	default:
+		return OrderName, errors.New(fmt.Sprintf("invalid order %s", mode))
+	}
+}
+
+// LoadOrderList reads the paths of an ordering file with one path per line.
+// Empty lines and lines starting with # are ignored
+func LoadOrderList(file string) ([]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot open order file %s", file)
+	}
+	defer f.Close()
+	var result = []string{}
+	var scanner = bufio.NewScanner(f)
+	for scanner.Scan() {
+		var line = strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		result = append(result, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrapf(err, "cannot read order file %s", file)
+	}
+	return result, nil
+}
+
+func isDigit(b byte) bool {
+	return b >= '0' && b <= '9'
+}
+
+// naturalLess compares a and b with sequences of digits compared by their value
+func naturalLess(a, b string) bool {
+	var i, j = 0, 0
+	for i < len(a) && j < len(b) {
+		if isDigit(a[i]) && isDigit(b[j]) {
+			var si, sj = i, j
+			for i < len(a) && isDigit(a[i]) {
+				i++
+			}
+			for j < len(b) && isDigit(b[j]) {
+				j++
+			}
+			var na, nb = strings.TrimLeft(a[si:i], "0"), strings.TrimLeft(b[sj:j], "0")
+			if len(na) != len(nb) {
+				return len(na) < len(nb)
+			}
+			if na != nb {
+				return na < nb
+			}
+			continue
+		}
+		if a[i] != b[j] {
+			return a[i] < b[j]
+		}
+		i++
+		j++
+	}
+	if len(a)-i != len(b)-j {
+		return len(a)-i < len(b)-j
+	}
+	return a < b
+}
+
+// modTime returns the modification time of the file path of fsys
+func modTime(fsys fs.FS, path string) time.Time {
+	info, err := fs.Stat(fsys, path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+// sortRects sorts rects by the order. source returns the path of the source image of a rect within fsys
+func (o Order) sortRects(fsys fs.FS, rects []Rect, source func(name string) string) {
+	var times = map[string]time.Time{}
+	var positions = map[string]int{}
+	switch o.Mode {
+	case OrderModTime, OrderCapture:
+		for _, rect := range rects {
+			var path = fsName(source(rect.Name))
+			if _, ok := times[path]; ok {
+				continue
+			}
+			if t, ok := CaptureTime(fsys, path); ok && o.Mode == OrderCapture {
+				times[path] = t
+			} else {
+				times[path] = modTime(fsys, path)
+			}
+		}
+	case OrderList:
+		for i, path := range o.List {
+			if _, ok := positions[fsName(path)]; !ok {
+				positions[fsName(path)] = i
+			}
+		}
+	}
+	var less = func(a, b Rect) bool {
+		switch o.Mode {
+		case OrderName:
+			return a.Name < b.Name
+		case OrderModTime, OrderCapture:
+			var ta, tb = times[fsName(source(a.Name))], times[fsName(source(b.Name))]
+			if !ta.Equal(tb) {
+				return ta.Before(tb)
+			}
+		case OrderSize:
+			if a.Width*a.Height != b.Width*b.Height {
+				return a.Width*a.Height > b.Width*b.Height
+			}
+		case OrderList:
+			pa, okA := positions[fsName(source(a.Name))]
+			pb, okB := positions[fsName(source(b.Name))]
+			if okA != okB {
+				return okA
+			}
+			if pa != pb {
+				return pa < pb
+			}
+		}
+		return naturalLess(a.Name, b.Name)
+	}
+	sort.SliceStable(rects, func(i, j int) bool {
+		if o.Reverse {
+			return less(rects[j], rects[i])
+		}
+		return less(rects[i], rects[j])
+	})
+}
+
+// SetOrder sets the order of the images in the layout
+func (sc *SemibranCollage) SetOrder(order Order) {
+	sc.order = order
+}
+
+// SetOrder sets the placement order of the images
+func (gc *galleryCollage) SetOrder(order Order) {
+	gc.order = order
+}
@@ -111,6 +111,8 @@ type STBRPRect struct {
 
 	// input:
 	w, h STBRPCoord
+	// rects with higher priority are packed first
+	priority int
 
 	// output:
 	x, y       STBRPCoord
@@ -129,6 +131,11 @@ func (rect *STBRPRect) SetDimensions(w, h int) {
 	rect.h = STBRPCoord(h)
 }
 
+// SetPriority packs the rect before all rects with lower priority (default: 0)
+func (rect *STBRPRect) SetPriority(priority int) {
+	rect.priority = priority
+}
+
 // STBRP_DEF void STBRPInitTarget (stbrp_context *context, int Width, int Height, stbrp_node *nodes, int num_nodes);
 // Initialize a rectangle packer to:
 //    pack a rectangle that is 'Width' by 'Height' in dimensions
@@ -258,13 +265,13 @@ func stbrpSkylineFindMinY(c *STBRPContext, first *STBRPNode, x0, width int, pwas
 	var x1 = x0 + width
 	var min_y, visited_width, waste_area int
 
-	if first.x <= STBRPCoord(x0) {
+	if !(first.x <= STBRPCoord(x0)) {
 		log.Fatalf("assertion failed: first.X <= STBRPCoord(x0)")
 	}
-	if node.next.x > STBRPCoord(x0) {
+	if !(node.next.x > STBRPCoord(x0)) {
 		log.Fatalf("assertion failed: node.next.X > STBRPCoord(x0)")
 	}
-	if node.x <= STBRPCoord(x0) {
+	if !(node.x <= STBRPCoord(x0)) {
 		log.Fatalf("assertion failed: node.X <= STBRPCoord(x0)")
 	}
 
@@ -318,7 +325,7 @@ func stbrpSkylineFindBestPos(c *STBRPContext, width, height int) stbrp__findresu
 	// align to multiple of c->align
 	width = width + c.align - 1
 	width -= width % c.align
-	if width%c.align == 0 {
+	if width%c.align != 0 {
 		log.Fatal("assertion failed: Width % c.align == 0")
 	}
 
@@ -389,7 +396,7 @@ func stbrpSkylineFindBestPos(c *STBRPContext, width, height int) stbrp__findresu
 		for tail != nil {
 			var xpos = int(tail.x) - width
 			var y, waste int
-			if xpos >= 0 {
+			if !(xpos >= 0) {
 				log.Fatal("assertion failed: xpos >= 0")
 			}
 			// find the left position that matches this
@@ -397,7 +404,7 @@ func stbrpSkylineFindBestPos(c *STBRPContext, width, height int) stbrp__findresu
 				prev = &node.next
 				node = node.next
 			}
-			if int(node.next.x) > xpos && int(node.x) <= xpos {
+			if !(int(node.next.x) > xpos && int(node.x) <= xpos) {
 				log.Fatal("assertion failed: node->next->X > xpos && node->X <= xpos")
 			}
 			y = stbrpSkylineFindMinY(c, node, xpos, width, &waste)
@@ -405,7 +412,7 @@ func stbrpSkylineFindBestPos(c *STBRPContext, width, height int) stbrp__findresu
 				if y <= best_y {
 					if y < best_y || waste < best_waste || (waste == best_waste && xpos < best_x) {
 						best_x = xpos
-						if y <= best_y {
+						if !(y <= best_y) {
 							log.Fatal("assertion failed: Y <= best_y")
 						}
 						best_y = y
@@ -480,6 +487,12 @@ func stbrpSkylinePackRectangle(context *STBRPContext, width, height int) stbrp__
 }
 
 func rectHeightCompare(p, q *STBRPRect) int {
+	if p.priority > q.priority {
+		return -1
+	}
+	if p.priority < q.priority {
+		return 1
+	}
 	if p.h > q.h {
 		return -1
 	}
@@ -520,7 +533,7 @@ func STBRPPackRects(context *STBRPContext, rects []*STBRPRect) int {
 
 	// sort according to heuristic
 	//qsort(Rects, num_rects, sizeof(Rects[0]), rect_height_compare);
-	sort.Slice(rects, func(i, j int) bool { return rectHeightCompare(rects[i], rects[j]) > 0 })
+	sort.SliceStable(rects, func(i, j int) bool { return rectHeightCompare(rects[i], rects[j]) < 0 })
 
 	for i = 0; i < len(rects); i++ {
 		if rects[i].w == 0 || rects[i].h == 0 {
@@ -540,7 +553,7 @@ func STBRPPackRects(context *STBRPContext, rects []*STBRPRect) int {
 
 	// unsort
 	//qsort(Rects, num_rects, sizeof(Rects[0]), rect_original_order);
-	sort.Slice(rects, func(i, j int) bool { return rectOriginalOrder(rects[i], rects[j]) > 0 })
+	sort.Slice(rects, func(i, j int) bool { return rectOriginalOrder(rects[i], rects[j]) < 0 })
 
 	// set was_packed flags and all_rects_packed status
 	for i = 0; i < len(rects); i++ {
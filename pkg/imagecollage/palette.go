@@ -0,0 +1,107 @@
+package imagecollage
+
+import (
+	"fmt"
+	"github.com/je4/PictureFS/v2/pkg/PictureFS"
+	"github.com/pkg/errors"
+	"golang.org/x/image/draw"
+	"image"
+	"image/color"
+)
+
+type ColorMode int
+
+const (
+	// ColorModeNRGBA creates 32 bit images
+	ColorModeNRGBA ColorMode = iota
+	// ColorModeAuto creates gray images, if all pixels are opaque gray and paletted images, if
+	// there are not more than 256 colors. Otherwise the image is NRGBA
+	ColorModeAuto
+	// ColorModeGray converts the image to grayscale
+	ColorModeGray
+	// ColorModePaletted creates a paletted image. If there are too many colors, the palette is
+	// reduced with the median cut quantizer
+	ColorModePaletted
+)
+
+// ParseColorMode returns the color mode with name auto, rgba, gray or paletted
+func ParseColorMode(name string) (ColorMode, error) {
+	switch name {
+	case "auto":
+		return ColorModeAuto, nil
+	case "rgba":
+		return ColorModeNRGBA, nil
+	case "gray":
+		return ColorModeGray, nil
+	case "paletted":
+		return ColorModePaletted, nil
+	default:
+		return 0, errors.New(fmt.Sprintf("invalid color mode %s", name))
+	}
+}
+
+// SetColorMode defines the image type of CreateImage. maxColors is the maximum palette size (2..256)
+func (sc *SemibranCollage) SetColorMode(mode ColorMode, maxColors int) {
+	sc.colorMode = mode
+	sc.maxColors = maxColors
+}
+
+// colorHistogram counts the colors of img. It stops after max+1 different colors
+func colorHistogram(img *image.NRGBA, max int) (map[color.NRGBA]int, bool) {
+	var hist = map[color.NRGBA]int{}
+	var gray = true
+	for y := img.Rect.Min.Y; y < img.Rect.Max.Y; y++ {
+		var row = img.Pix[img.PixOffset(img.Rect.Min.X, y):img.PixOffset(img.Rect.Max.X, y)]
+		for i := 0; i < len(row); i += 4 {
+			var c = color.NRGBA{R: row[i], G: row[i+1], B: row[i+2], A: row[i+3]}
+			if gray && (c.R != c.G || c.G != c.B || c.A != 0xff) {
+				gray = false
+			}
+			if len(hist) <= max {
+				hist[c]++
+			}
+		}
+	}
+	return hist, gray
+}
+
+// ConvertImage converts img to the image type of mode. maxColors limits the palette of paletted images
+func ConvertImage(img *image.NRGBA, mode ColorMode, maxColors int) image.Image {
+	if maxColors <= 0 || maxColors > 256 {
+		maxColors = 256
+	}
+	if mode == ColorModeNRGBA {
+		return img
+	}
+	hist, gray := colorHistogram(img, maxColors)
+	if mode == ColorModeGray || mode == ColorModeAuto && gray {
+		var result = image.NewGray(img.Rect)
+		draw.Draw(result, result.Rect, img, img.Rect.Min, draw.Src)
+		return result
+	}
+	if mode == ColorModeAuto && len(hist) > maxColors {
+		return img
+	}
+	var palette color.Palette
+	if len(hist) <= maxColors {
+		palette = PictureFS.SortedPalette(hist)
+	} else {
+		palette = PictureFS.MedianCut{}.Quantize(make(color.Palette, 0, maxColors), img)
+	}
+	var result = image.NewPaletted(img.Rect, palette)
+	if len(hist) <= maxColors {
+		// exact mapping, draw would merge colors which only differ in fully transparent pixels
+		var index = map[color.NRGBA]uint8{}
+		for i, c := range palette {
+			index[c.(color.NRGBA)] = uint8(i)
+		}
+		for y := img.Rect.Min.Y; y < img.Rect.Max.Y; y++ {
+			for x := img.Rect.Min.X; x < img.Rect.Max.X; x++ {
+				result.SetColorIndex(x, y, index[img.NRGBAAt(x, y)])
+			}
+		}
+		return result
+	}
+	draw.Draw(result, result.Rect, img, img.Rect.Min, draw.Src)
+	return result
+}
@@ -0,0 +1,109 @@
+package imagecollage
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"path"
+	"sort"
+)
+
+// SetPin places the rect name at x, y of the packing area (the coordinates of Layout, without outer margins).
+// Pinned rects are placed before all other rects and must be aligned
+func (sc *SemibranCollage) SetPin(name string, x, y int64) {
+	if sc.pins == nil {
+		sc.pins = map[string]Position{}
+	}
+	sc.pins[name] = Position{x: x, y: y}
+}
+
+// SetPriority places the rect name before all rects with lower priority (default: 0).
+// Rects with the same priority are placed by size
+func (sc *SemibranCollage) SetPriority(name string, priority int) {
+	if sc.priorities == nil {
+		sc.priorities = map[string]int{}
+	}
+	sc.priorities[name] = priority
+}
+
+// SetGroup packs the rect name together with the other rects of group into one contiguous region
+func (sc *SemibranCollage) SetGroup(name, group string) {
+	if sc.groups == nil {
+		sc.groups = map[string]string{}
+	}
+	sc.groups[name] = group
+}
+
+// SetGroupFunc defines the group of all rects without SetGroup. fn gets the path of the source
+// image (e.g. of frames and pages) and returns the group or "" for no group
+func (sc *SemibranCollage) SetGroupFunc(fn func(path string) string) {
+	sc.groupFunc = fn
+}
+
+// DirectoryGroup is a group function, which packs the images of every subdirectory together
+func DirectoryGroup(name string) string {
+	var dir = path.Dir(path.Clean("/" + name))
+	if dir == "/" {
+		return ""
+	}
+	return dir
+}
+
+// sourcePath returns the path of the source image of a rect
+func (sc *SemibranCollage) sourcePath(name string) string {
+	if frame, ok := sc.frames[name]; ok {
+		return frame.source
+	}
+	if page, ok := sc.pages[name]; ok {
+		return page.source
+	}
+	return name
+}
+
+// rectGroups returns the groups of the rects
+func (sc *SemibranCollage) rectGroups() map[string]string {
+	var result = map[string]string{}
+	for _, rect := range sc.rects {
+		if group, ok := sc.groups[rect.Name]; ok {
+			result[rect.Name] = group
+		} else if sc.groupFunc != nil {
+			result[rect.Name] = sc.groupFunc(sc.sourcePath(rect.Name))
+		}
+	}
+	return result
+}
+
+// checkPins returns an error, if a pinned rect does not exist, is not aligned or overlaps another pinned rect
+func (sc *SemibranCollage) checkPins(opts packOptions) error {
+	var sizes = map[string]Rect{}
+	for _, rect := range sc.rects {
+		sizes[rect.Name] = rect
+	}
+	var names = []string{}
+	for name := range sc.pins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var pinned = []Rect{}
+	for _, name := range names {
+		var pin = sc.pins[name]
+		size, ok := sizes[name]
+		if !ok {
+			return errors.New(fmt.Sprintf("cannot pin %s: rectangle not found", name))
+		}
+		var align = max(opts.align, 1)
+		if pin.x < 0 || pin.y < 0 || pin.x%align != 0 || pin.y%align != 0 {
+			return errors.New(fmt.Sprintf("cannot pin %s to %v/%v: position not aligned to %v", name, pin.x, pin.y, align))
+		}
+		var rect = Rect{Name: name, X: pin.x, Y: pin.y, Width: size.Width, Height: size.Height}
+		if !fits(rect, opts) {
+			return errors.New(fmt.Sprintf("cannot pin %s to %v/%v: outside of %vx%v", name, pin.x, pin.y, opts.maxWidth, opts.maxHeight))
+		}
+		for _, other := range pinned {
+			if intersects(rect, other) {
+				return errors.New(fmt.Sprintf("cannot pin %s to %v/%v: overlaps %s", name, pin.x, pin.y, other.Name))
+			}
+		}
+		pinned = append(pinned, rect)
+	}
+	return nil
+}
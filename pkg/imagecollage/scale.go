@@ -0,0 +1,224 @@
+package imagecollage
+
+import (
+	"fmt"
+	"github.com/je4/PictureFS/v2/pkg/PictureFS"
+	"github.com/pkg/errors"
+	"golang.org/x/image/draw"
+	"image"
+	"image/color"
+	"sort"
+)
+
+func gcd(a, b int64) int64 {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+// lcm returns the least common multiple of a and b (values <= 1 are ignored)
+func lcm(a, b int64) int64 {
+	if a <= 1 {
+		return max(b, 1)
+	}
+	if b <= 1 {
+		return a
+	}
+	return a / gcd(a, b) * b
+}
+
+// SetScales packs the images for several scale factors, e.g. 1, 2 and 3 for 1x, 2x and 3x atlases.
+// The source images have the largest scale. Positions are aligned to the least common multiple of
+// the scales and image sizes have to be divisible by scaleUnit, so that the coordinates of every
+// scale are exact. Use CreateScaledImage and CreateScaledLayout for the smaller scales
+func (sc *SemibranCollage) SetScales(scales []int64) error {
+	var result = []int64{}
+	var found = map[int64]bool{}
+	for _, scale := range scales {
+		if scale <= 0 {
+			return errors.New(fmt.Sprintf("invalid scale %v", scale))
+		}
+		if !found[scale] {
+			found[scale] = true
+			result = append(result, scale)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i] < result[j] })
+	sc.scales = result
+	return nil
+}
+
+// Scales returns the scale factors of the collage in ascending order
+func (sc *SemibranCollage) Scales() []int64 {
+	return sc.scales
+}
+
+// maxScale returns the scale of the source images
+func (sc *SemibranCollage) maxScale() int64 {
+	if len(sc.scales) == 0 {
+		return 1
+	}
+	return sc.scales[len(sc.scales)-1]
+}
+
+// scaleAlign returns the least common multiple of the scales
+func (sc *SemibranCollage) scaleAlign() int64 {
+	var result int64 = 1
+	for _, scale := range sc.scales {
+		result = lcm(result, scale)
+	}
+	return result
+}
+
+// scaleUnit returns the smallest size, which is an integer at every scale
+func (sc *SemibranCollage) scaleUnit() int64 {
+	var result int64 = 1
+	for _, scale := range sc.scales {
+		result = lcm(result, sc.maxScale()/gcd(sc.maxScale(), scale))
+	}
+	return result
+}
+
+// alignment returns the alignment of the image positions
+func (sc *SemibranCollage) alignment() int64 {
+	return lcm(sc.align, sc.scaleAlign())
+}
+
+// checkScaleSize returns an error, if a size of the image name cannot be scaled exactly
+func (sc *SemibranCollage) checkScaleSize(name string, sizes ...int64) error {
+	var unit = sc.scaleUnit()
+	for _, size := range sizes {
+		if size%unit != 0 {
+			return errors.New(fmt.Sprintf("cannot scale %s: size %v is not a multiple of %v", name, size, unit))
+		}
+	}
+	return nil
+}
+
+// alignTrim extends the trimmed bounds b of an image of width x height to multiples of the scale unit
+func (sc *SemibranCollage) alignTrim(b image.Rectangle, width, height int) image.Rectangle {
+	var unit = int(sc.scaleUnit())
+	if unit <= 1 {
+		return b
+	}
+	b.Min.X -= b.Min.X % unit
+	b.Min.Y -= b.Min.Y % unit
+	b.Max.X = int(alignUp(int64(b.Max.X), int64(unit)))
+	b.Max.Y = int(alignUp(int64(b.Max.Y), int64(unit)))
+	return b.Intersect(image.Rect(0, 0, width, height))
+}
+
+// scaled converts v from the scale of the source images to scale
+func (sc *SemibranCollage) scaled(v int, scale int64) int {
+	return int(int64(v) * scale / sc.maxScale())
+}
+
+func (sc *SemibranCollage) checkScale(scale int64) error {
+	for _, s := range sc.scales {
+		if s == scale {
+			return nil
+		}
+	}
+	return errors.New(fmt.Sprintf("scale %v not in %v", scale, sc.scales))
+}
+
+// contentRect returns the position of the image of the packed rect within the collage image
+func (sc *SemibranCollage) contentRect(rect Rect) image.Rectangle {
+	return image.Rect(
+		int(rect.X+sc.left()+sc.gutter()),
+		int(rect.Y+sc.top()+sc.gutter()),
+		int(rect.X+sc.left()+rect.Width-sc.gutter()),
+		int(rect.Y+sc.top()+rect.Height-sc.gutter()),
+	)
+}
+
+// CreateScaledImage creates the collage image of layout at scale. The images are resampled
+// from the source images, border, padding and extrusion are scaled and rounded
+func (sc *SemibranCollage) CreateScaledImage(layout Layout, scale int64) (image.Image, error) {
+	if err := sc.checkScale(scale); err != nil {
+		return nil, err
+	}
+	if scale == sc.maxScale() {
+		return sc.CreateImage(layout)
+	}
+	var size = sc.ImageSize(layout)
+	size.Max = image.Point{X: sc.scaled(size.Max.X, scale), Y: sc.scaled(size.Max.Y, scale)}
+	var collImg draw.Image
+	if sc.deep {
+		collImg = image.NewNRGBA64(size)
+	} else {
+		collImg = image.NewNRGBA(size)
+	}
+	// rounded size of the space around the images
+	var round = func(v int64) int {
+		return int((v*scale + sc.maxScale()/2) / sc.maxScale())
+	}
+	var border, inner, extrude = round(sc.border), round(sc.inner()), round(sc.extrude)
+	for _, rect := range layout.Rects {
+		tile, err := sc.renderRect(rect)
+		if err != nil {
+			return nil, err
+		}
+		var content = sc.contentRect(rect)
+		var dst = image.Rect(
+			sc.scaled(content.Min.X, scale),
+			sc.scaled(content.Min.Y, scale),
+			sc.scaled(content.Max.X, scale),
+			sc.scaled(content.Max.Y, scale),
+		)
+		draw.CatmullRom.Scale(collImg, dst, tile, content, draw.Src, nil)
+		if border > 0 {
+			DrawRect(
+				dst.Min.X-inner-border,
+				dst.Min.Y-inner-border,
+				dst.Max.X+inner+border-1,
+				dst.Max.Y+inner+border-1,
+				border, color.Black, collImg)
+		}
+		if extrude > 0 {
+			Extrude(collImg, dst, extrude)
+		}
+	}
+	if sc.deep {
+		return ConvertImage64(collImg.(*image.NRGBA64), sc.colorMode, sc.maxColors), nil
+	}
+	return ConvertImage(collImg.(*image.NRGBA), sc.colorMode, sc.maxColors), nil
+}
+
+// CreateScaledLayout creates the layout of the collage image of CreateScaledImage
+func (sc *SemibranCollage) CreateScaledLayout(layout Layout, scale int64) (*PictureFS.Layout, error) {
+	if err := sc.checkScale(scale); err != nil {
+		return nil, err
+	}
+	result, err := sc.CreateLayout(layout)
+	if err != nil {
+		return nil, err
+	}
+	if scale == sc.maxScale() {
+		return result, nil
+	}
+	result.Scale = int(scale)
+	for i, rect := range result.Images {
+		rect.X, rect.Y = sc.scaled(rect.X, scale), sc.scaled(rect.Y, scale)
+		rect.Width, rect.Height = sc.scaled(rect.Width, scale), sc.scaled(rect.Height, scale)
+		rect.TrimX, rect.TrimY = sc.scaled(rect.TrimX, scale), sc.scaled(rect.TrimY, scale)
+		rect.SourceWidth, rect.SourceHeight = sc.scaled(rect.SourceWidth, scale), sc.scaled(rect.SourceHeight, scale)
+		result.Images[i] = rect
+	}
+	for i, anim := range result.Animations {
+		var frames = make([]PictureFS.Frame, len(anim.Frames))
+		for j, frame := range anim.Frames {
+			frame.X, frame.Y = sc.scaled(frame.X, scale), sc.scaled(frame.Y, scale)
+			frames[j] = frame
+		}
+		anim.Width, anim.Height = sc.scaled(anim.Width, scale), sc.scaled(anim.Height, scale)
+		anim.Frames = frames
+		result.Animations[i] = anim
+	}
+	if sc.uvOrigin != "" {
+		var size = sc.ImageSize(layout)
+		result.UV = sc.createUV(result, sc.scaled(size.Dx(), scale), sc.scaled(size.Dy(), scale))
+	}
+	return result, nil
+}
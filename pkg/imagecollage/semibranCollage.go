@@ -8,21 +8,76 @@ import (
 	"golang.org/x/image/draw"
 	"image"
 	"image/color"
-	"os"
+	"io/fs"
 	"path/filepath"
+	"sort"
 	"strings"
 )
 
 type SemibranCollage struct {
 	rects                                            []Rect
-	basePath                                         string
+	fsys                                             fs.FS
 	border                                           int64
 	margin                                           int64
 	marginTop, marginLeft, marginBottom, marginRight int64
+	seed                                             map[string]Rect
+	seedImage                                        image.Image
+	align                                            int64
+	canvas                                           CanvasOptions
+	extrude                                          int64
+	padding                                          int64
+	trim                                             bool
+	trimThreshold                                    uint8
+	trims                                            map[string]Trim
+	names                                            map[string]bool
+	dedup                                            DedupMode
+	dedupDistance                                    int
+	aliases                                          map[string]string
+	hashes                                           map[string]string
+	phashes                                          map[string][]phash
+	uvOrigin                                         string
+	uvHalfTexel                                      bool
+	animation                                        AnimationMode
+	animations                                       map[string]PictureFS.Animation
+	frames                                           map[string]frameRef
+	frameCache                                       *frameCache
+	colorMode                                        ColorMode
+	maxColors                                        int
+	colorSpace                                       *ICCProfile
+	pages                                            map[string]frameRef
+	tiffs                                            map[string]*TIFFFile
+	deep                                             bool
+	sources                                          []Source
+	rasterization                                    Rasterization
+	sourceInfos                                      map[string]*PictureFS.SourceInfo
+	scales                                           []int64
+	pins                                             map[string]Position
+	priorities                                       map[string]int
+	groups                                           map[string]string
+	groupFunc                                        func(path string) string
+	order                                            Order
 }
 
-func getImageFromFilePath(filePath string) (image.Image, error) {
-	f, err := os.Open(filePath)
+type phash struct {
+	name string
+	hash uint64
+}
+
+// CanvasOptions constrains the size of the resulting collage image (including outer margins)
+type CanvasOptions struct {
+	// PowerOfTwo rounds width and height up to the next power of two
+	PowerOfTwo bool
+	// Multiple rounds width and height up to the next multiple of Multiple
+	Multiple int64
+	// Square forces width == height
+	Square bool
+	// FixedWidth and FixedHeight define the exact size of the image, Pack fails if the rects do not fit.
+	// If only one of them is set, the other dimension grows with the rects (a square canvas is fixed in both)
+	FixedWidth, FixedHeight int64
+}
+
+func getImageFromFilePath(fsys fs.FS, filePath string) (image.Image, error) {
+	f, err := fsys.Open(filePath)
 	if err != nil {
 		return nil, err
 	}
@@ -31,7 +86,12 @@ func getImageFromFilePath(filePath string) (image.Image, error) {
 	return image, err
 }
 
-func DrawRect(x1, y1, x2, y2, thickness int, col color.Color, img *image.NRGBA) {
+// fsName returns the name of the layout path within an fs.FS (without leading slash)
+func fsName(path string) string {
+	return strings.TrimLeft(filepath.ToSlash(filepath.Clean(path)), "/")
+}
+
+func DrawRect(x1, y1, x2, y2, thickness int, col color.Color, img draw.Image) {
 
 	for t := 0; t < thickness; t++ {
 		// draw horizontal lines
@@ -47,89 +107,455 @@ func DrawRect(x1, y1, x2, y2, thickness int, col color.Color, img *image.NRGBA)
 	}
 }
 
+// Extrude repeats the edge pixels of the region r n times into the surrounding pixels of img
+func Extrude(img draw.Image, r image.Rectangle, n int) {
+	if r.Empty() {
+		return
+	}
+	for k := 1; k <= n; k++ {
+		// top and bottom rows
+		draw.Draw(img, image.Rect(r.Min.X, r.Min.Y-k, r.Max.X, r.Min.Y-k+1), img, r.Min, draw.Src)
+		draw.Draw(img, image.Rect(r.Min.X, r.Max.Y-1+k, r.Max.X, r.Max.Y+k), img, image.Point{X: r.Min.X, Y: r.Max.Y - 1}, draw.Src)
+	}
+	for k := 1; k <= n; k++ {
+		// left and right columns including the corners
+		draw.Draw(img, image.Rect(r.Min.X-k, r.Min.Y-n, r.Min.X-k+1, r.Max.Y+n), img, image.Point{X: r.Min.X, Y: r.Min.Y - n}, draw.Src)
+		draw.Draw(img, image.Rect(r.Max.X-1+k, r.Min.Y-n, r.Max.X+k, r.Max.Y+n), img, image.Point{X: r.Max.X - 1, Y: r.Min.Y - n}, draw.Src)
+	}
+}
+
+// NewSemibranCollage creates a collage of the images in fsys. Use os.DirFS for a folder
 func NewSemibranCollage(
-	basePath string,
+	fsys fs.FS,
 	borderWidth, margin int64,
 	marginLeft, marginTop, marginRight, marginBottom int64,
 ) *SemibranCollage {
 	var sc = &SemibranCollage{
 		rects:        []Rect{},
-		basePath:     filepath.ToSlash(filepath.Clean(basePath)),
+		fsys:         fsys,
 		border:       borderWidth,
 		margin:       margin,
 		marginBottom: marginBottom,
 		marginLeft:   marginLeft,
 		marginRight:  marginRight,
 		marginTop:    marginTop,
+		trims:        map[string]Trim{},
+		names:        map[string]bool{},
+		aliases:      map[string]string{},
+		hashes:       map[string]string{},
+		phashes:      map[string][]phash{},
+		animations:   map[string]PictureFS.Animation{},
+		frames:       map[string]frameRef{},
+		pages:        map[string]frameRef{},
+		tiffs:        map[string]*TIFFFile{},
+		sources:      DefaultSources(),
+		sourceInfos:  map[string]*PictureFS.SourceInfo{},
 	}
 	return sc
 }
 
-func (sc *SemibranCollage) AddRect(name string, width, height int64) error {
-	for _, r := range sc.rects {
-		if r.Name == name {
-			return errors.New(fmt.Sprintf("rectangle with name %s already added", name))
+// FS returns the file system of the source images
+func (sc *SemibranCollage) FS() fs.FS {
+	return sc.fsys
+}
+
+// SetSeed uses the layout of a previous run as packing seed.
+// Rects with unchanged name and size keep their position. If img is not nil, it has to be
+// the image of the previous run and the content of seeded rects is copied from it instead of
+// reading the source files
+func (sc *SemibranCollage) SetSeed(layout Layout, img image.Image) {
+	sc.seed = map[string]Rect{}
+	for _, rect := range layout.Rects {
+		sc.seed[rect.Name] = rect
+	}
+	sc.seedImage = img
+}
+
+// SetAlignment aligns the top left corner of every image within the collage to a multiple of align pixels
+func (sc *SemibranCollage) SetAlignment(align int64) {
+	sc.align = align
+}
+
+// SetCanvas sets the size constraints of the collage image
+func (sc *SemibranCollage) SetCanvas(canvas CanvasOptions) {
+	sc.canvas = canvas
+}
+
+// SetExtrude repeats the edge pixels of every image n times into the surrounding space.
+// This prevents texture bleeding if the collage is sampled with bilinear filtering
+func (sc *SemibranCollage) SetExtrude(n int64) {
+	sc.extrude = n
+}
+
+// SetPadding adds n transparent pixels around every image (outside of extrusion, inside of border)
+func (sc *SemibranCollage) SetPadding(n int64) {
+	sc.padding = n
+}
+
+// space between image content and border
+func (sc *SemibranCollage) inner() int64 {
+	return sc.extrude + sc.padding
+}
+
+// space between image content and the edge of the packed rect
+func (sc *SemibranCollage) gutter() int64 {
+	return sc.margin + sc.border + sc.inner()
+}
+
+// left margin including additional space needed for alignment
+func (sc *SemibranCollage) left() int64 {
+	return alignUp(sc.marginLeft+sc.gutter(), sc.alignment()) - sc.gutter()
+}
+
+// top margin including additional space needed for alignment
+func (sc *SemibranCollage) top() int64 {
+	return alignUp(sc.marginTop+sc.gutter(), sc.alignment()) - sc.gutter()
+}
+
+// nextPowerOfTwo returns the smallest power of two >= v
+func nextPowerOfTwo(v int64) int64 {
+	var p int64 = 1
+	for p < v {
+		p <<= 1
+	}
+	return p
+}
+
+// fixedSize returns the fixed width and height of the canvas (0: not fixed)
+func (sc *SemibranCollage) fixedSize() (int64, int64) {
+	var width, height = sc.canvas.FixedWidth, sc.canvas.FixedHeight
+	if sc.canvas.Square && (width > 0) != (height > 0) {
+		width = max(width, height)
+		height = width
+	}
+	return width, height
+}
+
+// canvasSize applies the canvas constraints to the given image size
+func (sc *SemibranCollage) canvasSize(width, height int64) (int64, int64) {
+	var fixedWidth, fixedHeight = sc.fixedSize()
+	if fixedWidth > 0 && fixedHeight > 0 {
+		return fixedWidth, fixedHeight
+	}
+	if sc.canvas.Square {
+		width = max(width, height)
+		height = width
+	}
+	if sc.canvas.Multiple > 1 {
+		width = alignUp(width, sc.canvas.Multiple)
+		height = alignUp(height, sc.canvas.Multiple)
+	}
+	if sc.canvas.PowerOfTwo {
+		width = nextPowerOfTwo(width)
+		height = nextPowerOfTwo(height)
+	}
+	if sc.canvas.Square {
+		width = max(width, height)
+		height = width
+	}
+	// a single fixed dimension is not rounded
+	if fixedWidth > 0 {
+		width = fixedWidth
+	}
+	if fixedHeight > 0 {
+		height = fixedHeight
+	}
+	return width, height
+}
+
+// SetTrim enables trimming of transparent borders. Pixels with alpha <= threshold are transparent
+func (sc *SemibranCollage) SetTrim(trim bool, threshold uint8) {
+	sc.trim = trim
+	sc.trimThreshold = threshold
+}
+
+// AddTrimmedRect adds the rect of a trimmed image. width and height are the trimmed size
+func (sc *SemibranCollage) AddTrimmedRect(name string, width, height int64, trim Trim) error {
+	if err := sc.checkScaleSize(name, trim.X, trim.Y, trim.SourceWidth, trim.SourceHeight); err != nil {
+		return err
+	}
+	if err := sc.AddRect(name, width, height); err != nil {
+		return err
+	}
+	sc.trims[name] = trim
+	return nil
+}
+
+// SetDedup enables detection of duplicate images in AddImageFile. Duplicates are not packed
+// but added as alias of the first image. maxDistance is the maximum hamming distance of the
+// perceptual hashes for DedupPerceptual
+func (sc *SemibranCollage) SetDedup(mode DedupMode, maxDistance int) {
+	sc.dedup = mode
+	sc.dedupDistance = maxDistance
+}
+
+// AddAlias adds alias as second name for the already added rect name
+func (sc *SemibranCollage) AddAlias(alias, name string) error {
+	if sc.names[alias] {
+		return errors.New(fmt.Sprintf("rectangle with name %s already added", alias))
+	}
+	if original, ok := sc.aliases[name]; ok {
+		name = original
+	}
+	if !sc.names[name] {
+		return errors.New(fmt.Sprintf("cannot add alias %s: rectangle %s not found", alias, name))
+	}
+	sc.names[alias] = true
+	sc.aliases[alias] = name
+	return nil
+}
+
+// findDuplicate returns the name of an already added image with the same content as region r of img
+// if there is no duplicate, the image is registered under name
+func (sc *SemibranCollage) findDuplicate(name string, img image.Image, r image.Rectangle, trim Trim) (string, bool) {
+	var hash = dedupHash(img, r, trim)
+	if original, ok := sc.hashes[hash]; ok {
+		return original, true
+	}
+	sc.hashes[hash] = name
+	if sc.dedup != DedupPerceptual {
+		return "", false
+	}
+	// perceptual duplicates need the same size to share a rect
+	var key = fmt.Sprintf("%s/%vx%v", trimKey(trim), r.Dx(), r.Dy())
+	var pHash = PerceptualHash(img, r)
+	for _, p := range sc.phashes[key] {
+		if HammingDistance(p.hash, pHash) <= sc.dedupDistance {
+			return p.name, true
 		}
 	}
+	sc.phashes[key] = append(sc.phashes[key], phash{name: name, hash: pHash})
+	return "", false
+}
+
+func (sc *SemibranCollage) AddRect(name string, width, height int64) error {
+	if sc.names[name] {
+		return errors.New(fmt.Sprintf("rectangle with name %s already added", name))
+	}
+	if err := sc.checkScaleSize(name, width, height); err != nil {
+		return err
+	}
+	sc.names[name] = true
 	sc.rects = append(sc.rects, Rect{
 		Name:   name,
 		X:      0,
 		Y:      0,
-		Width:  width + 2*sc.border + 2*sc.margin,
-		Height: height + 2*sc.border + 2*sc.margin,
+		Width:  width + 2*sc.gutter(),
+		Height: height + 2*sc.gutter(),
 	})
 	return nil
 }
 
 func (sc *SemibranCollage) AddImageFile(path string) error {
 	path = filepath.ToSlash(filepath.Clean(path))
-	fullpath := filepath.Join(sc.basePath, path)
-	img, err := getImageFromFilePath(fullpath)
+	fullpath := fsName(path)
+	if sc.animation != AnimationNone {
+		anim, err := loadAnimation(sc.fsys, fullpath)
+		if err != nil {
+			return errors.Wrapf(err, "cannot open image %s", fullpath)
+		}
+		if anim != nil && len(anim.Frames) > 1 {
+			return sc.addAnimation(path, anim)
+		}
+	}
+	if isTIFF(path) {
+		pages, err := LoadTIFFPages(sc.fsys, fullpath)
+		if err != nil {
+			return errors.Wrapf(err, "cannot open image %s", fullpath)
+		}
+		// every page of a multi-page tiff is an image <path>/page-0001.png
+		if len(pages) > 1 {
+			for i, page := range pages {
+				var name = PagePath(path, i)
+				if err := sc.addImage(name, page); err != nil {
+					return err
+				}
+				sc.pages[name] = frameRef{source: path, index: i}
+			}
+			return nil
+		}
+	}
+	img, info, err := sc.decodeFile(fullpath)
 	if err != nil {
 		return errors.Wrapf(err, "cannot open image %s", fullpath)
 	}
-	return sc.AddRect(path, int64(img.Bounds().Dx()), int64(img.Bounds().Dy()))
+	if err := sc.addImage(path, img); err != nil {
+		return err
+	}
+	if info != nil {
+		sc.sourceInfos[path] = info
+	}
+	return nil
+}
+
+// addImage adds the rect of img with trimming and duplicate detection
+func (sc *SemibranCollage) addImage(path string, img image.Image) error {
+	var bounds = img.Bounds()
+	var trim = Trim{}
+	if sc.trim {
+		bounds = sc.alignTrim(TrimBounds(img, sc.trimThreshold), img.Bounds().Dx(), img.Bounds().Dy())
+		trim = Trim{
+			X:            int64(bounds.Min.X),
+			Y:            int64(bounds.Min.Y),
+			SourceWidth:  int64(img.Bounds().Dx()),
+			SourceHeight: int64(img.Bounds().Dy()),
+		}
+		bounds = bounds.Add(img.Bounds().Min)
+	}
+	if sc.dedup != DedupNone && !sc.names[path] {
+		if original, ok := sc.findDuplicate(path, img, bounds, trim); ok {
+			return sc.AddAlias(path, original)
+		}
+	}
+	if sc.trim {
+		return sc.AddTrimmedRect(path, int64(bounds.Dx()), int64(bounds.Dy()), trim)
+	}
+	return sc.AddRect(path, int64(bounds.Dx()), int64(bounds.Dy()))
 }
 
 func (sc *SemibranCollage) Pack() (Layout, error) {
-	layout := pack(sc.rects)
+	var seed = []Rect{}
+	for _, rect := range sc.seed {
+		seed = append(seed, rect)
+	}
+	// sort by name, so that the result does not depend on the order of AddRect calls
+	var rects = make([]Rect, len(sc.rects))
+	copy(rects, sc.rects)
+	sort.SliceStable(rects, func(i, j int) bool { return rects[i].Name < rects[j].Name })
+	// the rects of the layout are in the chosen order, the placement order depends on size and priority
+	sc.order.sortRects(sc.fsys, rects, sc.sourcePath)
+	var opts = packOptions{
+		align:      sc.alignment(),
+		pins:       sc.pins,
+		priorities: sc.priorities,
+		groups:     sc.rectGroups(),
+	}
+	var fixedWidth, fixedHeight = sc.fixedSize()
+	if fixedWidth > 0 {
+		opts.maxWidth = fixedWidth - sc.left() - sc.marginRight
+	}
+	if fixedHeight > 0 {
+		opts.maxHeight = fixedHeight - sc.top() - sc.marginBottom
+	}
+	if (fixedWidth > 0 && opts.maxWidth <= 0) || (fixedHeight > 0 && opts.maxHeight <= 0) {
+		return Layout{}, errors.New(fmt.Sprintf("canvas %vx%v too small for margins", fixedWidth, fixedHeight))
+	}
+	if err := sc.checkPins(opts); err != nil {
+		return Layout{}, err
+	}
+	layout, unplaced := pack(rects, seed, opts)
+	if len(unplaced) > 0 {
+		var names = []string{}
+		for _, rect := range unplaced {
+			names = append(names, rect.Name)
+		}
+		return Layout{}, errors.New(fmt.Sprintf("%v rects do not fit into %vx%v: %s",
+			len(unplaced), fixedWidth, fixedHeight, strings.Join(names, ", ")))
+	}
+	// the layout size is the canvas size without margins
+	width, height := sc.canvasSize(layout.Width+sc.left()+sc.marginRight, layout.Height+sc.top()+sc.marginBottom)
+	// the size of the collage image has to be scaled exactly
+	if unit := sc.scaleAlign(); width%unit != 0 || height%unit != 0 {
+		if (fixedWidth > 0 && width%unit != 0) || (fixedHeight > 0 && height%unit != 0) {
+			return Layout{}, errors.New(fmt.Sprintf("canvas %vx%v is not a multiple of %v", width, height, unit))
+		}
+		width, height = alignUp(width, unit), alignUp(height, unit)
+	}
+	layout.Width = width - sc.left() - sc.marginRight
+	layout.Height = height - sc.top() - sc.marginBottom
 	return layout, nil
 }
 
-func (sc *SemibranCollage) CreateImage(layout Layout, dirName string) (image.Image, error) {
-	upLeft := image.Point{X: 0, Y: 0}
-	lowRight := image.Point{
-		X: int(layout.Width + sc.marginLeft + sc.marginRight),
-		Y: int(layout.Height + sc.marginTop + sc.marginBottom),
+// outerRect returns the position of the packed rect within the collage image
+func (sc *SemibranCollage) outerRect(rect Rect) image.Rectangle {
+	return image.Rect(
+		int(rect.X+sc.left()),
+		int(rect.Y+sc.top()),
+		int(rect.X+sc.left()+rect.Width),
+		int(rect.Y+sc.top()+rect.Height),
+	)
+}
+
+// renderRect creates the content of a packed rect including border and extrusion.
+// The bounds of the result are the coordinates of the rect within the collage image
+func (sc *SemibranCollage) renderRect(rect Rect) (draw.Image, error) {
+	var outer = sc.outerRect(rect)
+	var tile draw.Image
+	if sc.deep {
+		tile = image.NewNRGBA64(outer)
+	} else {
+		tile = image.NewNRGBA(outer)
+	}
+	if seed, ok := sc.seed[rect.Name]; ok && sc.seedImage != nil && seed == rect {
+		PictureFS.CopyImage(tile, outer.Min, sc.seedImage, outer)
+		return tile, nil
+	}
+	src, err := sc.loadImage(rect.Name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot open image %s", rect.Name)
 	}
-	collImg := image.NewNRGBA(image.Rectangle{Min: upLeft, Max: lowRight})
-	//fmt.Printf("target: %v\n", collImg.Rect)
+	var srcRect = src.Bounds()
+	if trim, ok := sc.trims[rect.Name]; ok {
+		srcRect = image.Rect(
+			int(trim.X),
+			int(trim.Y),
+			int(trim.X+rect.Width-2*sc.gutter()),
+			int(trim.Y+rect.Height-2*sc.gutter()),
+		).Add(src.Bounds().Min)
+	}
+	if sc.border > 0 {
+		DrawRect(
+			int(rect.X+sc.left()+sc.margin),
+			int(rect.Y+sc.top()+sc.margin),
+			int(rect.X+sc.left()+sc.margin+int64(srcRect.Dx())+2*sc.border+2*sc.inner()-1),
+			int(rect.Y+sc.top()+sc.margin+int64(srcRect.Dy())+2*sc.border+2*sc.inner()-1),
+			int(sc.border), color.Black, tile)
+	}
+	var content = image.Rectangle{
+		Min: image.Point{
+			X: int(rect.X + sc.left() + sc.gutter()),
+			Y: int(rect.Y + sc.top() + sc.gutter()),
+		},
+	}
+	content.Max = content.Min.Add(srcRect.Size())
+	PictureFS.CopyImage(tile, content.Min, src, srcRect)
+	if sc.extrude > 0 {
+		Extrude(tile, content, int(sc.extrude))
+	}
+	return tile, nil
+}
+
+// ImageSize returns the size of the collage image of layout
+func (sc *SemibranCollage) ImageSize(layout Layout) image.Rectangle {
+	return image.Rect(
+		0,
+		0,
+		int(layout.Width+sc.left()+sc.marginRight),
+		int(layout.Height+sc.top()+sc.marginBottom),
+	)
+}
+
+func (sc *SemibranCollage) CreateImage(layout Layout) (image.Image, error) {
+	if sc.deep {
+		collImg := image.NewNRGBA64(sc.ImageSize(layout))
+		for _, rect := range layout.Rects {
+			tile, err := sc.renderRect(rect)
+			if err != nil {
+				return nil, err
+			}
+			draw.Draw(collImg, tile.Bounds(), tile, tile.Bounds().Min, draw.Src)
+		}
+		return ConvertImage64(collImg, sc.colorMode, sc.maxColors), nil
+	}
+	collImg := image.NewNRGBA(sc.ImageSize(layout))
 	for _, rect := range layout.Rects {
-		src, err := getImageFromFilePath(filepath.Join(dirName, rect.Name))
+		tile, err := sc.renderRect(rect)
 		if err != nil {
-			return nil, errors.Wrapf(err, "cannot open image %s", rect.Name)
-		}
-		if sc.border > 0 {
-			DrawRect(
-				int(rect.X+sc.marginLeft+sc.margin),
-				int(rect.Y+sc.marginTop+sc.margin),
-				int(rect.X+sc.marginLeft+sc.margin+int64(src.Bounds().Dx())+2*sc.border-1),
-				int(rect.Y+sc.marginTop+sc.margin+int64(src.Bounds().Dy())+2*sc.border-1),
-				int(sc.border), color.Black, collImg)
-		}
-		draw.Copy(
-			collImg,
-			image.Point{
-				X: int(rect.X + sc.marginLeft + sc.margin + sc.border),
-				Y: int(rect.Y + sc.marginTop + sc.margin + sc.border),
-			},
-			src,
-			src.Bounds(),
-			draw.Over,
-			nil,
-		)
-	}
-	return collImg, nil
+			return nil, err
+		}
+		draw.Draw(collImg, tile.Bounds(), tile, tile.Bounds().Min, draw.Src)
+	}
+	return ConvertImage(collImg, sc.colorMode, sc.maxColors), nil
 }
 
 func (sc *SemibranCollage) CreateLayout(layout Layout) (*PictureFS.Layout, error) {
@@ -138,26 +564,58 @@ func (sc *SemibranCollage) CreateLayout(layout Layout) (*PictureFS.Layout, error
 		Images:  []PictureFS.Rect{},
 	}
 
+	var aliases = map[string][]string{}
+	for alias, name := range sc.aliases {
+		aliases[name] = append(aliases[name], alias)
+	}
 	for _, rect := range layout.Rects {
-		result.Images = append(result.Images, PictureFS.Rect{
+		var pRect = PictureFS.Rect{
 			Path:   rect.Name,
-			X:      int(rect.X + sc.border + sc.margin + sc.marginLeft),
-			Y:      int(rect.Y + sc.border + sc.margin + sc.marginTop),
-			Width:  int(rect.Width - 2*sc.border - 2*sc.margin),
-			Height: int(rect.Height - 2*sc.border - 2*sc.margin),
-		})
-		if strings.ToLower(filepath.Ext(rect.Name)) == ".gif" {
-			result.Images = append(result.Images, PictureFS.Rect{
-				Path:   strings.ReplaceAll(rect.Name, ".gif", ".png"),
-				X:      int(rect.X + sc.border + sc.margin + sc.marginLeft),
-				Y:      int(rect.Y + sc.border + sc.margin + sc.marginTop),
-				Width:  int(rect.Width - 2*sc.border - 2*sc.margin),
-				Height: int(rect.Height - 2*sc.border - 2*sc.margin),
-			})
+			X:      int(rect.X + sc.gutter() + sc.left()),
+			Y:      int(rect.Y + sc.gutter() + sc.top()),
+			Width:  int(rect.Width - 2*sc.gutter()),
+			Height: int(rect.Height - 2*sc.gutter()),
+		}
+		if trim, ok := sc.trims[rect.Name]; ok {
+			pRect.TrimX = int(trim.X)
+			pRect.TrimY = int(trim.Y)
+			pRect.SourceWidth = int(trim.SourceWidth)
+			pRect.SourceHeight = int(trim.SourceHeight)
+		}
+		// aliases share the coordinates of the original image
+		var names = aliases[rect.Name]
+		sort.Strings(names)
+		for _, name := range append([]string{rect.Name}, names...) {
+			pRect.Path = name
+			pRect.Source = sc.sourceInfos[name]
+			result.Images = append(result.Images, pRect)
+			if strings.ToLower(filepath.Ext(name)) == ".gif" {
+				pRect.Path = pngAlias(name)
+				result.Images = append(result.Images, pRect)
+			}
+		}
+	}
 
+	if len(sc.scales) > 0 {
+		result.Scale = int(sc.maxScale())
+		for _, scale := range sc.scales {
+			result.Scales = append(result.Scales, int(scale))
 		}
 	}
 
+	if sc.colorSpace != nil {
+		result.ICCProfile = sc.colorSpace.Data
+	}
+
+	if len(sc.animations) > 0 {
+		result.Animations = sc.createAnimations()
+	}
+
+	if sc.uvOrigin != "" {
+		var size = sc.ImageSize(layout)
+		result.UV = sc.createUV(result, size.Dx(), size.Dy())
+	}
+
 	return result, nil
 }
 
@@ -19,6 +19,34 @@ type Size struct {
 	width, height int64
 }
 
+// constraints for the packer
+// align: all rect coordinates are multiples of align
+// maxWidth, maxHeight: size of the packing area (0: unbounded)
+// pins: fixed positions of rects by name
+// priorities: rects with higher priority are placed before larger rects (default: 0)
+// groups: rects of the same group are packed into one contiguous region
+type packOptions struct {
+	align               int64
+	maxWidth, maxHeight int64
+	pins                map[string]Position
+	priorities          map[string]int
+	groups              map[string]string
+}
+
+// rounds `v` up to the next multiple of `align`
+func alignUp(v, align int64) int64 {
+	if align <= 1 {
+		return v
+	}
+	return (v + align - 1) / align * align
+}
+
+// determines if `rect` lies within the packing area
+func fits(rect Rect, opts packOptions) bool {
+	return (opts.maxWidth <= 0 || rect.X+rect.Width <= opts.maxWidth) &&
+		(opts.maxHeight <= 0 || rect.Y+rect.Height <= opts.maxHeight)
+}
+
 func max(a, b int64) int64 {
 	if a > b {
 		return a
@@ -82,20 +110,21 @@ func findBounds(rects []Rect) Size {
 }
 
 // find all rect positions given a rect list
-func findPositions(rects []Rect) []Position {
+// positions are aligned to multiples of `align`
+func findPositions(rects []Rect, align int64) []Position {
 	var positions = []Position{}
 	for i := 0; i < len(rects); i++ {
 		var rect = rects[i]
 		for x := int64(0); x < rect.Width; x++ {
 			positions = append(positions, Position{
-				x: rect.X + x,
-				y: rect.Y + rect.Height,
+				x: alignUp(rect.X+x, align),
+				y: alignUp(rect.Y+rect.Height, align),
 			})
 		}
 		for y := int64(0); y < rect.Height; y++ {
 			positions = append(positions, Position{
-				x: rect.X + rect.Width,
-				y: rect.Y + y,
+				x: alignUp(rect.X+rect.Width, align),
+				y: alignUp(rect.Y+y, align),
 			})
 		}
 	}
@@ -103,7 +132,8 @@ func findPositions(rects []Rect) []Position {
 }
 
 // finds the best location for a { Width, Height } tuple within the given layout
-func findBestRect(layout Layout, size Rect) Rect {
+// returns false, if there is no valid location within the packing area
+func findBestRect(layout Layout, size Rect, opts packOptions) (Rect, bool) {
 	var bestRect = Rect{
 		X:      0,
 		Y:      0,
@@ -112,7 +142,7 @@ func findBestRect(layout Layout, size Rect) Rect {
 	}
 
 	if len(layout.Rects) <= 0 {
-		return bestRect
+		return bestRect, fits(bestRect, opts)
 	}
 
 	var rect = Rect{
@@ -129,12 +159,14 @@ func findBestRect(layout Layout, size Rect) Rect {
 	}
 
 	var bestScore int64 = math.MaxInt64
-	var positions = findPositions(layout.Rects)
+	var found = false
+	// the origin is free, if the rects are pinned or seeded elsewhere
+	var positions = append([]Position{{}}, findPositions(layout.Rects, opts.align)...)
 	for i := 0; i < len(positions); i++ {
 		var pos = positions[i]
 		rect.X = pos.x
 		rect.Y = pos.y
-		if validate(layout.Rects, rect) {
+		if fits(rect, opts) && validate(layout.Rects, rect) {
 			if len(layout.Rects) >= len(sandbox.Rects) {
 				sandbox.Rects = append(sandbox.Rects, rect)
 			} else {
@@ -150,41 +182,43 @@ func findBestRect(layout Layout, size Rect) Rect {
 				bestScore = score
 				bestRect.X = rect.X
 				bestRect.Y = rect.Y
+				found = true
 			}
 		}
 	}
 
-	return bestRect
+	return bestRect, found
 }
 
 // determine order of iteration (FFD)
-func preorder(sizes []Rect) []int {
+// Rects are sorted by priority and area descending, ties are broken by name to get a reproducible order.
+// priorities contains the priority of every rect of sizes (nil: all 0)
+func preorder(sizes []Rect, priorities []int) []int {
 	var order = make([]int, len(sizes))
 	for i := 0; i < len(sizes); i++ {
 		order[i] = i
 	}
 
-	// sort Rects by area descending
-
-	sort.Slice(order, func(a, b int) bool {
-		return sizes[b].Width*sizes[b].Height-sizes[a].Width*sizes[a].Height < 0
+	sort.SliceStable(order, func(a, b int) bool {
+		if priorities != nil && priorities[order[a]] != priorities[order[b]] {
+			return priorities[order[a]] > priorities[order[b]]
+		}
+		var sa, sb = sizes[order[a]], sizes[order[b]]
+		var areaA, areaB = sa.Width * sa.Height, sb.Width * sb.Height
+		if areaA != areaB {
+			return areaA > areaB
+		}
+		return sa.Name < sb.Name
 	})
 
 	return order
 }
 
-// rearrange Rects to reflect the given iteration order
-func reorder(items []Rect, order []int) []Rect {
-	for i := 0; i < len(items); i++ {
-		var tmp = items[order[i]]
-		items[order[i]] = items[i]
-		items[i] = tmp
-	}
-	return items
-}
-
 // packs { Width, Height } tuples into a layout { Width, Height, Rects }
-func pack(sizes []Rect) Layout {
+// pinned rects are placed first, rects of `seed` keep their position if name and size are unchanged.
+// the resulting Rects are in the order of `sizes`, rects which do not fit into the
+// packing area are returned separately
+func pack(sizes []Rect, seed []Rect, opts packOptions) (Layout, []Rect) {
 	var layout = Layout{
 		Width:  0,
 		Height: 0,
@@ -192,22 +226,143 @@ func pack(sizes []Rect) Layout {
 	}
 
 	if len(sizes) <= 0 {
-		return layout
+		return layout, nil
 	}
 
-	var order = preorder(sizes)
-	for i := 0; i < len(sizes); i++ {
-		var size = sizes[order[i]]
+	var seeds = map[string]Rect{}
+	for _, rect := range seed {
+		seeds[rect.Name] = rect
+	}
 
-		var rect = findBestRect(layout, size)
-		rect.Name = size.Name
+	var placed = make([]Rect, len(sizes))
+	var done = make([]bool, len(sizes))
+	var unplaced = []Rect{}
+	for i := 0; i < len(sizes); i++ {
+		var size = sizes[i]
+		pin, ok := opts.pins[size.Name]
+		if !ok {
+			continue
+		}
+		var rect = Rect{Name: size.Name, X: pin.x, Y: pin.y, Width: size.Width, Height: size.Height}
+		if !fits(rect, opts) || !validate(layout.Rects, rect) {
+			unplaced = append(unplaced, size)
+			continue
+		}
+		layout.Rects = append(layout.Rects, rect)
+		placed[i] = rect
+		done[i] = true
+	}
+	for i := 0; i < len(sizes); i++ {
+		var size = sizes[i]
+		rect, ok := seeds[size.Name]
+		if _, pinned := opts.pins[size.Name]; pinned || opts.groups[size.Name] != "" {
+			continue
+		}
+		if !ok || rect.Width != size.Width || rect.Height != size.Height {
+			continue
+		}
+		if rect.X%max(opts.align, 1) != 0 || rect.Y%max(opts.align, 1) != 0 {
+			continue
+		}
+		if !fits(rect, opts) || !validate(layout.Rects, rect) {
+			continue
+		}
 		layout.Rects = append(layout.Rects, rect)
+		placed[i] = rect
+		done[i] = true
+	}
 
-		var bounds = findBounds(layout.Rects)
-		layout.Width = bounds.width
-		layout.Height = bounds.height
+	// every group is packed separately and placed as a single rect
+	var items = []Rect{}
+	var priorities = []int{}
+	var members = [][]int{}
+	var groupItems = map[string]int{}
+	for i := 0; i < len(sizes); i++ {
+		if _, pinned := opts.pins[sizes[i].Name]; pinned || done[i] {
+			continue
+		}
+		var group = opts.groups[sizes[i].Name]
+		if group == "" {
+			items = append(items, sizes[i])
+			priorities = append(priorities, opts.priorities[sizes[i].Name])
+			members = append(members, []int{i})
+			continue
+		}
+		if _, ok := groupItems[group]; !ok {
+			groupItems[group] = len(items)
+			items = append(items, Rect{Name: group})
+			priorities = append(priorities, math.MinInt32)
+			members = append(members, []int{})
+		}
+		var item = groupItems[group]
+		members[item] = append(members[item], i)
+		if p := opts.priorities[sizes[i].Name]; p > priorities[item] {
+			priorities[item] = p
+		}
+	}
+	// positions of the group members relative to the group
+	var offsets = make([][]Rect, len(items))
+	for _, item := range groupItems {
+		var groupSizes = []Rect{}
+		for _, i := range members[item] {
+			groupSizes = append(groupSizes, sizes[i])
+		}
+		group, groupUnplaced := pack(groupSizes, nil, packOptions{
+			align:      opts.align,
+			maxWidth:   opts.maxWidth,
+			maxHeight:  opts.maxHeight,
+			priorities: opts.priorities,
+		})
+		if len(groupUnplaced) > 0 {
+			unplaced = append(unplaced, groupSizes...)
+			members[item] = nil
+			continue
+		}
+		items[item].Width = group.Width
+		items[item].Height = group.Height
+		offsets[item] = group.Rects
 	}
 
-	reorder(layout.Rects, order)
-	return layout
+	var order = preorder(items, priorities)
+	for i := 0; i < len(items); i++ {
+		var item = order[i]
+		if len(members[item]) == 0 {
+			continue
+		}
+		var size = items[item]
+
+		rect, ok := findBestRect(layout, size, opts)
+		if !ok {
+			for _, j := range members[item] {
+				unplaced = append(unplaced, sizes[j])
+			}
+			continue
+		}
+		if offsets[item] == nil {
+			rect.Name = size.Name
+			layout.Rects = append(layout.Rects, rect)
+			placed[members[item][0]] = rect
+			done[members[item][0]] = true
+			continue
+		}
+		for k, j := range members[item] {
+			var member = offsets[item][k]
+			member.X += rect.X
+			member.Y += rect.Y
+			layout.Rects = append(layout.Rects, member)
+			placed[j] = member
+			done[j] = true
+		}
+	}
+
+	var bounds = findBounds(layout.Rects)
+	layout.Width = bounds.width
+	layout.Height = bounds.height
+	layout.Rects = []Rect{}
+	for i, rect := range placed {
+		if done[i] {
+			layout.Rects = append(layout.Rects, rect)
+		}
+	}
+	return layout, unplaced
 }
@@ -0,0 +1,206 @@
+package imagecollage
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/je4/PictureFS/v2/pkg/PictureFS"
+	"github.com/pkg/errors"
+	"github.com/srwiley/oksvg"
+	"github.com/srwiley/rasterx"
+	"image"
+	"image/png"
+	"io/fs"
+	"math"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// resolution of css pixels, which is the unit of svg and the base of the dpi scaling
+const baseDPI = 96
+
+// Rasterization defines the size of rasterized vector images. If Width or Height is set, the
+// image is scaled to fit into Width x Height (keeping the aspect ratio) and DPI is ignored
+type Rasterization struct {
+	DPI           float64
+	Width, Height int
+}
+
+// size returns the raster size of a vector image with the natural size w x h at 96 dpi
+func (r Rasterization) size(w, h float64) (int, int) {
+	var scale = 1.0
+	switch {
+	case r.Width > 0 && r.Height > 0:
+		scale = math.Min(float64(r.Width)/w, float64(r.Height)/h)
+	case r.Width > 0:
+		scale = float64(r.Width) / w
+	case r.Height > 0:
+		scale = float64(r.Height) / h
+	case r.DPI > 0:
+		scale = r.DPI / baseDPI
+	}
+	return int(math.Max(1, math.Round(w*scale))), int(math.Max(1, math.Round(h*scale)))
+}
+
+// info returns the layout description of the rasterization
+func (r Rasterization) info(format string) *PictureFS.SourceInfo {
+	var result = &PictureFS.SourceInfo{Format: format}
+	if r.Width > 0 || r.Height > 0 {
+		result.Width, result.Height = r.Width, r.Height
+	} else if r.DPI > 0 {
+		result.DPI = r.DPI
+	}
+	return result
+}
+
+// RasterizationFromInfo returns the rasterization parameters recorded in the layout
+func RasterizationFromInfo(info *PictureFS.SourceInfo) Rasterization {
+	return Rasterization{DPI: info.DPI, Width: info.Width, Height: info.Height}
+}
+
+// Source reads input files, which cannot be decoded with image.Decode
+type Source interface {
+	// Format returns the name of the source format, which is recorded in the layout
+	Format() string
+	// Match returns true, if the source reads the file path
+	Match(path string) bool
+	// Load rasterizes the file of fsys with the parameters of r
+	Load(fsys fs.FS, filePath string, r Rasterization) (image.Image, error)
+}
+
+// DefaultSources returns the sources of NewSemibranCollage. PDFSource needs an external
+// command and has to be added with AddSource
+func DefaultSources() []Source {
+	return []Source{&SVGSource{}}
+}
+
+// FindSource returns the source of format
+func FindSource(sources []Source, format string) (Source, bool) {
+	for _, source := range sources {
+		if source.Format() == format {
+			return source, true
+		}
+	}
+	return nil, false
+}
+
+// SVGSource rasterizes svg images. The natural size is the view box (or width/height) in css pixels
+type SVGSource struct{}
+
+func (*SVGSource) Format() string {
+	return "svg"
+}
+
+func (*SVGSource) Match(path string) bool {
+	return strings.ToLower(filepath.Ext(path)) == ".svg"
+}
+
+func (*SVGSource) Load(fsys fs.FS, filePath string, r Rasterization) (image.Image, error) {
+	f, err := fsys.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	icon, err := oksvg.ReadIconStream(f, oksvg.IgnoreErrorMode)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot parse svg %s", filePath)
+	}
+	if icon.ViewBox.W <= 0 || icon.ViewBox.H <= 0 {
+		return nil, errors.New(fmt.Sprintf("svg %s has no size", filePath))
+	}
+	var w, h = r.size(icon.ViewBox.W, icon.ViewBox.H)
+	var img = image.NewRGBA(image.Rect(0, 0, w, h))
+	icon.SetTarget(0, 0, float64(w), float64(h))
+	var scanner = rasterx.NewScannerGV(w, h, img, img.Bounds())
+	icon.Draw(rasterx.NewDasher(w, h, scanner), 1)
+	return img, nil
+}
+
+// PDFSource rasterizes the first page of pdf files with the external command pdftoppm (poppler),
+// which has to be installed. It is not one of the DefaultSources, use AddSource(&PDFSource{}).
+// The file is passed on stdin, so it does not have to be a local file
+type PDFSource struct {
+	// Command is the path of pdftoppm (default: pdftoppm from PATH)
+	Command string
+}
+
+func (*PDFSource) Format() string {
+	return "pdf"
+}
+
+func (*PDFSource) Match(path string) bool {
+	return strings.ToLower(filepath.Ext(path)) == ".pdf"
+}
+
+func (ps *PDFSource) Load(fsys fs.FS, filePath string, r Rasterization) (image.Image, error) {
+	data, err := fs.ReadFile(fsys, filePath)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case r.Width > 0 && r.Height > 0:
+		// fit into the box: scale to the width and to the height, if the page is too high
+		img, err := ps.rasterize(filePath, data, "-scale-to-x", fmt.Sprint(r.Width), "-scale-to-y", "-1")
+		if err != nil || img.Bounds().Dy() <= r.Height {
+			return img, err
+		}
+		return ps.rasterize(filePath, data, "-scale-to-x", "-1", "-scale-to-y", fmt.Sprint(r.Height))
+	case r.Width > 0:
+		return ps.rasterize(filePath, data, "-scale-to-x", fmt.Sprint(r.Width), "-scale-to-y", "-1")
+	case r.Height > 0:
+		return ps.rasterize(filePath, data, "-scale-to-x", "-1", "-scale-to-y", fmt.Sprint(r.Height))
+	case r.DPI > 0:
+		return ps.rasterize(filePath, data, "-r", fmt.Sprint(r.DPI))
+	default:
+		return ps.rasterize(filePath, data, "-r", fmt.Sprint(baseDPI))
+	}
+}
+
+// rasterize renders the first page of the pdf data with the size options args
+func (ps *PDFSource) rasterize(filePath string, data []byte, args ...string) (image.Image, error) {
+	var command = ps.Command
+	if command == "" {
+		command = "pdftoppm"
+	}
+	args = append([]string{"-png", "-singlefile", "-f", "1", "-l", "1"}, args...)
+	var stdout, stderr = &bytes.Buffer{}, &bytes.Buffer{}
+	var cmd = exec.Command(command, append(args, "-")...)
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		return nil, errors.Wrapf(err, "cannot rasterize pdf %s: %s", filePath, strings.TrimSpace(stderr.String()))
+	}
+	img, err := png.Decode(stdout)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot decode rasterized pdf %s", filePath)
+	}
+	return img, nil
+}
+
+// AddSource adds a source for input files, which cannot be decoded with image.Decode.
+// Sources added later take precedence
+func (sc *SemibranCollage) AddSource(source Source) {
+	sc.sources = append([]Source{source}, sc.sources...)
+}
+
+// SetRasterization defines the size of rasterized vector sources
+func (sc *SemibranCollage) SetRasterization(r Rasterization) {
+	sc.rasterization = r
+}
+
+// decodeFile loads the image of a file with the matching source or image.Decode.
+// Rasterized files return the parameters of the rasterization
+func (sc *SemibranCollage) decodeFile(filePath string) (image.Image, *PictureFS.SourceInfo, error) {
+	for _, source := range sc.sources {
+		if source.Match(filePath) {
+			img, err := source.Load(sc.fsys, filePath, sc.rasterization)
+			if err != nil {
+				return nil, nil, err
+			}
+			return img, sc.rasterization.info(source.Format()), nil
+		}
+	}
+	img, err := getImageFromFilePath(sc.fsys, filePath)
+	return img, nil, err
+}
@@ -0,0 +1,121 @@
+package imagecollage
+
+import (
+	"golang.org/x/image/draw"
+	"image"
+	"image/color"
+	"io"
+	"sort"
+)
+
+// bandImage renders a collage in horizontal bands on demand.
+// Only the bands and the source images which overlap the current band are kept in memory.
+// The rows have to be read from top to bottom (like png.Encode does). Bands are always 8 bit
+type bandImage struct {
+	sc         *SemibranCollage
+	bounds     image.Rectangle
+	bandHeight int
+	rects      []Rect
+	next       int
+	active     []draw.Image
+	band       *image.NRGBA
+	err        error
+}
+
+func newBandImage(sc *SemibranCollage, layout Layout, bandHeight int) *bandImage {
+	var rects = make([]Rect, len(layout.Rects))
+	copy(rects, layout.Rects)
+	sort.SliceStable(rects, func(i, j int) bool { return rects[i].Y < rects[j].Y })
+	if bandHeight <= 0 {
+		bandHeight = 1
+	}
+	return &bandImage{
+		sc:         sc,
+		bounds:     sc.ImageSize(layout),
+		bandHeight: bandHeight,
+		rects:      rects,
+		active:     []draw.Image{},
+	}
+}
+
+func (bi *bandImage) ColorModel() color.Model {
+	return color.NRGBAModel
+}
+
+func (bi *bandImage) Bounds() image.Rectangle {
+	return bi.bounds
+}
+
+// Opaque prevents png.Encode from scanning the whole image in advance
+func (bi *bandImage) Opaque() bool {
+	return false
+}
+
+func (bi *bandImage) At(x, y int) color.Color {
+	if bi.err != nil || !(image.Point{X: x, Y: y}).In(bi.bounds) {
+		return color.NRGBA{}
+	}
+	if bi.band == nil || y < bi.band.Rect.Min.Y || y >= bi.band.Rect.Max.Y {
+		if err := bi.renderBand(y - (y-bi.bounds.Min.Y)%bi.bandHeight); err != nil {
+			bi.err = err
+			return color.NRGBA{}
+		}
+	}
+	return bi.band.NRGBAAt(x, y)
+}
+
+// renderBand creates the band starting at row y0
+func (bi *bandImage) renderBand(y0 int) error {
+	var y1 = y0 + bi.bandHeight
+	if y1 > bi.bounds.Max.Y {
+		y1 = bi.bounds.Max.Y
+	}
+	var bandRect = image.Rect(bi.bounds.Min.X, y0, bi.bounds.Max.X, y1)
+	if bi.band != nil && len(bi.band.Pix) == bandRect.Dx()*bandRect.Dy()*4 {
+		for i := range bi.band.Pix {
+			bi.band.Pix[i] = 0
+		}
+		bi.band.Rect = bandRect
+	} else {
+		bi.band = image.NewNRGBA(bandRect)
+	}
+
+	// forget all images above the band
+	var active = []draw.Image{}
+	for _, tile := range bi.active {
+		if tile.Bounds().Max.Y > y0 {
+			active = append(active, tile)
+		}
+	}
+	// load all images which start within the band
+	for ; bi.next < len(bi.rects); bi.next++ {
+		var rect = bi.rects[bi.next]
+		if bi.sc.outerRect(rect).Min.Y >= y1 {
+			break
+		}
+		tile, err := bi.sc.renderRect(rect)
+		if err != nil {
+			return err
+		}
+		active = append(active, tile)
+	}
+	bi.active = active
+
+	for _, tile := range bi.active {
+		var r = tile.Bounds().Intersect(bandRect)
+		if !r.Empty() {
+			draw.Draw(bi.band, r, tile, r.Min, draw.Src)
+		}
+	}
+	return nil
+}
+
+// EncodeImageBands writes the collage image as png without creating the whole image in memory.
+// The image is rendered in bands of bandHeight rows
+func (sc *SemibranCollage) EncodeImageBands(w io.Writer, layout Layout, bandHeight int) error {
+	var img = newBandImage(sc, layout, bandHeight)
+	if err := EncodePNG(w, img); err != nil {
+		return err
+	}
+	return img.err
+}
@@ -0,0 +1,174 @@
+package imagecollage
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"github.com/je4/PictureFS/v2/pkg/PictureFS"
+	"github.com/pkg/errors"
+	"golang.org/x/image/draw"
+	"image"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// writeTile encodes img as jpg or png file
+func writeTile(filename string, img image.Image, format string) error {
+	if err := os.MkdirAll(filepath.Dir(filename), 0777); err != nil {
+		return errors.Wrapf(err, "cannot create directory %s", filepath.Dir(filename))
+	}
+	f, err := os.Create(filename)
+	if err != nil {
+		return errors.Wrapf(err, "cannot create %s", filename)
+	}
+	defer f.Close()
+	switch format {
+	case "jpg", "jpeg":
+		err = jpeg.Encode(f, img, &jpeg.Options{Quality: 90})
+	case "png":
+		err = EncodePNG(f, img)
+	default:
+		return errors.New(fmt.Sprintf("invalid tile format %s", format))
+	}
+	if err != nil {
+		return errors.Wrapf(err, "cannot encode %s", filename)
+	}
+	return nil
+}
+
+// halve returns img scaled to half of its size (rounded up)
+func halve(img image.Image) image.Image {
+	var b = img.Bounds()
+	var result = image.NewNRGBA(image.Rect(0, 0, (b.Dx()+1)/2, (b.Dy()+1)/2))
+	draw.BiLinear.Scale(result, result.Bounds(), img, b, draw.Src, nil)
+	return result
+}
+
+// crop returns the region r of img with bounds starting at 0/0
+func crop(img image.Image, r image.Rectangle) *image.NRGBA {
+	var result = image.NewNRGBA(image.Rectangle{Max: r.Size()})
+	draw.Copy(result, image.Point{}, img, r, draw.Src, nil)
+	return result
+}
+
+type dziSize struct {
+	Width  int `xml:"Width,attr"`
+	Height int `xml:"Height,attr"`
+}
+
+type dziImage struct {
+	XMLName  xml.Name `xml:"http://schemas.microsoft.com/deepzoom/2008 Image"`
+	TileSize int      `xml:"TileSize,attr"`
+	Overlap  int      `xml:"Overlap,attr"`
+	Format   string   `xml:"Format,attr"`
+	Size     dziSize  `xml:"Size"`
+}
+
+// WriteDeepZoom exports img as Deep Zoom image <dir>/<name>.dzi with tiles in <dir>/<name>_files
+func WriteDeepZoom(img image.Image, dir, name string, tileSize, overlap int, format string) error {
+	if tileSize <= 0 {
+		return errors.New(fmt.Sprintf("invalid tile size %v", tileSize))
+	}
+	var b = img.Bounds()
+	var maxLevel = 0
+	for s := max(int64(b.Dx()), int64(b.Dy())); s > 1; s = (s + 1) / 2 {
+		maxLevel++
+	}
+	var level = img
+	for l := maxLevel; l >= 0; l-- {
+		var lb = level.Bounds()
+		for row := 0; row*tileSize < lb.Dy(); row++ {
+			for col := 0; col*tileSize < lb.Dx(); col++ {
+				var r = image.Rect(
+					col*tileSize-overlap,
+					row*tileSize-overlap,
+					(col+1)*tileSize+overlap,
+					(row+1)*tileSize+overlap,
+				).Add(lb.Min).Intersect(lb)
+				var filename = filepath.Join(dir, name+"_files", fmt.Sprintf("%d", l), fmt.Sprintf("%d_%d.%s", col, row, format))
+				if err := writeTile(filename, crop(level, r), format); err != nil {
+					return err
+				}
+			}
+		}
+		if l > 0 {
+			level = halve(level)
+		}
+	}
+	data, err := xml.MarshalIndent(dziImage{
+		TileSize: tileSize,
+		Overlap:  overlap,
+		Format:   format,
+		Size:     dziSize{Width: b.Dx(), Height: b.Dy()},
+	}, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "cannot marshal dzi")
+	}
+	var filename = filepath.Join(dir, name+".dzi")
+	if err := os.WriteFile(filename, append([]byte(xml.Header), data...), 0666); err != nil {
+		return errors.Wrapf(err, "cannot write %s", filename)
+	}
+	return nil
+}
+
+// WriteIIIFTiles exports img as static IIIF Image API 3.0 level 0 tile pyramid with info.json into dir.
+// id is the base uri of the image service, which serves the content of dir
+func WriteIIIFTiles(img image.Image, dir, id string, tileSize int, format string) error {
+	if tileSize <= 0 {
+		return errors.New(fmt.Sprintf("invalid tile size %v", tileSize))
+	}
+	var b = img.Bounds()
+	var info = PictureFS.NewIIIFImageInfo(strings.TrimRight(id, "/"), b.Dx(), b.Dy())
+	var tile = PictureFS.IIIFTile{
+		Width:        tileSize,
+		Height:       tileSize,
+		ScaleFactors: []int{},
+	}
+	var level = img
+	for sf := 1; ; sf *= 2 {
+		tile.ScaleFactors = append(tile.ScaleFactors, sf)
+		var lb = level.Bounds()
+		for y := 0; y < b.Dy(); y += tileSize * sf {
+			for x := 0; x < b.Dx(); x += tileSize * sf {
+				// region in full size coordinates
+				var region = image.Rect(x, y, x+tileSize*sf, y+tileSize*sf).Intersect(image.Rect(0, 0, b.Dx(), b.Dy()))
+				// region in scaled coordinates
+				var scaled = image.Rect(x/sf, y/sf, (x+region.Dx()+sf-1)/sf, (y+region.Dy()+sf-1)/sf).Add(lb.Min).Intersect(lb)
+				var filename = filepath.Join(
+					dir,
+					fmt.Sprintf("%d,%d,%d,%d", region.Min.X, region.Min.Y, region.Dx(), region.Dy()),
+					fmt.Sprintf("%d,%d", scaled.Dx(), scaled.Dy()),
+					"0",
+					"default."+format,
+				)
+				if err := writeTile(filename, crop(level, scaled), format); err != nil {
+					return err
+				}
+			}
+		}
+		if lb.Dx() <= tileSize && lb.Dy() <= tileSize {
+			// whole image fits into one tile
+			info.Sizes = append(info.Sizes, PictureFS.IIIFSize{Width: lb.Dx(), Height: lb.Dy()})
+			var filename = filepath.Join(dir, "full", fmt.Sprintf("%d,%d", lb.Dx(), lb.Dy()), "0", "default."+format)
+			if err := writeTile(filename, crop(level, lb), format); err != nil {
+				return err
+			}
+		}
+		if lb.Dx() <= 1 && lb.Dy() <= 1 || lb.Dx() <= tileSize && lb.Dy() <= tileSize {
+			break
+		}
+		level = halve(level)
+	}
+	info.Tiles = []PictureFS.IIIFTile{tile}
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "cannot marshal info.json")
+	}
+	var filename = filepath.Join(dir, "info.json")
+	if err := os.WriteFile(filename, data, 0666); err != nil {
+		return errors.Wrapf(err, "cannot write %s", filename)
+	}
+	return nil
+}
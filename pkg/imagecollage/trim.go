@@ -0,0 +1,38 @@
+package imagecollage
+
+import (
+	"image"
+)
+
+// Trim describes the position of a trimmed image within its source image
+type Trim struct {
+	X, Y                      int64
+	SourceWidth, SourceHeight int64
+}
+
+// TrimBounds returns the bounding box of all pixels with an alpha value greater than threshold.
+// The result is relative to img.Bounds().Min. Fully transparent images result in a 1x1 box
+func TrimBounds(img image.Image, threshold uint8) image.Rectangle {
+	var b = img.Bounds()
+	var result = image.Rectangle{}
+	var found = false
+	var limit = uint32(threshold) * 0x101
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if _, _, _, a := img.At(x, y).RGBA(); a <= limit {
+				continue
+			}
+			var p = image.Rect(x-b.Min.X, y-b.Min.Y, x-b.Min.X+1, y-b.Min.Y+1)
+			if !found {
+				result = p
+				found = true
+			} else {
+				result = result.Union(p)
+			}
+		}
+	}
+	if !found {
+		return image.Rect(0, 0, 1, 1)
+	}
+	return result
+}
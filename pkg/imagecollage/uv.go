@@ -0,0 +1,53 @@
+package imagecollage
+
+import (
+	"fmt"
+	"github.com/je4/PictureFS/v2/pkg/PictureFS"
+	"github.com/pkg/errors"
+)
+
+// SetUV enables the uv section of the layout with origin PictureFS.UVTopLeft or PictureFS.UVBottomLeft.
+// An empty origin disables the section. With halfTexel the coordinates are inset by half a texel
+func (sc *SemibranCollage) SetUV(origin string, halfTexel bool) error {
+	switch origin {
+	case "", PictureFS.UVTopLeft, PictureFS.UVBottomLeft:
+	default:
+		return errors.New(fmt.Sprintf("invalid uv origin %s", origin))
+	}
+	sc.uvOrigin = origin
+	sc.uvHalfTexel = halfTexel
+	return nil
+}
+
+// createUV computes the normalized coordinates of the image rects of layout for a canvas of width x height pixels
+func (sc *SemibranCollage) createUV(layout *PictureFS.Layout, width, height int) *PictureFS.UVLayout {
+	var result = &PictureFS.UVLayout{
+		Origin:    sc.uvOrigin,
+		Width:     width,
+		Height:    height,
+		HalfTexel: sc.uvHalfTexel,
+		Rects:     []PictureFS.UVRect{},
+	}
+	if width <= 0 || height <= 0 {
+		return result
+	}
+	var inset float64
+	if sc.uvHalfTexel {
+		inset = 0.5
+	}
+	for _, rect := range layout.Images {
+		var x0, x1 = float64(rect.X) + inset, float64(rect.X+rect.Width) - inset
+		var y0, y1 = float64(rect.Y) + inset, float64(rect.Y+rect.Height) - inset
+		if sc.uvOrigin == PictureFS.UVBottomLeft {
+			y0, y1 = float64(height)-y1, float64(height)-y0
+		}
+		result.Rects = append(result.Rects, PictureFS.UVRect{
+			Path: rect.Path,
+			U0:   x0 / float64(width),
+			V0:   y0 / float64(height),
+			U1:   x1 / float64(width),
+			V1:   y1 / float64(height),
+		})
+	}
+	return result
+}